@@ -4,7 +4,7 @@ associated methods based on one or more XML Schema.
 
 Usage:
 
-	xsdgen [-o file] [-ns xmlns] [-pkg name] [-r rule] file ...
+	xsdgen [-a] [-o file] [-ns xmlns] [-pkg name] [-r rule] file ...
 
 Given a set of XML files containing <xsd:schema> declarations,
 xsdgen will create a new self-contained Go source file containing
@@ -14,8 +14,13 @@ library.
 
 If the -ns flag is used, only types defined in schema with the specified
 target namespace will be declared in the Go source. The -ns flag may
-be used more than once. If -ns is not specified, types for all schema in
-all files will be declared.
+be used more than once, and may be a comma-separated list; the types of
+all of the namespaces are declared in one file. Types in other namespaces
+are used to resolve references, but are not declared. If -ns is not
+specified, types for all schema in all files will be declared. Types with
+the same name in different namespaces are prefixed with the last part of
+their namespace URI, so Address in http://example.com/billing becomes
+BillingAddress.
 
 The default package name and output file are "ws" and "xsdgen_output.go",
 and can be overriddent by the -pkg and -o flags, respectively. The xsdgen
@@ -33,6 +38,15 @@ For example, the rule
 will transform the identifier Array_Of_soapenc_boolean to booleanArray.
 All identifiers are passed through the defined substitution rules.
 
+If the -a flag is used, xsdgen only replaces the code between the lines
+
+	// xsdgen: begin generated code. DO NOT EDIT.
+	// xsdgen: end generated code.
+
+in the output file, so that hand-written code may be kept in the same
+file. If the output file does not exist, it is created with these
+lines.
+
 The xsdgen command may be used with the go generate command. Simply
 embed a comment in your go source like so:
 
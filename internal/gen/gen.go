@@ -15,6 +15,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // TypeDecl generates a type declaration with the given name.
@@ -94,7 +95,41 @@ func String(s string) *ast.BasicLit {
 // Public turns a string into a public (uppercase)
 // identifier.
 func Public(name string) *ast.Ident {
-	return ast.NewIdent(strings.Title(name))
+	return ast.NewIdent(PublicName(name))
+}
+
+// PublicName turns a string into the name of a public identifier.
+// Characters that may not appear in a Go identifier are removed, and
+// the letter following each of them is upper-cased, as is the first
+// letter. If the result does not start with an upper-case letter, as
+// when name starts with a digit or a letter of a script without case,
+// it is prefixed with X. PublicName returns the empty string if name
+// has no letters or digits.
+func PublicName(name string) string {
+	var ident []rune
+	upper := true
+	for _, r := range name {
+		switch {
+		case unicode.IsMark(r):
+			// Combining accents can't be part of an
+			// identifier, but don't start a new word.
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if upper {
+				r = unicode.ToUpper(r)
+				upper = false
+			}
+			ident = append(ident, r)
+		default:
+			upper = true
+		}
+	}
+	if len(ident) == 0 {
+		return ""
+	}
+	if !unicode.IsUpper(ident[0]) {
+		return "X" + string(ident)
+	}
+	return string(ident)
 }
 
 func constDecl(kind token.Token, args ...string) *ast.GenDecl {
@@ -184,7 +219,7 @@ func (fn *Function) Decl() (*ast.FuncDecl, error) {
 		return nil, errors.New("function name unset")
 	}
 	if len(fn.body) == 0 {
-		return nil, fmt.Errorf("function body for %s unset")
+		return nil, fmt.Errorf("function body for %s unset", fn.name)
 	}
 
 	if fn.godoc != "" {
@@ -0,0 +1,207 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Lines of context around each change in the report of DiffString.
+const diffContext = 2
+
+// DiffString parses two XML documents and reports how they differ,
+// or returns the empty string if they are equivalent. It is meant for
+// tests that check a document against the one they expect.
+//
+// The documents are compared in the canonical form returned by
+// Canonical. The report is in the style of a unified diff of the
+// canonical forms, with lines of a prefixed by - and lines of b by +.
+func DiffString(a, b []byte) (string, error) {
+	x, err := canonicalLines(a)
+	if err != nil {
+		return "", fmt.Errorf("first document: %v", err)
+	}
+	y, err := canonicalLines(b)
+	if err != nil {
+		return "", fmt.Errorf("second document: %v", err)
+	}
+	return diffLines(x, y), nil
+}
+
+func canonicalLines(doc []byte) ([]string, error) {
+	root, err := Parse(doc)
+	if err != nil {
+		return nil, err
+	}
+	data, err := Canonical(root)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// Canonical returns a canonical form of the element el, for comparing
+// documents or computing their digest. It has one line for each start
+// tag, end tag and piece of text, indented by depth. Names are
+// written with their namespace in braces, as in {urn:x}name, so that
+// the prefixes bound to namespaces do not matter, and neither do
+// namespace declarations, the order of attributes, comments, the way
+// characters are escaped, or white space around text. Text between
+// the children of an element is kept, so that mixed content is
+// compared too.
+func Canonical(el *Element) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := el.canonical(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (el *Element) canonical(w *bytes.Buffer, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	var attrs []string
+	for _, attr := range el.StartElement.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, " "+expandedName(attr.Name)+"="+strconv.Quote(attr.Value))
+	}
+	sort.Strings(attrs)
+	w.WriteString(indent + "<" + expandedName(el.Name) + strings.Join(attrs, "") + ">\n")
+
+	// The content holds the children as well as the text around
+	// them, so it is scanned for the text at the top level, and the
+	// children are written as they are reached.
+	var text bytes.Buffer
+	flush := func() {
+		if s := strings.TrimSpace(text.String()); s != "" {
+			w.WriteString(indent + "  " + strconv.Quote(s) + "\n")
+		}
+		text.Reset()
+	}
+	d := xml.NewDecoder(bytes.NewReader(el.Content))
+	child, level := 0, 0
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if level == 0 && child < len(el.Children) {
+				flush()
+				if err := el.Children[child].canonical(w, depth+1); err != nil {
+					return err
+				}
+				child++
+			}
+			level++
+		case xml.EndElement:
+			level--
+		case xml.CharData:
+			if level == 0 {
+				text.Write(tok)
+			}
+		}
+	}
+	flush()
+	w.WriteString(indent + "</" + expandedName(el.Name) + ">\n")
+	return nil
+}
+
+func expandedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+// diffLines returns the differences between x and y as hunks of a
+// unified diff, using their longest common subsequence.
+func diffLines(x, y []string) string {
+	// lcs[i][j] is the length of the longest common subsequence
+	// of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i, j = i+1, j+1
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	var buf bytes.Buffer
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// Changes with no more than twice the context between
+		// them are reported in one hunk.
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for n := k; n < len(edits) && n <= end+2*diffContext; n++ {
+			if edits[n].op != ' ' {
+				end = n
+			}
+		}
+		end += diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		var countX, countY int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				countX++
+			}
+			if e.op != '-' {
+				countY++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", edits[start].i+1, countX, edits[start].j+1, countY)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&buf, "%c%s\n", e.op, e.line)
+		}
+		k = end
+	}
+	if buf.Len() == 0 {
+		return ""
+	}
+	return "--- a\n+++ b\n" + buf.String()
+}
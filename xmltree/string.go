@@ -2,19 +2,148 @@ package xmltree
 
 import (
 	"bytes"
-	"text/template"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"unicode/utf8"
 )
 
-var xmlTmpl = template.Must(template.New("Marshal XML Elements").Parse(
-	`{{define "Element"}}<{{.Name.Local}}{{range .StartElement.Attr}} {{.Name.Local}}="{{.Value}}"{{end}}>{{range .Children}}{{template "Element" .}}{{else}}{{printf "%s" .Content}}{{end}}</{{.Name.Local}}>{{end}}`))
+// An EmptyElementStyle controls how Marshal and Encode write an
+// element with no children and no content.
+type EmptyElementStyle int
 
-type marshalError string
+const (
+	// SelfClosing writes an empty element as a single tag, <x/>.
+	// This is the default.
+	SelfClosing EmptyElementStyle = iota
+	// Paired writes an empty element as a start tag followed by an
+	// end tag, <x></x>, as canonical XML requires.
+	Paired
+)
+
+// A MarshalOption changes how Marshal and Encode write an Element.
+type MarshalOption interface {
+	apply(*encoder)
+}
+
+func (style EmptyElementStyle) apply(e *encoder) {
+	e.emptyStyle = style
+}
+
+type encoder struct {
+	buf        bytes.Buffer
+	emptyStyle EmptyElementStyle
+}
 
-// String returns an Element rendered as an XML document.
-func (el *Element) String() (doc string) {
+// Marshal returns the XML encoding of an Element and its children.
+// See Encode for the rules Marshal follows.
+func Marshal(el *Element, opts ...MarshalOption) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := xmlTmpl.ExecuteTemplate(&buf, "Element", el); err != nil {
+	if err := Encode(&buf, el, opts...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the XML encoding of an Element and its children to w.
+// Names are qualified with the prefixes declared in the Element's
+// Scope. Attribute values are escaped, including the whitespace
+// characters that an XML parser would otherwise normalize. An Element
+// with children is written from its Children, and any text between
+// them is not kept; the Content of an Element with no children is
+// raw XML, and is written as is. Elements with neither are written
+// in the EmptyElementStyle given in opts, or as self-closing tags. It
+// is an error if an attribute value or Content holds a character that
+// is not allowed in XML 1.0, such as NUL.
+func Encode(w io.Writer, el *Element, opts ...MarshalOption) error {
+	var e encoder
+	for _, opt := range opts {
+		opt.apply(&e)
+	}
+	if err := e.encode(el, 0); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *encoder) encode(el *Element, depth int) error {
+	if depth > recursionLimit {
+		return errDeepXML
+	}
+	buf := &e.buf
+	name := el.Prefix(el.Name)
+	buf.WriteString("<" + name)
+	for _, attr := range el.StartElement.Attr {
+		if err := checkChars(attr.Value); err != nil {
+			return fmt.Errorf("attribute %s of <%s>: %v", attr.Name.Local, name, err)
+		}
+		buf.WriteString(" " + el.attrName(attr.Name) + `="`)
+		xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteString(`"`)
+	}
+	if len(el.Children) == 0 && len(el.Content) == 0 && e.emptyStyle == SelfClosing {
+		buf.WriteString("/>")
+		return nil
+	}
+	buf.WriteString(">")
+	if len(el.Children) == 0 {
+		if err := checkChars(string(el.Content)); err != nil {
+			return fmt.Errorf("content of <%s>: %v", name, err)
+		}
+		buf.Write(el.Content)
+	}
+	for i := range el.Children {
+		if err := e.encode(&el.Children[i], depth+1); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("</" + name + ">")
+	return nil
+}
+
+// attrName returns the qualified name of an attribute. Unlike the
+// name of an element, an attribute that has a namespace needs a
+// prefix, as an unprefixed attribute is in no namespace.
+func (el *Element) attrName(name xml.Name) string {
+	switch {
+	case name.Space == "xmlns":
+		return "xmlns:" + name.Local
+	case name.Space == "" || name.Space == xmlLangURI || name.Space == xmlNamespaceURI:
+		return el.Prefix(name)
+	}
+	if prefix := el.declaredPrefix(name.Space); prefix != "" {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
+// checkChars returns an error if s is not valid UTF-8, or holds a
+// character outside of the Char production of XML 1.0.
+func checkChars(s string) error {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			return fmt.Errorf("invalid UTF-8 at offset %d", i)
+		case r == '\t', r == '\n', r == '\r',
+			r >= 0x20 && r <= 0xD7FF,
+			r >= 0xE000 && r <= 0xFFFD,
+			r >= 0x10000:
+		default:
+			return fmt.Errorf("character %U is not allowed in XML", r)
+		}
+		i += size
+	}
+	return nil
+}
+
+// String returns an Element rendered as an XML document, as by
+// Marshal.
+func (el *Element) String() string {
+	data, err := Marshal(el)
+	if err != nil {
 		return "nil (" + err.Error() + ")"
 	}
-	return buf.String()
+	return string(data)
 }
@@ -10,6 +10,7 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -188,23 +189,30 @@ func (scope *Scope) Prefix(name xml.Name) (qname string) {
 }
 
 func (scope *Scope) pushNS(tag xml.StartElement) {
-	var ns []xml.Name
+	// Most elements do not declare any namespaces, and share the
+	// scope of their parent.
+	n := 0
+	for _, attr := range tag.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	// Future additions to the scope must create a new backing array,
+	// so that the scope is not clobbered during parsing; the scope is
+	// copied into an array with no room to spare.
+	ns := make([]xml.Name, len(scope.ns), len(scope.ns)+n)
+	copy(ns, scope.ns)
 	for _, attr := range tag.Attr {
 		if attr.Name.Space == "xmlns" {
 			ns = append(ns, xml.Name{attr.Value, attr.Name.Local})
 		} else if attr.Name.Local == "xmlns" {
 			ns = append(ns, xml.Name{attr.Value, ""})
-		} else {
-			continue
 		}
 	}
-	if len(ns) > 0 {
-		scope.ns = append(scope.ns, ns...)
-		// Ensure that future additions to the scope create
-		// a new backing array. This prevents the scope from
-		// being clobbered during parsing.
-		scope.ns = scope.ns[:len(scope.ns):len(scope.ns)]
-	}
+	scope.ns = ns
 }
 
 // Save some typing when scanning xml
@@ -257,7 +265,10 @@ walk:
 	for scanner.scan() {
 		switch tok := scanner.tok.(type) {
 		case xml.StartElement:
-			child := Element{StartElement: tok.Copy(), Scope: el.Scope}
+			// Unlike CharData, the fields of a StartElement are not
+			// overwritten by the next call to Token, so there is no
+			// need to copy it.
+			child := Element{StartElement: tok, Scope: el.Scope}
 			if err := child.parse(scanner, data, depth+1); err != nil {
 				return err
 			}
@@ -302,6 +313,66 @@ func (el *Element) SetAttr(space, local, value string) {
 	})
 }
 
+// Normalize rewrites the Content of an Element and its children so
+// that each run of character data is a single piece of text, like the
+// normalize method of the DOM. Text, CDATA sections and character
+// references that are next to each other are merged, and the result
+// is escaped so that markup characters in CDATA sections remain text.
+// The byte array passed to Parse is not modified; the Content of
+// each Element that changes is copied.
+func (el *Element) Normalize() {
+	if content, ok := normalizeContent(el.Content); ok {
+		el.Content = content
+	}
+	for i := range el.Children {
+		el.Children[i].Normalize()
+	}
+}
+
+// normalizeContent merges the runs of character data in an XML
+// fragment. The fragment is copied verbatim except for its character
+// data. ok is false if the fragment cannot be tokenized, or if its
+// character data is already normalized.
+func normalizeContent(content []byte) (normalized []byte, ok bool) {
+	if !bytes.Contains(content, []byte("<![CDATA[")) && !bytes.ContainsRune(content, '&') {
+		return nil, false
+	}
+	var buf, text bytes.Buffer
+	flush := func() {
+		for _, c := range text.Bytes() {
+			switch c {
+			case '&':
+				buf.WriteString("&amp;")
+			case '<':
+				buf.WriteString("&lt;")
+			case '>':
+				buf.WriteString("&gt;")
+			default:
+				buf.WriteByte(c)
+			}
+		}
+		text.Reset()
+	}
+	d := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		begin := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			text.Write(data)
+			continue
+		}
+		flush()
+		buf.Write(content[begin:d.InputOffset()])
+	}
+	flush()
+	return buf.Bytes(), true
+}
+
 // walkFunc is the type of the function called for each of an Element's
 // children.
 type walkFunc func(*Element)
@@ -336,3 +407,95 @@ func (root *Element) Search(space, local string) []*Element {
 		return space == "" || space == el.Name.Space
 	})
 }
+
+// NamespacesInUse returns the namespaces used in an Element and its
+// children, mapped to a prefix that can be used to declare them on a
+// new root element. A namespace is in use if it is the namespace of
+// the name of an element or attribute, or the namespace of a QName
+// in an attribute value or in the text of an element with no children,
+// if its prefix is declared in scope. Where possible, the prefix is
+// the one declared for the namespace in the document; otherwise, a
+// prefix of the form nsN is chosen. Each namespace is given a distinct,
+// non-empty prefix, so that it can also be used for attributes.
+func (root *Element) NamespacesInUse() map[string]string {
+	var (
+		order    []string
+		declared = make(map[string]string)
+	)
+	use := func(scope *Scope, ns string) {
+		if ns == "" || ns == xmlLangURI || ns == xmlNamespaceURI {
+			return
+		}
+		if _, ok := declared[ns]; ok {
+			return
+		}
+		order = append(order, ns)
+		declared[ns] = scope.declaredPrefix(ns)
+	}
+	useQName := func(scope *Scope, s string) {
+		s = strings.TrimSpace(s)
+		i := strings.Index(s, ":")
+		if i <= 0 || strings.ContainsAny(s, " \t\r\n/") || strings.Count(s, ":") > 1 {
+			return
+		}
+		if name, ok := scope.ResolveNS(s); ok {
+			use(scope, name.Space)
+		}
+	}
+	var search func(el *Element)
+	search = func(el *Element) {
+		use(&el.Scope, el.Name.Space)
+		for _, attr := range el.StartElement.Attr {
+			if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+				continue
+			}
+			use(&el.Scope, attr.Name.Space)
+			useQName(&el.Scope, attr.Value)
+		}
+		if len(el.Children) == 0 {
+			useQName(&el.Scope, string(el.Content))
+		}
+		el.walk(search)
+	}
+	search(root)
+
+	result := make(map[string]string, len(order))
+	taken := make(map[string]bool)
+	for _, ns := range order {
+		if prefix := declared[ns]; prefix != "" && !taken[prefix] {
+			result[ns] = prefix
+			taken[prefix] = true
+		}
+	}
+	n := 0
+	for _, ns := range order {
+		if _, ok := result[ns]; ok {
+			continue
+		}
+		for {
+			n++
+			if prefix := fmt.Sprintf("ns%d", n); !taken[prefix] {
+				result[ns] = prefix
+				taken[prefix] = true
+				break
+			}
+		}
+	}
+	return result
+}
+
+// declaredPrefix returns the closest non-empty prefix declared for a
+// namespace that is not hidden by a later declaration, or the empty
+// string.
+func (scope *Scope) declaredPrefix(ns string) string {
+	for i := len(scope.ns) - 1; i >= 0; i-- {
+		prefix := scope.ns[i].Local
+		if scope.ns[i].Space != ns || prefix == "" {
+			continue
+		}
+		if name, _ := scope.ResolveNS(prefix + ":x"); name.Space == ns {
+			return prefix
+		}
+	}
+	return ""
+}
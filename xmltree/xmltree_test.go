@@ -1,7 +1,10 @@
 package xmltree
 
 import (
+	"bytes"
 	"encoding/xml"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/kr/pretty"
@@ -150,3 +153,330 @@ func TestString(t *testing.T) {
 	}
 	t.Log(s)
 }
+
+func TestNestedScopes(t *testing.T) {
+	root, err := Parse([]byte(`
+		<root xmlns:p="http://outer/" xmlns="http://default/">
+		  <first xmlns:p="http://first/" xmlns:q="http://q/">
+		    <inner p:a="1" />
+		  </first>
+		  <second>
+		    <inner xmlns="http://second/" />
+		  </second>
+		  <third xmlns:r="http://r/" p:b="2">
+		    <inner xmlns:p="http://third/" />
+		    <sibling />
+		  </third>
+		</root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  []int
+		qname string
+		space string
+		ok    bool
+	}{
+		{nil, "p:x", "http://outer/", true},
+		{nil, "x", "http://default/", true},
+		{nil, "q:x", "q", false},
+		{[]int{0}, "p:x", "http://first/", true},
+		{[]int{0, 0}, "p:x", "http://first/", true},
+		{[]int{0, 0}, "q:x", "http://q/", true},
+		{[]int{1}, "p:x", "http://outer/", true},
+		{[]int{1}, "q:x", "q", false},
+		{[]int{1, 0}, "x", "http://second/", true},
+		{[]int{1}, "x", "http://default/", true},
+		{[]int{2}, "r:x", "http://r/", true},
+		{[]int{2, 0}, "p:x", "http://third/", true},
+		{[]int{2, 0}, "r:x", "http://r/", true},
+		{[]int{2, 1}, "p:x", "http://outer/", true},
+		{[]int{2, 1}, "r:x", "http://r/", true},
+		{[]int{2, 1}, "x", "http://default/", true},
+	}
+	for _, tt := range tests {
+		el := root
+		for _, i := range tt.path {
+			el = &el.Children[i]
+		}
+		name, ok := el.ResolveNS(tt.qname)
+		if name.Space != tt.space || ok != tt.ok {
+			t.Errorf("ResolveNS(%q) at <%s> %v = %q, %v, want %q, %v",
+				tt.qname, el.Name.Local, tt.path, name.Space, ok, tt.space, tt.ok)
+		}
+	}
+	if a := root.Children[0].Children[0].Attr("http://first/", "a"); a != "1" {
+		t.Errorf("attribute p:a in nested scope is %q, want \"1\"", a)
+	}
+	if b := root.Children[2].Attr("http://outer/", "b"); b != "2" {
+		t.Errorf("attribute p:b is %q, want \"2\"", b)
+	}
+}
+
+// attrHeavyDoc returns a document with n elements of many attributes
+// each, some of which declare namespaces.
+func attrHeavyDoc(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<root xmlns="http://example.com/root" xmlns:a="http://example.com/a">`)
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&buf, `<a:group xmlns:b="http://example.com/b%d">`, i)
+		}
+		fmt.Fprintf(&buf, `<a:item id="%d" a:x="1" a:y="2" b:z="3" name="item%d" kind="k" `+
+			`size="10" color="red" weight="5" height="7" />`, i, i)
+		if i%10 == 9 {
+			buf.WriteString(`</a:group>`)
+		}
+	}
+	if n%10 != 0 {
+		buf.WriteString(`</a:group>`)
+	}
+	buf.WriteString(`</root>`)
+	return buf.Bytes()
+}
+
+func BenchmarkParseAttrHeavy(b *testing.B) {
+	doc := attrHeavyDoc(1000)
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	root, err := Parse([]byte(`<doc>` +
+		`<text>foo<![CDATA[bar]]>baz</text>` +
+		`<markup>a &lt; b<![CDATA[ <i>and</i> ]]>c &amp; d</markup>` +
+		`<mixed>x<![CDATA[y]]><b>z<![CDATA[!]]></b>&#119;</mixed>` +
+		`<plain>nothing to do</plain>` +
+		`</doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Normalize()
+	want := map[string]string{
+		"text":   "foobarbaz",
+		"markup": "a &lt; b &lt;i&gt;and&lt;/i&gt; c &amp; d",
+		"mixed":  "xy<b>z!</b>w",
+		"b":      "z!",
+		"plain":  "nothing to do",
+	}
+	for name, content := range want {
+		el := root.Search("", name)
+		if len(el) != 1 {
+			t.Fatalf("found %d <%s> elements, want 1", len(el), name)
+		}
+		if got := string(el[0].Content); got != content {
+			t.Errorf("<%s> content is %q, want %q", name, got, content)
+		}
+	}
+	var v struct {
+		Markup string `xml:"markup"`
+	}
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a < b <i>and</i> c & d"; v.Markup != want {
+		t.Errorf("unmarshalled normalized <markup> as %q, want %q", v.Markup, want)
+	}
+}
+
+func TestNamespacesInUse(t *testing.T) {
+	root, err := Parse([]byte(`
+	  <doc xmlns="http://example.com/doc" xmlns:a="http://example.com/a"
+	       xmlns:b="http://example.com/b" xmlns:c="http://example.com/c"
+	       xmlns:unused="http://example.com/unused">
+	    <a:order id="1" b:priority="high">
+	      <item>
+	        <a:name>widget</a:name>
+	      </item>
+	      <kind>c:Part</kind>
+	    </a:order>
+	  </doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := root.Search("http://example.com/a", "order")
+	if len(order) != 1 {
+		t.Fatalf("found %d <a:order> elements, want 1", len(order))
+	}
+	got := order[0].NamespacesInUse()
+	want := map[string]string{
+		"http://example.com/a": "a",
+		// used only by an attribute
+		"http://example.com/b": "b",
+		// used only by a QName in the text of an element
+		"http://example.com/c": "c",
+		// the default namespace does not have a prefix
+		"http://example.com/doc": "ns1",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d namespaces %v, want %d", len(got), got, len(want))
+	}
+	for ns, prefix := range want {
+		if got[ns] != prefix {
+			t.Errorf("prefix for %s is %q, want %q", ns, got[ns], prefix)
+		}
+	}
+}
+
+func TestMarshalEscaping(t *testing.T) {
+	root, err := Parse([]byte(`<root xmlns:p="http://p/" p:note="a &amp; b &lt; c&#xD;"><item>1 &amp; 2 &lt; 3&#xD;</item></root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetAttr("", "added", "x & y < z\r\n\"q\"")
+	data, err := Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Parse(data)
+	if err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	if got, want := again.Attr("http://p/", "note"), "a & b < c\r"; got != want {
+		t.Errorf("note is %q, want %q in %s", got, want, data)
+	}
+	if got, want := again.Attr("", "added"), "x & y < z\r\n\"q\""; got != want {
+		t.Errorf("added is %q, want %q in %s", got, want, data)
+	}
+	var text string
+	if err := again.Children[0].Unmarshal(&text); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1 & 2 < 3\r"; text != want {
+		t.Errorf("item is %q, want %q in %s", text, want, data)
+	}
+
+	root.SetAttr("", "added", "nul\x00")
+	if _, err := Marshal(root); err == nil {
+		t.Error("expected an error marshalling an attribute with a NUL character")
+	}
+	root.SetAttr("", "added", "ok")
+	root.Children[0].Content = []byte("nul\x00")
+	if _, err := Marshal(root); err == nil {
+		t.Error("expected an error marshalling content with a NUL character")
+	}
+}
+
+func TestMarshalEmptyElements(t *testing.T) {
+	root, err := Parse([]byte(`<root><x></x><y a="1"/><z>text</z></root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts []MarshalOption
+		want string
+	}{
+		{nil, `<root><x/><y a="1"/><z>text</z></root>`},
+		{[]MarshalOption{SelfClosing}, `<root><x/><y a="1"/><z>text</z></root>`},
+		{[]MarshalOption{Paired}, `<root><x></x><y a="1"></y><z>text</z></root>`},
+	}
+	for _, tt := range tests {
+		data, err := Marshal(root, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(root, %v) = %s, want %s", tt.opts, data, tt.want)
+		}
+	}
+}
+
+func TestDiffString(t *testing.T) {
+	const order = `<order xmlns="urn:orders" xmlns:x="urn:extra" id="7" x:flag="on">
+	  <item sku="a"><qty>1</qty></item>
+	  <item sku="b"><qty>2</qty></item>
+	  <note/>
+	</order>`
+	same := []string{
+		order,
+		`<o:order xmlns:o="urn:orders" x:flag="on" id="7" xmlns:x="urn:extra"><o:item sku="a"><o:qty> 1 </o:qty></o:item>
+		<!-- second --><o:item sku="b"><o:qty><![CDATA[2]]></o:qty></o:item><o:note></o:note></o:order>`,
+	}
+	for _, b := range same {
+		diff, err := DiffString([]byte(order), []byte(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff != "" {
+			t.Errorf("DiffString reports differences from\n%s\n%s", b, diff)
+		}
+	}
+
+	changed := `<order xmlns="urn:orders" xmlns:x="urn:extra" id="7" x:flag="on">
+	  <item sku="a"><qty>1</qty></item>
+	  <item sku="b"><qty>3</qty></item>
+	  <note/>
+	</order>`
+	diff, err := DiffString([]byte(order), []byte(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- a
++++ b
+@@ -7,5 +7,5 @@
+   <{urn:orders}item sku="b">
+     <{urn:orders}qty>
+-      "2"
++      "3"
+     </{urn:orders}qty>
+   </{urn:orders}item>
+`
+	if diff != want {
+		t.Errorf("got\n%s\nwant\n%s", diff, want)
+	}
+
+	// An element in another namespace is not the same element.
+	diff, err = DiffString([]byte(`<a xmlns="urn:x"><b/></a>`), []byte(`<a xmlns="urn:x"><b xmlns="urn:y"/></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-  <{urn:x}b>\n") || !strings.Contains(diff, "+  <{urn:y}b>\n") {
+		t.Errorf("diff does not show the change of namespace:\n%s", diff)
+	}
+
+	// Text between the children of an element is compared.
+	diff, err = DiffString([]byte(`<p>Total: <b>7</b> items</p>`), []byte(`<p>Total: <b>7</b> units</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-  \"items\"\n") || !strings.Contains(diff, "+  \"units\"\n") {
+		t.Errorf("diff does not show the change of mixed content:\n%s", diff)
+	}
+
+	if _, err := DiffString([]byte(order), []byte("<order>")); err == nil {
+		t.Error("DiffString returned no error for a malformed document")
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	root, err := Parse([]byte(`<p xmlns="urn:x" xmlns:y="urn:y" y:b="2" a="1">
+	  Total: <!-- count --><b>7</b> items, <i><![CDATA[<all>]]></i> &amp; more
+	</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Canonical(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<{urn:x}p a="1" {urn:y}b="2">
+  "Total:"
+  <{urn:x}b>
+    "7"
+  </{urn:x}b>
+  "items,"
+  <{urn:x}i>
+    "<all>"
+  </{urn:x}i>
+  "& more"
+</{urn:x}p>
+`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -3,7 +3,6 @@ package xsd
 import (
 	"encoding/xml"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 
@@ -54,6 +53,41 @@ func Imports(data []byte) ([]Ref, error) {
 	return result, nil
 }
 
+// SchemaLocations reads an XML instance document and returns the
+// schema that its xsi:schemaLocation and xsi:noNamespaceSchemaLocation
+// attributes point to, in the order that they appear. These attributes
+// are hints, and may be on any element of the document. The Namespace
+// of a Ref from xsi:noNamespaceSchemaLocation is the empty string.
+// Locations are returned as they are written, and may be relative to
+// the location of the document.
+func SchemaLocations(doc []byte) ([]Ref, error) {
+	var result []Ref
+	root, err := xmltree.Parse(doc)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[Ref]bool)
+	add := func(ref Ref) {
+		if !seen[ref] {
+			seen[ref] = true
+			result = append(result, ref)
+		}
+	}
+	outer := xmltree.Element{Children: []xmltree.Element{*root}}
+	outer.SearchFunc(func(el *xmltree.Element) bool {
+		pairs := strings.Fields(el.Attr(schemaInstanceNS, "schemaLocation"))
+		for i := 0; i+1 < len(pairs); i += 2 {
+			add(Ref{pairs[i], pairs[i+1]})
+		}
+		if loc := el.Attr(schemaInstanceNS, "noNamespaceSchemaLocation"); loc != "" {
+			add(Ref{"", strings.TrimSpace(loc)})
+		}
+		// keep searching the children
+		return false
+	})
+	return result, nil
+}
+
 // Parse reads XML documents containing one or more <schema>
 // elements. The returned slice has one Schema for every <schema>
 // element in the documents. Parse will not fetch schema used in
@@ -87,6 +121,7 @@ func Parse(docs ...[]byte) ([]Schema, error) {
 		// schema without target namespaces, we would have to
 		// do it another way.
 		for _, s := range add {
+			applyDerivationDefaults(s)
 			ns := s.Attr("", "targetNamespace")
 			if v, ok := schema[ns]; !ok {
 				schema[ns] = s
@@ -98,7 +133,12 @@ func Parse(docs ...[]byte) ([]Schema, error) {
 	}
 
 	for tns, root := range schema {
-		s := Schema{TargetNS: tns, Types: make(map[xml.Name]Type)}
+		s := Schema{
+			TargetNS:  tns,
+			Types:     make(map[xml.Name]Type),
+			Elements:  make(map[xml.Name]Element),
+			Notations: make(map[xml.Name]Notation),
+		}
 		if err := s.parse(root, schema); err != nil {
 			return nil, err
 		}
@@ -110,6 +150,11 @@ func Parse(docs ...[]byte) ([]Schema, error) {
 			types[XMLName(t)] = t
 		}
 	}
+	for tns, s := range parsed {
+		if err := checkImports(tns, schema, s.Types); err != nil {
+			return nil, err
+		}
+	}
 	for _, s := range parsed {
 		if err := s.resolvePartialTypes(types); err != nil {
 			return nil, err
@@ -119,6 +164,142 @@ func Parse(docs ...[]byte) ([]Schema, error) {
 	return result, nil
 }
 
+// The blockDefault and finalDefault attributes of a <schema> element
+// apply to the components declared in it, but not to those of the
+// schema it includes or is included by. As the documents of a
+// namespace are merged before they are parsed, the defaults are
+// copied to the components that have no block or final attributes of
+// their own beforehand.
+func applyDerivationDefaults(root *xmltree.Element) {
+	block, final := root.Attr("", "blockDefault"), root.Attr("", "finalDefault")
+	if block == "" && final == "" {
+		return
+	}
+	setDefault := func(el *xmltree.Element, name, value string) {
+		if _, ok := attr(el, name); !ok && value != "" {
+			el.SetAttr("", name, value)
+		}
+	}
+	root.SearchFunc(func(el *xmltree.Element) bool {
+		if el.Name.Space != schemaNS {
+			return false
+		}
+		switch el.Name.Local {
+		case "element":
+			// A reference takes the attributes of the element
+			// it refers to, which are merged with its own.
+			if _, ok := attr(el, "ref"); !ok {
+				setDefault(el, "block", block)
+			}
+		case "complexType":
+			setDefault(el, "block", block)
+			setDefault(el, "final", final)
+		case "simpleType":
+			setDefault(el, "final", final)
+		}
+		return false
+	})
+	// Only top-level elements head substitution groups.
+	for i := range root.Children {
+		if el := &root.Children[i]; (el.Name == xml.Name{Space: schemaNS, Local: "element"}) {
+			setDefault(el, "final", final)
+		}
+	}
+}
+
+// attr is like the Attr method of an Element, but reports whether
+// the attribute is present, as a block or final attribute that is
+// present but empty overrides the default of the schema.
+func attr(el *xmltree.Element, local string) (string, bool) {
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// parseDerivation parses the value of a block or final attribute, or
+// of their defaults, keeping the methods that apply to the component,
+// which are all of them for #all.
+func parseDerivation(s string, applies Derivation) Derivation {
+	var d Derivation
+	for _, method := range strings.Fields(s) {
+		switch method {
+		case "#all":
+			d |= applies
+		case "extension":
+			d |= DerivationExtension
+		case "restriction":
+			d |= DerivationRestriction
+		case "substitution":
+			d |= DerivationSubstitution
+		case "list":
+			d |= DerivationList
+		case "union":
+			d |= DerivationUnion
+		default:
+			stop("invalid derivation method " + method)
+		}
+	}
+	return d & applies
+}
+
+// An <import> declaration need not say where to find the schema for a
+// namespace; the schema may be supplied along with the importing
+// schema instead. References into the imported namespace are resolved
+// against all of the schema passed to Parse, so the order in which
+// they are given does not matter. checkImports reports references to
+// namespaces for which no schema was supplied at all, naming the
+// missing namespace.
+func checkImports(tns string, schema map[string]*xmltree.Element, types map[xml.Name]Type) error {
+	var missing xml.Name
+	check := func(t Type) {
+		if ref, ok := t.(linkedType); ok && missing.Local == "" {
+			if _, ok := schema[ref.Space]; !ok && ref.Space != schemaNS {
+				missing = xml.Name(ref)
+			}
+		}
+	}
+	for _, t := range types {
+		switch t := t.(type) {
+		case *ComplexType:
+			check(t.Base)
+			for _, el := range t.Elements {
+				check(el.Type)
+			}
+			for _, attr := range t.Attributes {
+				check(attr.Type)
+			}
+		case *SimpleType:
+			check(t.Base)
+			for _, u := range t.Union {
+				check(u)
+			}
+		}
+		if missing.Local != "" {
+			return missingNamespace(tns, schema[tns], missing)
+		}
+	}
+	return nil
+}
+
+func missingNamespace(tns string, root *xmltree.Element, name xml.Name) error {
+	for _, imp := range root.Search(schemaNS, "import") {
+		if imp.Attr("", "namespace") != name.Space {
+			continue
+		}
+		if imp.Attr("", "schemaLocation") == "" {
+			return fmt.Errorf("schema %s imports namespace %s without a schemaLocation, "+
+				"and no schema for it was provided (needed for %s)", tns, name.Space, name.Local)
+		}
+		return fmt.Errorf("schema %s imports namespace %s from %s, but it was not provided (needed for %s)",
+			tns, name.Space, imp.Attr("", "schemaLocation"), name.Local)
+	}
+	return fmt.Errorf("schema %s references %s in namespace %s, but no schema for that namespace was provided",
+		tns, name.Local, name.Space)
+}
+
 func parseType(name xml.Name) Type {
 	builtin, err := ParseBuiltin(name)
 	if err != nil {
@@ -213,6 +394,9 @@ func (s *Schema) parse(root *xmltree.Element, extra map[string]*xmltree.Element)
 			}
 		}
 		if !found {
+			if _, ok := extra[ref.Space]; !ok {
+				return missingNamespace(s.TargetNS, root, ref)
+			}
 			return fmt.Errorf("could not dereference %s %s %s", el.Name.Local,
 				el.Resolve(el.Attr("", "ref")).Space, el.Resolve(el.Attr("", "ref")).Local)
 		}
@@ -227,6 +411,16 @@ func (s *Schema) parse(root *xmltree.Element, extra map[string]*xmltree.Element)
 		t := s.parseSimpleType(el)
 		s.Types[t.Name] = t
 	}
+	for i := range root.Children {
+		switch el := &root.Children[i]; el.Name {
+		case xml.Name{Space: schemaNS, Local: "element"}:
+			e := parseElement(s.TargetNS, el)
+			s.Elements[e.Name] = e
+		case xml.Name{Space: schemaNS, Local: "notation"}:
+			n := parseNotation(s.TargetNS, el)
+			s.Notations[n.Name] = n
+		}
+	}
 
 	return err
 }
@@ -237,6 +431,8 @@ func (s *Schema) parseComplexType(root *xmltree.Element) *ComplexType {
 	var doc annotation
 	t.Name = root.ResolveDefault(root.Attr("", "name"), s.TargetNS)
 	t.Abstract = parseBool(root.Attr("", "abstract"))
+	t.Block = parseDerivation(root.Attr("", "block"), DerivationExtension|DerivationRestriction)
+	t.Final = parseDerivation(root.Attr("", "final"), DerivationExtension|DerivationRestriction)
 	// We set this special attribute in a pre-processing step.
 	t.Anonymous = (root.Attr("", "_isAnonymous") == "true")
 
@@ -286,10 +482,13 @@ func (t *ComplexType) parseSimpleContent(ns string, root *xmltree.Element) {
 			doc = doc.append(parseAnnotation(el))
 		case "restriction":
 			t.Base = parseType(el.Resolve(el.Attr("", "base")))
+			for _, v := range searchContent(el, "attribute") {
+				t.Attributes = append(t.Attributes, parseAttribute(ns, v))
+			}
 		case "extension":
 			t.Base = parseType(el.Resolve(el.Attr("", "base")))
 			t.Extends = true
-			for _, v := range el.Search(schemaNS, "attribute") {
+			for _, v := range searchContent(el, "attribute") {
 				t.Attributes = append(t.Attributes, parseAttribute(ns, v))
 			}
 		}
@@ -309,13 +508,31 @@ func (t *ComplexType) parseComplexContent(ns string, root *xmltree.Element) {
 			fallthrough
 		case "restriction":
 			t.Base = parseType(el.Resolve(el.Attr("", "base")))
-			for _, v := range el.Search(schemaNS, "any") {
-				t.Elements = append(t.Elements, parseAnyElement(ns, v))
+			occurs := particleOccurs(el)
+			for _, v := range searchContent(el, "any") {
+				e := parseAnyElement(ns, v)
+				if o, ok := occurs[v]; ok {
+					e.nestOccurs(o)
+				}
+				t.Elements = append(t.Elements, e)
 			}
-			for _, v := range el.Search(schemaNS, "element") {
-				t.Elements = append(t.Elements, parseElement(ns, v))
+			choices := choiceGroups(el)
+			groups := repeatedGroups(el)
+			for _, v := range searchContent(el, "element") {
+				e := parseElement(ns, v)
+				if o, ok := occurs[v]; ok {
+					e.nestOccurs(o)
+				}
+				if e.Choice = choices[v]; e.Choice > 0 {
+					e.Optional = true
+				}
+				if pos, ok := groups[v]; ok {
+					e.Group, e.GroupIndex = pos.group, pos.index
+					e.GroupMinOccurs, e.GroupMaxOccurs = pos.min, pos.max
+				}
+				t.Elements = append(t.Elements, e)
 			}
-			for _, v := range el.Search(schemaNS, "attribute") {
+			for _, v := range searchContent(el, "attribute") {
 				t.Attributes = append(t.Attributes, parseAttribute(ns, v))
 			}
 		case "annotation":
@@ -327,6 +544,201 @@ func (t *ComplexType) parseComplexContent(ns string, root *xmltree.Element) {
 	t.Doc += string(doc)
 }
 
+// searchContent returns the XML schema elements named local in the
+// content model rooted at root. Unlike root.Search, it does not
+// descend into element and type definitions, since any particles
+// declared there belong to the nested type, not this one.
+func searchContent(root *xmltree.Element, local string) []*xmltree.Element {
+	var result []*xmltree.Element
+	for i := range root.Children {
+		el := &root.Children[i]
+		if el.Name.Space != schemaNS {
+			continue
+		}
+		if el.Name.Local == local {
+			result = append(result, el)
+		}
+		switch el.Name.Local {
+		case "element", "attribute", "complexType", "simpleType", "annotation":
+			continue
+		}
+		result = append(result, searchContent(el, local)...)
+	}
+	return result
+}
+
+// choiceGroups maps the element declarations in a content model to
+// the position of the innermost choice they are an alternative of.
+func choiceGroups(root *xmltree.Element) map[*xmltree.Element]int {
+	groups := make(map[*xmltree.Element]int)
+	for i, choice := range searchContent(root, "choice") {
+		// Nested choices come later, and override their parents.
+		for _, el := range searchContent(choice, "element") {
+			groups[el] = i + 1
+		}
+	}
+	return groups
+}
+
+// A groupPosition locates an element declaration in a sequence or
+// choice that may appear more than once.
+type groupPosition struct {
+	group, index int
+	occurs
+}
+
+// repeatedGroups maps the element declarations in a content model to
+// their positions in the sequences and choices that may appear more
+// than once. Such a group is only recorded if it declares more than
+// one element, no wildcards, and no other repeating groups, as
+// elements that repeat together in nested groups cannot be told
+// apart by the group they are in.
+func repeatedGroups(root *xmltree.Element) map[*xmltree.Element]groupPosition {
+	result := make(map[*xmltree.Element]groupPosition)
+	var n int
+	var visit func(el *xmltree.Element)
+	visit = func(el *xmltree.Element) {
+		for i := range el.Children {
+			child := &el.Children[i]
+			if child.Name.Space != schemaNS {
+				continue
+			}
+			switch child.Name.Local {
+			case "sequence", "choice", "group":
+			default:
+				continue
+			}
+			group, particles, o := modelGroup(child)
+			if !o.repeats() || len(searchContent(group, "element")) < 2 ||
+				len(searchContent(group, "any")) > 0 || hasRepeatedGroup(group) {
+				visit(child)
+				continue
+			}
+			n++
+			occurs := particleOccurs(group)
+			for j, p := range particles {
+				index := j
+				if group.Name.Local == "choice" {
+					index = 0
+				}
+				elements := []*xmltree.Element{p}
+				if p.Name.Local != "element" {
+					elements = searchContent(p, "element")
+				}
+				for _, e := range elements {
+					result[e] = groupPosition{n, index, occurs[e].mul(parseOccurs(e))}
+				}
+			}
+		}
+	}
+	visit(root)
+	return result
+}
+
+// hasRepeatedGroup reports whether a model group contains a nested
+// sequence or choice that may appear more than once.
+func hasRepeatedGroup(root *xmltree.Element) bool {
+	for _, local := range []string{"sequence", "choice", "group"} {
+		for _, el := range searchContent(root, local) {
+			if _, _, o := modelGroup(el); o.repeats() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// modelGroup returns the sequence or choice that a particle stands
+// for, looking through the group definitions that wrap it, along with
+// its particles and its bounds.
+func modelGroup(el *xmltree.Element) (*xmltree.Element, []*xmltree.Element, occurs) {
+	o := occurs{1, 1}.mul(parseOccurs(el))
+	for {
+		var particles []*xmltree.Element
+		for i := range el.Children {
+			child := &el.Children[i]
+			if child.Name.Space == schemaNS && child.Name.Local != "annotation" {
+				particles = append(particles, child)
+			}
+		}
+		if el.Name.Local != "group" || len(particles) != 1 {
+			return el, particles, o
+		}
+		el = particles[0]
+		o = o.mul(parseOccurs(el))
+	}
+}
+
+// The occurrence constraints of the model groups (sequence, choice,
+// all and group) that contain an element apply to the element, too:
+// an element that must appear once, in a sequence that may appear
+// any number of times, may appear any number of times. An occurs
+// holds the bounds of a particle, as multiplied down the nesting.
+type occurs struct {
+	min, max int
+}
+
+func (o occurs) mul(min, max int) occurs {
+	o.min *= min
+	switch {
+	case o.max == 0 || max == 0:
+		o.max = 0
+	case o.max < 0 || max < 0:
+		o.max = -1
+	default:
+		o.max *= max
+	}
+	return o
+}
+
+func (o occurs) repeats() bool {
+	return o.max < 0 || o.max > 1
+}
+
+// nestOccurs applies the bounds of the model groups that contain an
+// element to the element's own bounds.
+func (e *Element) nestOccurs(o occurs) {
+	o = o.mul(e.MinOccurs, e.MaxOccurs)
+	e.MinOccurs, e.MaxOccurs = o.min, o.max
+	e.Optional = e.Optional || o.min == 0
+	e.Plural = e.Plural || o.min > 1 || o.max < 0 || o.max > 1
+}
+
+// particleOccurs maps the element and wildcard declarations in a
+// content model to the combined bounds of the model groups they
+// are nested in. The alternatives of a choice with more than one
+// alternative may not appear at all, whatever their own bounds.
+func particleOccurs(root *xmltree.Element) map[*xmltree.Element]occurs {
+	result := make(map[*xmltree.Element]occurs)
+	var visit func(el *xmltree.Element, o occurs)
+	visit = func(el *xmltree.Element, o occurs) {
+		var alternatives int
+		for i := range el.Children {
+			if el.Children[i].Name.Space == schemaNS && el.Children[i].Name.Local != "annotation" {
+				alternatives++
+			}
+		}
+		for i := range el.Children {
+			child := &el.Children[i]
+			if child.Name.Space != schemaNS {
+				continue
+			}
+			nested := o
+			if el.Name.Local == "choice" && alternatives > 1 {
+				nested.min = 0
+			}
+			switch child.Name.Local {
+			case "element", "any":
+				result[child] = nested
+			case "sequence", "choice", "all", "group":
+				visit(child, nested.mul(parseOccurs(child)))
+			}
+		}
+	}
+	visit(root, occurs{1, 1})
+	return result
+}
+
 func parseInt(s string) int {
 	switch s {
 	case "":
@@ -352,6 +764,19 @@ func parseBool(s string) bool {
 	return false
 }
 
+// parseOccurs returns the minOccurs and maxOccurs attributes of a
+// particle, which default to 1. An unbounded maxOccurs is returned as -1.
+func parseOccurs(el *xmltree.Element) (min, max int) {
+	min, max = 1, 1
+	if s := el.Attr("", "minOccurs"); s != "" {
+		min = parseInt(s)
+	}
+	if s := el.Attr("", "maxOccurs"); s != "" {
+		max = parseInt(s)
+	}
+	return min, max
+}
+
 func parsePlural(el *xmltree.Element) bool {
 	if min := parseInt(el.Attr("", "minOccurs")); min > 1 {
 		return true
@@ -367,29 +792,48 @@ func parseAnyElement(ns string, el *xmltree.Element) Element {
 	if typeattr != "" {
 		base = parseType(el.Resolve(typeattr))
 	}
+	namespace := el.Attr("", "namespace")
+	if namespace == "" {
+		namespace = "##any"
+	}
+	min, max := parseOccurs(el)
 	return Element{
-		Plural:   parsePlural(el),
-		Type:     base,
-		Wildcard: true,
+		Plural:    parsePlural(el),
+		Optional:  min == 0,
+		MinOccurs: min,
+		MaxOccurs: max,
+		Type:      base,
+		Wildcard:  true,
+		Namespace: namespace,
 	}
 }
 
 func parseElement(ns string, el *xmltree.Element) Element {
 	var doc annotation
+	min, max := parseOccurs(el)
+	var typ Type = AnyType
+	if name := el.Attr("", "type"); name != "" {
+		typ = parseType(el.Resolve(name))
+	}
 	e := Element{
-		Name:     el.ResolveDefault(el.Attr("", "name"), ns),
-		Type:     parseType(el.Resolve(el.Attr("", "type"))),
-		Default:  el.Attr("", "default"),
-		Abstract: parseBool(el.Attr("", "abstract")),
-		Nillable: parseBool(el.Attr("", "nillable")),
-		Optional: (el.Attr("", "use") == "optional"),
-		Plural:   parsePlural(el),
-		Scope:    el.Scope,
+		Name:      el.ResolveDefault(el.Attr("", "name"), ns),
+		Type:      typ,
+		Default:   el.Attr("", "default"),
+		Abstract:  parseBool(el.Attr("", "abstract")),
+		Nillable:  parseBool(el.Attr("", "nillable")),
+		Block:     parseDerivation(el.Attr("", "block"), DerivationExtension|DerivationRestriction|DerivationSubstitution),
+		Final:     parseDerivation(el.Attr("", "final"), DerivationExtension|DerivationRestriction),
+		Optional:  min == 0 || el.Attr("", "use") == "optional",
+		MinOccurs: min,
+		MaxOccurs: max,
+		Plural:    parsePlural(el),
+		Scope:     el.Scope,
 	}
 
 	walk(el, func(el *xmltree.Element) {
 		if el.Name.Local == "annotation" {
 			doc = doc.append(parseAnnotation(el))
+			e.AppInfo = append(e.AppInfo, parseAppInfo(el)...)
 		}
 	})
 	e.Doc = string(doc)
@@ -397,6 +841,21 @@ func parseElement(ns string, el *xmltree.Element) Element {
 	return e
 }
 
+func parseNotation(ns string, el *xmltree.Element) Notation {
+	var doc annotation
+	walk(el, func(el *xmltree.Element) {
+		if el.Name.Local == "annotation" {
+			doc = doc.append(parseAnnotation(el))
+		}
+	})
+	return Notation{
+		Name:   el.ResolveDefault(el.Attr("", "name"), ns),
+		Doc:    string(doc),
+		Public: el.Attr("", "public"),
+		System: el.Attr("", "system"),
+	}
+}
+
 func parseAttribute(ns string, el *xmltree.Element) Attribute {
 	var a Attribute
 	var doc annotation
@@ -406,13 +865,22 @@ func parseAttribute(ns string, el *xmltree.Element) Attribute {
 	} else {
 		a.Name.Local = name
 	}
-	a.Type = parseType(el.Resolve(el.Attr("", "type")))
+	if typeattr := el.Attr("", "type"); typeattr != "" {
+		a.Type = parseType(el.Resolve(typeattr))
+	} else {
+		// An attribute without a type is of the simple ur-type,
+		// anySimpleType, which places no restriction on its value.
+		a.Type = String
+	}
 	a.Default = el.Attr("", "default")
+	a.Prohibited = (el.Attr("", "use") == "prohibited")
+	a.Required = (el.Attr("", "use") == "required")
 	a.Scope = el.Scope
 
 	walk(el, func(el *xmltree.Element) {
 		if el.Name.Local == "annotation" {
 			doc = doc.append(parseAnnotation(el))
+			a.AppInfo = append(a.AppInfo, parseAppInfo(el)...)
 		}
 	})
 	a.Doc = string(doc)
@@ -428,6 +896,7 @@ func (s *Schema) parseSimpleType(root *xmltree.Element) *SimpleType {
 
 	t.Name = root.ResolveDefault(root.Attr("", "name"), s.TargetNS)
 	t.Anonymous = (root.Attr("", "_isAnonymous") == "true")
+	t.Final = parseDerivation(root.Attr("", "final"), DerivationRestriction|DerivationList|DerivationUnion)
 	walk(root, func(el *xmltree.Element) {
 		switch el.Name.Local {
 		case "restriction":
@@ -443,6 +912,7 @@ func (s *Schema) parseSimpleType(root *xmltree.Element) *SimpleType {
 			}
 		case "annotation":
 			doc = doc.append(parseAnnotation(el))
+			t.AppInfo = append(t.AppInfo, parseAppInfo(el)...)
 		}
 	})
 	t.Doc = string(doc)
@@ -456,6 +926,18 @@ func parseAnnotation(el *xmltree.Element) (doc annotation) {
 	return doc
 }
 
+// parseAppInfo returns the contents of the <appinfo> elements in an
+// annotation.
+func parseAppInfo(el *xmltree.Element) []xmltree.Element {
+	var info []xmltree.Element
+	for _, v := range el.Children {
+		if v.Name.Space == schemaNS && v.Name.Local == "appinfo" {
+			info = append(info, v.Children...)
+		}
+	}
+	return info
+}
+
 func parseSimpleRestriction(root *xmltree.Element) Restriction {
 	var r Restriction
 	var doc annotation
@@ -479,7 +961,7 @@ func parseSimpleRestriction(root *xmltree.Element) Restriction {
 			r.Max = parseInt(el.Attr("", "value"))
 		case "maxInclusive":
 			r.Max = parseInt(el.Attr("", "value")) + 1
-		case "length":
+		case "length", "maxLength":
 			r.MaxLength = parseInt(el.Attr("", "value"))
 		case "minLength":
 			r.MinLength = parseInt(el.Attr("", "value"))
@@ -513,17 +995,6 @@ func parseSimpleRestriction(root *xmltree.Element) Restriction {
 	return r
 }
 
-// XML Schema defines its own flavor of regular expressions here:
-//
-// http://www.w3.org/TR/xmlschema-0/#regexAppendix
-//
-// For now, they are similar enough to RE2 expressions that we will
-// just try and compile them as RE2 expressions. If anyone wants to
-// transpile XML Schema patterns to RE2 expressions, be my guest :)
-func parsePattern(pat string) (*regexp.Regexp, error) {
-	return regexp.Compile(pat)
-}
-
 // Resolve all linkedTypes in a schema, so that all types are based
 // on a SimpleType, ComplexType, or a Builtin. Also resolve the types
 // of all Attributes and Elements.
@@ -606,6 +1077,17 @@ func (s *Schema) resolvePartialTypes(types map[xml.Name]Type) error {
 			panic(fmt.Sprintf("Unexpected type %s (%T) in Schema.Types map", name.Local, t))
 		}
 	}
+	for name, e := range s.Elements {
+		ref, ok := e.Type.(linkedType)
+		if !ok {
+			continue
+		}
+		if e.Type, ok = s.lookupType(ref, types); !ok {
+			delete(s.Elements, name)
+			continue
+		}
+		s.Elements[name] = e
+	}
 	return nil
 }
 
@@ -0,0 +1,235 @@
+package xsd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// XML Schema defines its own flavor of regular expressions here:
+//
+// http://www.w3.org/TR/xmlschema-2/#regexs
+//
+// Most of it is also valid RE2 syntax, which is what package regexp
+// implements. translatePattern rewrites the constructs that are not:
+// the \i and \c escapes for XML name characters, and the \p{IsX}
+// escapes for Unicode blocks. It also rewrites those that RE2 reads
+// differently: \w and \d, which are ASCII-only in RE2 but match any
+// word character or decimal digit in XML Schema, \s, which includes
+// form feed in RE2, and the wildcard ., which matches carriage return
+// in RE2. Character class subtraction, such as
+// [a-z-[aeiou]], has no RE2 equivalent, and RE2 would read it as a
+// different class, so it is an error and not passed through.
+
+// Characters that may begin an XML name (\i), and the other
+// characters that may appear in one (\c), as the contents of a
+// character class. These are the Unicode categories that the
+// Letter, Digit, CombiningChar and Extender productions of XML 1.0
+// are drawn from.
+const (
+	nameStartClass = `\p{L}\p{Nl}_:`
+	nameClass      = nameStartClass + `\p{Nd}\p{Mn}\p{Mc}\p{Lm}.\-\x{B7}`
+)
+
+// The characters that are not word characters (\W), and those that
+// are (\w): everything but punctuation, separators and other
+// characters. The white space characters (\s), and the others (\S).
+const (
+	nonWordClass  = `\p{P}\p{Z}\p{C}`
+	wordClass     = `\p{L}\p{M}\p{N}\p{S}`
+	spaceClass    = `\x20\t\n\r`
+	nonSpaceClass = `\x00-\x08\x0B\x0C\x0E-\x1F\x{21}-\x{10FFFF}`
+)
+
+// Ranges of the Unicode blocks that may be named by a \p{IsX} escape.
+// The surrogate blocks are left out, as there are no runes in them.
+var unicodeBlocks = map[string]string{
+	"BasicLatin":                         `\x{0000}-\x{007F}`,
+	"Latin-1Supplement":                  `\x{0080}-\x{00FF}`,
+	"LatinExtended-A":                    `\x{0100}-\x{017F}`,
+	"LatinExtended-B":                    `\x{0180}-\x{024F}`,
+	"IPAExtensions":                      `\x{0250}-\x{02AF}`,
+	"SpacingModifierLetters":             `\x{02B0}-\x{02FF}`,
+	"CombiningDiacriticalMarks":          `\x{0300}-\x{036F}`,
+	"Greek":                              `\x{0370}-\x{03FF}`,
+	"Cyrillic":                           `\x{0400}-\x{04FF}`,
+	"Armenian":                           `\x{0530}-\x{058F}`,
+	"Hebrew":                             `\x{0590}-\x{05FF}`,
+	"Arabic":                             `\x{0600}-\x{06FF}`,
+	"Syriac":                             `\x{0700}-\x{074F}`,
+	"Thaana":                             `\x{0780}-\x{07BF}`,
+	"Devanagari":                         `\x{0900}-\x{097F}`,
+	"Bengali":                            `\x{0980}-\x{09FF}`,
+	"Gurmukhi":                           `\x{0A00}-\x{0A7F}`,
+	"Gujarati":                           `\x{0A80}-\x{0AFF}`,
+	"Oriya":                              `\x{0B00}-\x{0B7F}`,
+	"Tamil":                              `\x{0B80}-\x{0BFF}`,
+	"Telugu":                             `\x{0C00}-\x{0C7F}`,
+	"Kannada":                            `\x{0C80}-\x{0CFF}`,
+	"Malayalam":                          `\x{0D00}-\x{0D7F}`,
+	"Sinhala":                            `\x{0D80}-\x{0DFF}`,
+	"Thai":                               `\x{0E00}-\x{0E7F}`,
+	"Lao":                                `\x{0E80}-\x{0EFF}`,
+	"Tibetan":                            `\x{0F00}-\x{0FFF}`,
+	"Myanmar":                            `\x{1000}-\x{109F}`,
+	"Georgian":                           `\x{10A0}-\x{10FF}`,
+	"HangulJamo":                         `\x{1100}-\x{11FF}`,
+	"Ethiopic":                           `\x{1200}-\x{137F}`,
+	"Cherokee":                           `\x{13A0}-\x{13FF}`,
+	"UnifiedCanadianAboriginalSyllabics": `\x{1400}-\x{167F}`,
+	"Ogham":                              `\x{1680}-\x{169F}`,
+	"Runic":                              `\x{16A0}-\x{16FF}`,
+	"Khmer":                              `\x{1780}-\x{17FF}`,
+	"Mongolian":                          `\x{1800}-\x{18AF}`,
+	"LatinExtendedAdditional":            `\x{1E00}-\x{1EFF}`,
+	"GreekExtended":                      `\x{1F00}-\x{1FFF}`,
+	"GeneralPunctuation":                 `\x{2000}-\x{206F}`,
+	"SuperscriptsandSubscripts":          `\x{2070}-\x{209F}`,
+	"CurrencySymbols":                    `\x{20A0}-\x{20CF}`,
+	"CombiningMarksforSymbols":           `\x{20D0}-\x{20FF}`,
+	"LetterlikeSymbols":                  `\x{2100}-\x{214F}`,
+	"NumberForms":                        `\x{2150}-\x{218F}`,
+	"Arrows":                             `\x{2190}-\x{21FF}`,
+	"MathematicalOperators":              `\x{2200}-\x{22FF}`,
+	"MiscellaneousTechnical":             `\x{2300}-\x{23FF}`,
+	"ControlPictures":                    `\x{2400}-\x{243F}`,
+	"OpticalCharacterRecognition":        `\x{2440}-\x{245F}`,
+	"EnclosedAlphanumerics":              `\x{2460}-\x{24FF}`,
+	"BoxDrawing":                         `\x{2500}-\x{257F}`,
+	"BlockElements":                      `\x{2580}-\x{259F}`,
+	"GeometricShapes":                    `\x{25A0}-\x{25FF}`,
+	"MiscellaneousSymbols":               `\x{2600}-\x{26FF}`,
+	"Dingbats":                           `\x{2700}-\x{27BF}`,
+	"BraillePatterns":                    `\x{2800}-\x{28FF}`,
+	"CJKRadicalsSupplement":              `\x{2E80}-\x{2EFF}`,
+	"KangxiRadicals":                     `\x{2F00}-\x{2FDF}`,
+	"IdeographicDescriptionCharacters":   `\x{2FF0}-\x{2FFF}`,
+	"CJKSymbolsandPunctuation":           `\x{3000}-\x{303F}`,
+	"Hiragana":                           `\x{3040}-\x{309F}`,
+	"Katakana":                           `\x{30A0}-\x{30FF}`,
+	"Bopomofo":                           `\x{3100}-\x{312F}`,
+	"HangulCompatibilityJamo":            `\x{3130}-\x{318F}`,
+	"Kanbun":                             `\x{3190}-\x{319F}`,
+	"BopomofoExtended":                   `\x{31A0}-\x{31BF}`,
+	"EnclosedCJKLettersandMonths":        `\x{3200}-\x{32FF}`,
+	"CJKCompatibility":                   `\x{3300}-\x{33FF}`,
+	"CJKUnifiedIdeographsExtensionA":     `\x{3400}-\x{4DB5}`,
+	"CJKUnifiedIdeographs":               `\x{4E00}-\x{9FFF}`,
+	"YiSyllables":                        `\x{A000}-\x{A48F}`,
+	"YiRadicals":                         `\x{A490}-\x{A4CF}`,
+	"HangulSyllables":                    `\x{AC00}-\x{D7A3}`,
+	"PrivateUse":                         `\x{E000}-\x{F8FF}`,
+	"CJKCompatibilityIdeographs":         `\x{F900}-\x{FAFF}`,
+	"AlphabeticPresentationForms":        `\x{FB00}-\x{FB4F}`,
+	"ArabicPresentationForms-A":          `\x{FB50}-\x{FDFF}`,
+	"CombiningHalfMarks":                 `\x{FE20}-\x{FE2F}`,
+	"CJKCompatibilityForms":              `\x{FE30}-\x{FE4F}`,
+	"SmallFormVariants":                  `\x{FE50}-\x{FE6F}`,
+	"ArabicPresentationForms-B":          `\x{FE70}-\x{FEFE}`,
+	"Specials":                           `\x{FEFF}\x{FFF0}-\x{FFFD}`,
+	"HalfwidthandFullwidthForms":         `\x{FF00}-\x{FFEF}`,
+}
+
+func parsePattern(pat string) (*regexp.Regexp, error) {
+	re, err := translatePattern(pat)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(re)
+}
+
+// translatePattern converts an XML Schema regular expression to the
+// syntax of package regexp.
+func translatePattern(pat string) (string, error) {
+	var (
+		buf     strings.Builder
+		inClass bool
+	)
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		switch {
+		case c == '[' && !inClass:
+			inClass = true
+			buf.WriteByte(c)
+			if i+1 < len(pat) && pat[i+1] == '^' {
+				buf.WriteByte('^')
+				i++
+			}
+			continue
+		case c == '[' && pat[i-1] == '-':
+			return "", fmt.Errorf("character class subtraction at offset %d is not supported", i-1)
+		case c == ']' && inClass:
+			inClass = false
+			buf.WriteByte(c)
+			continue
+		case c == '.' && !inClass:
+			buf.WriteString(`[^\n\r]`)
+			continue
+		case c != '\\' || i+1 == len(pat):
+			buf.WriteByte(c)
+			continue
+		}
+		start := i
+		i++
+		var class string
+		negate := false
+		// The contents of a class matching the characters that
+		// class does not, if it is negated and they can be listed.
+		var inverse string
+		switch esc := pat[i]; esc {
+		case 'w':
+			class, negate, inverse = nonWordClass, true, wordClass
+		case 'W':
+			class = nonWordClass
+		case 'd', 'D':
+			// A Unicode category, which RE2 knows, though it
+			// reads \d as [0-9].
+			if esc == 'd' {
+				buf.WriteString(`\p{Nd}`)
+			} else {
+				buf.WriteString(`\P{Nd}`)
+			}
+			continue
+		case 's':
+			class = spaceClass
+		case 'S':
+			class, negate, inverse = spaceClass, true, nonSpaceClass
+		case 'i', 'I':
+			class, negate = nameStartClass, esc == 'I'
+		case 'c', 'C':
+			class, negate = nameClass, esc == 'C'
+		case 'p', 'P':
+			end := strings.IndexByte(pat[i:], '}')
+			if !strings.HasPrefix(pat[i+1:], "{Is") || end < 0 {
+				// A Unicode category, which RE2 knows.
+				buf.WriteByte('\\')
+				buf.WriteByte(esc)
+				continue
+			}
+			name := pat[i+4 : i+end]
+			block, ok := unicodeBlocks[name]
+			if !ok {
+				return "", fmt.Errorf("unknown Unicode block %q", name)
+			}
+			class, negate = block, esc == 'P'
+			i += end
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(esc)
+			continue
+		}
+		switch {
+		case !inClass && negate:
+			buf.WriteString("[^" + class + "]")
+		case !inClass:
+			buf.WriteString("[" + class + "]")
+		case negate && inverse != "":
+			buf.WriteString(inverse)
+		case negate:
+			return "", fmt.Errorf("negated escape %s inside a character class is not supported", pat[start:i+1])
+		default:
+			buf.WriteString(class)
+		}
+	}
+	return buf.String(), nil
+}
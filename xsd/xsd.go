@@ -50,6 +50,10 @@ type Element struct {
 	// True if this element can have any name. See
 	// http://www.w3.org/TR/2004/REC-xmlschema-1-20041028/structures.html#element-any
 	Wildcard bool
+	// For a wildcard, the namespace attribute of the <any>
+	// element, which constrains the namespaces of the elements
+	// it matches. It is "##any" if the attribute is missing.
+	Namespace string
 	// Type of this element.
 	Type Type
 	// An abstract type does not appear in the xml document, but
@@ -59,12 +63,41 @@ type Element struct {
 	Plural bool
 	// True if the element is optional.
 	Optional bool
+	// The minimum and maximum number of times the element may
+	// appear. A MaxOccurs of -1 means there is no upper bound.
+	MinOccurs, MaxOccurs int
+	// If the element is one of the alternatives of a <choice>,
+	// Choice is the position, starting at 1, of that choice among
+	// the choices in its type's content model. Elements that are
+	// not part of a choice have a Choice of 0.
+	Choice int
+	// If the element is part of a sequence or choice that may
+	// appear more than once, Group is the position, starting at 1,
+	// of that group among the repeating groups of its type's content
+	// model, and GroupIndex is the position, starting at 0, of the
+	// particle of the group that holds the element. The alternatives
+	// of a choice share a position. GroupMinOccurs and GroupMaxOccurs
+	// are the bounds of the element within one repetition of the
+	// group. Elements that are not part of a repeating group have a
+	// Group of 0.
+	Group, GroupIndex              int
+	GroupMinOccurs, GroupMaxOccurs int
 	// If true, this element will be declared as a pointer.
 	Nillable bool
+	// The derivation methods by which the types of elements used in
+	// place of this one, through xsi:type or substitution groups, may
+	// not be derived, from the block attribute or the blockDefault
+	// of the schema. Final holds the methods by which the elements
+	// of a substitution group headed by this one may not be derived,
+	// from the final attribute or the finalDefault of the schema.
+	Block, Final Derivation
 	// Default overrides the zero value of this element.
 	Default string
 	// Any additional attributes provided in the <xs:element> element.
 	Attr []xml.Attr
+	// The elements within any <xs:appinfo> annotations, which
+	// carry information for tools such as code generators.
+	AppInfo []xmltree.Element
 	// Used for resolving prefixed strings in extra attribute values.
 	xmltree.Scope
 }
@@ -84,28 +117,56 @@ type Attribute struct {
 	Type Type
 	// True if this attribute has a <list> simpleType
 	Plural bool
+	// True if the attribute is declared with use="prohibited". In a
+	// complex type derived by restriction, this removes an attribute
+	// inherited from the base type.
+	Prohibited bool
+	// True if the attribute is declared with use="required".
+	Required bool
 	// Default overrides the zero value of this element.
 	Default string
 	// Any additional attributes provided in the <xs:attribute> element.
 	Attr []xml.Attr
+	// The elements within any <xs:appinfo> annotations.
+	AppInfo []xmltree.Element
 	// Used for resolving qnames in additional attributes.
 	xmltree.Scope
 }
 
 // A Schema is the decoded form of an XSD <schema> element. It contains
-// a collection of all types declared in the schema. Top-level elements
-// are not recorded in a Schema.
+// a collection of all types and top-level elements declared in the
+// schema.
 type Schema struct {
 	// The Target namespace of the schema. All types defined in this
 	// schema will be in this name space.
 	TargetNS string `xml:"targetNamespace,attr"`
 	// Types defined in this schema declaration
 	Types map[xml.Name]Type
+	// Top-level elements declared in this schema. Elements whose
+	// type cannot be found are left out.
+	Elements map[xml.Name]Element
+	// Notations declared in this schema, which the values of
+	// NOTATION attributes name.
+	Notations map[xml.Name]Notation
 	// Any annotations declared at the top-level of the schema, separated
 	// by new lines.
 	Doc string
 }
 
+// A Notation names a format, such as that of an image, for use as
+// the value of an attribute of type NOTATION.
+//
+// http://www.w3.org/TR/2004/REC-xmlschema-1-20041028/structures.html#element-notation
+type Notation struct {
+	// The canonical name of this notation.
+	Name xml.Name
+	// Annotations provided by the schema author.
+	Doc string
+	// The public and system identifiers of the format. At least
+	// one of them is set.
+	Public, System string
+}
+
 // FindType looks for a type by its canonical name. In addition to the types
 // declared in a Schema, FindType will also search through the types that
 // a Schema's top-level types are derived from. FindType will return nil if
@@ -165,6 +226,14 @@ type ComplexType struct {
 	// this type is derived by restricting the set of elements and
 	// attributes allowed in Base.
 	Extends bool
+	// The derivation methods by which types used in place of this
+	// one through xsi:type may not be derived, from the block
+	// attribute or the blockDefault of the schema.
+	Block Derivation
+	// The derivation methods by which no type may be derived from
+	// this one, from the final attribute or the finalDefault of the
+	// schema.
+	Final Derivation
 }
 
 func (*ComplexType) isType() {}
@@ -201,10 +270,34 @@ type SimpleType struct {
 	// The type this type is derived from. This is guaranteed to be
 	// part of a linked list that always ends in a Builtin type.
 	Base Type
+	// The elements within any <xs:appinfo> annotations.
+	AppInfo []xmltree.Element
+	// The derivation methods by which no type may be derived from
+	// this one, from the final attribute or the finalDefault of the
+	// schema.
+	Final Derivation
 }
 
 func (*SimpleType) isType() {}
 
+// A Derivation is a set of the methods by which a type may be derived
+// from another, or an element substituted for another. The block and
+// final attributes of a schema name the methods they forbid.
+type Derivation uint
+
+const (
+	DerivationExtension Derivation = 1 << iota
+	DerivationRestriction
+	DerivationSubstitution
+	DerivationList
+	DerivationUnion
+)
+
+// Has reports whether d includes all of the methods in m.
+func (d Derivation) Has(m Derivation) bool {
+	return d&m == m
+}
+
 // A SimpleType can be derived from a built-in or SimpleType by
 // restricting the set of values it may contain. The xsd package only
 // records restrictions that are useful for generating client libraries,
@@ -259,10 +352,14 @@ Loop:
 		case xml.EndElement:
 			break Loop
 		case xml.StartElement:
+			// Other children, such as <xs:appinfo>, are
+			// skipped. Skip consumes the element, so there is
+			// nothing left of it to decode as documentation.
 			if (tok.Name != xml.Name{schemaNS, "documentation"}) {
 				if err := d.Skip(); err != nil {
 					return err
 				}
+				continue
 			}
 			var frag []byte
 			if err := d.DecodeElement(&frag, &tok); err != nil {
@@ -1,8 +1,10 @@
 package xsd
 
 import (
+	"encoding/xml"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -33,3 +35,402 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestImportWithoutLocation(t *testing.T) {
+	main := []byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:xs="http://www.w3.org/2001/XMLSchema"
+		        xmlns:codes="http://www.example.com/codes"
+		        targetNamespace="http://www.example.com/orders">
+		  <import namespace="http://www.example.com/codes" />
+		  <complexType name="Order">
+		    <sequence>
+		      <element name="currency" type="codes:CurrencyCode" />
+		    </sequence>
+		    <attribute name="status" type="codes:Status" />
+		  </complexType>
+		</schema>`)
+	codes := []byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:xs="http://www.w3.org/2001/XMLSchema"
+		        targetNamespace="http://www.example.com/codes">
+		  <simpleType name="CurrencyCode">
+		    <restriction base="xs:string" />
+		  </simpleType>
+		  <simpleType name="Status">
+		    <restriction base="xs:token" />
+		  </simpleType>
+		</schema>`)
+
+	if _, err := Parse(main); err == nil {
+		t.Error("expected an error when the imported schema is not provided")
+	} else if !strings.Contains(err.Error(), "http://www.example.com/codes") {
+		t.Errorf("error does not name the missing namespace: %v", err)
+	} else {
+		t.Log(err)
+	}
+
+	schema, err := Parse(main, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order *ComplexType
+	for _, s := range schema {
+		if t, ok := s.Types[xml.Name{Space: "http://www.example.com/orders", Local: "Order"}]; ok {
+			order = t.(*ComplexType)
+		}
+	}
+	if order == nil {
+		t.Fatal("type Order not found")
+	}
+	want := xml.Name{Space: "http://www.example.com/codes", Local: "CurrencyCode"}
+	if got := order.Elements[0].Type; XMLName(got) != want {
+		t.Errorf("element currency has type %v, want %v", XMLName(got), want)
+	} else if _, ok := got.(*SimpleType); !ok {
+		t.Errorf("element currency has unresolved type %T", got)
+	}
+	want.Local = "Status"
+	if got := order.Attributes[0].Type; XMLName(got) != want {
+		t.Errorf("attribute status has type %v, want %v", XMLName(got), want)
+	}
+}
+
+func TestNestedParticles(t *testing.T) {
+	schema, err := Parse([]byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:tns="http://www.example.com/"
+		        targetNamespace="http://www.example.com/">
+		  <group name="Contact">
+		    <sequence>
+		      <element name="phone" type="string" />
+		      <element name="email" type="string" maxOccurs="unbounded" />
+		    </sequence>
+		  </group>
+		  <group name="Note">
+		    <sequence>
+		      <element name="note" type="string" />
+		    </sequence>
+		  </group>
+		  <complexType name="Person">
+		    <sequence>
+		      <element name="name" type="string" />
+		      <choice minOccurs="0">
+		        <group ref="tns:Contact" />
+		        <element name="address" type="string" />
+		      </choice>
+		      <sequence maxOccurs="unbounded">
+		        <choice>
+		          <group ref="tns:Note" />
+		          <element name="flag" type="string" />
+		        </choice>
+		      </sequence>
+		      <sequence minOccurs="0">
+		        <element name="nickname" type="string" />
+		      </sequence>
+		      <sequence maxOccurs="2">
+		        <element name="alias" type="string" maxOccurs="2" />
+		      </sequence>
+		    </sequence>
+		  </complexType>
+		</schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var person *ComplexType
+	for _, s := range schema {
+		if t, ok := s.Types[xml.Name{Space: "http://www.example.com/", Local: "Person"}]; ok {
+			person = t.(*ComplexType)
+		}
+	}
+	if person == nil {
+		t.Fatal("complexType Person not found")
+	}
+	type bounds struct {
+		optional, plural bool
+		min, max         int
+	}
+	want := map[string]bounds{
+		"name":     {false, false, 1, 1},
+		"phone":    {true, false, 0, 1},
+		"email":    {true, true, 0, -1},
+		"address":  {true, false, 0, 1},
+		"note":     {true, true, 0, -1},
+		"flag":     {true, true, 0, -1},
+		"nickname": {true, false, 0, 1},
+		"alias":    {false, true, 1, 4},
+	}
+	for _, el := range person.Elements {
+		w, ok := want[el.Name.Local]
+		if !ok {
+			t.Errorf("unexpected element %s", el.Name.Local)
+			continue
+		}
+		delete(want, el.Name.Local)
+		got := bounds{el.Optional, el.Plural, el.MinOccurs, el.MaxOccurs}
+		if got != w {
+			t.Errorf("element %s: got %+v, want %+v", el.Name.Local, got, w)
+		}
+	}
+	for name := range want {
+		t.Errorf("element %s not found", name)
+	}
+}
+
+func TestRepeatedGroups(t *testing.T) {
+	schema, err := Parse([]byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:tns="http://www.example.com/"
+		        targetNamespace="http://www.example.com/">
+		  <group name="Range">
+		    <sequence>
+		      <element name="low" type="int" />
+		      <element name="high" type="int" />
+		    </sequence>
+		  </group>
+		  <complexType name="Series">
+		    <sequence>
+		      <element name="name" type="string" />
+		      <sequence maxOccurs="unbounded">
+		        <element name="date" type="date" />
+		        <choice>
+		          <element name="value" type="decimal" />
+		          <element name="missing" type="string" />
+		        </choice>
+		        <element name="note" type="string" minOccurs="0" maxOccurs="3" />
+		      </sequence>
+		      <group ref="tns:Range" maxOccurs="2" />
+		      <sequence maxOccurs="unbounded">
+		        <element name="label" type="string" />
+		        <sequence maxOccurs="unbounded">
+		          <element name="x" type="int" />
+		          <element name="y" type="int" />
+		        </sequence>
+		      </sequence>
+		    </sequence>
+		  </complexType>
+		</schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series *ComplexType
+	for _, s := range schema {
+		if t, ok := s.Types[xml.Name{Space: "http://www.example.com/", Local: "Series"}]; ok {
+			series = t.(*ComplexType)
+		}
+	}
+	if series == nil {
+		t.Fatal("complexType Series not found")
+	}
+	type position struct {
+		group, index, min, max int
+	}
+	// The outer group of label is not recorded, as it repeats
+	// another group.
+	want := map[string]position{
+		"name":    {0, 0, 0, 0},
+		"date":    {1, 0, 1, 1},
+		"value":   {1, 1, 0, 1},
+		"missing": {1, 1, 0, 1},
+		"note":    {1, 2, 0, 3},
+		"low":     {2, 0, 1, 1},
+		"high":    {2, 1, 1, 1},
+		"label":   {0, 0, 0, 0},
+		"x":       {3, 0, 1, 1},
+		"y":       {3, 1, 1, 1},
+	}
+	for _, el := range series.Elements {
+		w, ok := want[el.Name.Local]
+		if !ok {
+			t.Errorf("unexpected element %s", el.Name.Local)
+			continue
+		}
+		got := position{el.Group, el.GroupIndex, el.GroupMinOccurs, el.GroupMaxOccurs}
+		if got != w {
+			t.Errorf("element %s: got %+v, want %+v", el.Name.Local, got, w)
+		}
+	}
+}
+
+func TestTopLevelElements(t *testing.T) {
+	schema, err := Parse([]byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:tns="http://www.example.com/"
+		        targetNamespace="http://www.example.com/">
+		  <element name="person" type="tns:Person" />
+		  <element name="note">
+		    <complexType>
+		      <sequence>
+		        <any namespace="##targetNamespace" />
+		      </sequence>
+		    </complexType>
+		  </element>
+		  <element name="anything" />
+		  <complexType name="Person">
+		    <sequence>
+		      <element name="name" type="string" />
+		    </sequence>
+		  </complexType>
+		</schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var elements map[xml.Name]Element
+	for _, s := range schema {
+		if s.TargetNS == "http://www.example.com/" {
+			elements = s.Elements
+		}
+	}
+	if len(elements) != 3 {
+		t.Fatalf("found %d top-level elements, want 3", len(elements))
+	}
+	name := func(local string) xml.Name {
+		return xml.Name{Space: "http://www.example.com/", Local: local}
+	}
+	if person, ok := elements[name("person")].Type.(*ComplexType); !ok || person.Name != name("Person") {
+		t.Errorf("element person has type %v, want Person", elements[name("person")].Type)
+	}
+	if b, ok := elements[name("anything")].Type.(Builtin); !ok || b != AnyType {
+		t.Errorf("element anything has type %v, want anyType", elements[name("anything")].Type)
+	}
+	note, ok := elements[name("note")].Type.(*ComplexType)
+	if !ok || len(note.Elements) != 1 {
+		t.Fatalf("element note has type %v, want a type with one element", elements[name("note")].Type)
+	}
+	if ns := note.Elements[0].Namespace; ns != "##targetNamespace" {
+		t.Errorf("wildcard has namespace %q, want ##targetNamespace", ns)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern       string
+		match, reject []string
+	}{
+		{`\i\c*`, []string{"name", "_x", "ns:a.b-c", "élan"}, []string{"1abc", "-a", "a b", ""}},
+		{`\p{IsBasicLatin}+`, []string{"abc", "A-1"}, []string{"abç", "日本"}},
+		{`[\p{IsGreek}\d]+`, []string{"αβγ", "α1"}, []string{"abc"}},
+		{`\P{IsBasicLatin}`, []string{"ç"}, []string{"c"}},
+		{`\d{3}-[A-Z]{2}`, []string{"123-AB"}, []string{"12-ABC"}},
+		{`\w+`, []string{"élan", "日本", "a1", "x+"}, []string{"a b", "a-b", "a.b", ""}},
+		{`\W`, []string{" ", "-", "\u00a0"}, []string{"é", "1"}},
+		{`\d+`, []string{"123", "١٢٣", "१२"}, []string{"1a", "²"}},
+		{`\D+`, []string{"abc", "é"}, []string{"١"}},
+		{`[\w.-]+`, []string{"élan.x-y"}, []string{"a b"}},
+		{`[\D\s]+`, []string{"a b"}, []string{"a1"}},
+		{`\s\S`, []string{" a", "\té"}, []string{"\f1", "  "}},
+		{`a.b`, []string{"a-b", "aéb"}, []string{"a\rb", "a\nb"}},
+	}
+	for _, tt := range tests {
+		re, err := parsePattern(`^(?:` + tt.pattern + `)$`)
+		if err != nil {
+			t.Errorf("parse %s: %v", tt.pattern, err)
+			continue
+		}
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("%s does not match %q", tt.pattern, s)
+			}
+		}
+		for _, s := range tt.reject {
+			if re.MatchString(s) {
+				t.Errorf("%s matches %q", tt.pattern, s)
+			}
+		}
+	}
+	for _, pattern := range []string{`[a-z-[aeiou]]`, `\p{IsKlingon}`, `[\I]`} {
+		if _, err := parsePattern(pattern); err == nil {
+			t.Errorf("parse %s: expected an error", pattern)
+		}
+	}
+}
+
+func TestDerivationDefaults(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/block-default.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An included document of the same namespace, whose components
+	// do not take the defaults of the other.
+	included := []byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        targetNamespace="http://example.com/blocking">
+		  <complexType name="Truck">
+		    <sequence>
+		      <element name="axles" type="int" />
+		    </sequence>
+		  </complexType>
+		</schema>`)
+	schema, err := Parse(data, included)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Schema
+	for _, v := range schema {
+		if v.TargetNS == "http://example.com/blocking" {
+			s = v
+		}
+	}
+	name := func(local string) xml.Name {
+		return xml.Name{Space: "http://example.com/blocking", Local: local}
+	}
+	vehicle := s.Types[name("Vehicle")].(*ComplexType)
+	if !vehicle.Block.Has(DerivationExtension) || vehicle.Block.Has(DerivationRestriction) {
+		t.Errorf("Vehicle blocks %b, want extension only", vehicle.Block)
+	}
+	if want := DerivationExtension | DerivationRestriction; vehicle.Final != want {
+		t.Errorf("Vehicle is final for %b, want %b", vehicle.Final, want)
+	}
+	car := s.Types[name("Car")].(*ComplexType)
+	if car.Block != 0 || car.Final != DerivationRestriction {
+		t.Errorf("Car blocks %b and is final for %b, want 0 and restriction", car.Block, car.Final)
+	}
+	if color := s.Types[name("Color")].(*SimpleType); color.Final != DerivationRestriction|DerivationList|DerivationUnion {
+		t.Errorf("Color is final for %b, want restriction, list and union", color.Final)
+	}
+	if truck := s.Types[name("Truck")].(*ComplexType); truck.Block != 0 || truck.Final != 0 {
+		t.Errorf("Truck, from a schema without defaults, blocks %b and is final for %b", truck.Block, truck.Final)
+	}
+	if el := s.Elements[name("vehicle")]; el.Block != DerivationExtension {
+		t.Errorf("element vehicle blocks %b, want extension", el.Block)
+	}
+	garage := s.Types[name("Garage")].(*ComplexType)
+	want := map[string]Derivation{
+		"car":   DerivationExtension | DerivationRestriction | DerivationSubstitution,
+		"spare": DerivationExtension,
+	}
+	for _, el := range garage.Elements {
+		if el.Block != want[el.Name.Local] {
+			t.Errorf("element %s of Garage blocks %b, want %b", el.Name.Local, el.Block, want[el.Name.Local])
+		}
+	}
+}
+
+func TestAnnotationAppInfo(t *testing.T) {
+	schema, err := Parse([]byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        targetNamespace="http://example.com/appinfo">
+		  <simpleType name="Amount">
+		    <annotation>
+		      <appinfo><goType>decimal.Decimal</goType></appinfo>
+		      <documentation>A sum of money.</documentation>
+		    </annotation>
+		    <restriction base="string" />
+		  </simpleType>
+		</schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Schema
+	for _, v := range schema {
+		if v.TargetNS == "http://example.com/appinfo" {
+			s = v
+		}
+	}
+	amount := s.Types[xml.Name{Space: "http://example.com/appinfo", Local: "Amount"}].(*SimpleType)
+	if amount.Doc != "A sum of money." {
+		t.Errorf("Amount has documentation %q after its appinfo", amount.Doc)
+	}
+	if len(amount.AppInfo) != 1 || amount.AppInfo[0].Name.Local != "goType" {
+		t.Errorf("Amount has appinfo %v, want goType", amount.AppInfo)
+	}
+}
@@ -0,0 +1,194 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// When a schema changes between versions, the Go names of its types
+// and fields may change with it, but the names of most elements and
+// attributes do not. The FieldByXMLName methods generated here let
+// users write code that moves data between two versions of the
+// generated types by the names used on the wire.
+func (cfg *Config) addFieldAccessors(decls map[string]spec) error {
+	for name, s := range decls {
+		str, ok := s.expr.(*ast.StructType)
+		if !ok || hasMethod(s, "FieldByXMLName") {
+			continue
+		}
+		fn, err := cfg.genFieldAccessor(s, str, decls)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+func (cfg *Config) genFieldAccessor(s spec, str *ast.StructType, decls map[string]spec) (*ast.FuncDecl, error) {
+	var cases, embedded bytes.Buffer
+	for _, field := range str.Fields.List {
+		if len(field.Names) == 0 {
+			// The fields of an embedded struct type are
+			// looked up by its own method.
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				if base, ok := decls[ident.Name]; ok {
+					if _, ok := base.expr.(*ast.StructType); ok {
+						fmt.Fprintf(&embedded, `
+							if value, ok := v.%s.FieldByXMLName(name); ok {
+								return value, true
+							}`, ident.Name)
+					}
+				}
+			}
+			continue
+		}
+		space, local, ok := fieldXMLTag(field)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&cases, `
+			case xml.Name{Space: %q, Local: %q}:
+				return v.%s, true`, space, local, field.Names[0].Name)
+	}
+	fn, err := gen.Func("FieldByXMLName").
+		Comment("// FieldByXMLName returns the value of the field that holds the\n"+
+			"// element or attribute called name, and whether there is one.\n"+
+			"// Attributes are named without a namespace.").
+		Receiver("v *"+s.name).
+		Args("name xml.Name").
+		Returns("interface{}", "bool").
+		Body(`
+			switch name {
+			%s
+			}
+			%s
+			return nil, false
+		`, cases.String(), embedded.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("FieldByXMLName %s: %v", s.name, err)
+	}
+	return fn, nil
+}
+
+// Logging a whole message to see one part of it is wasteful when the
+// message is large. The MarshalField methods generated here encode a
+// single field, including those promoted from embedded structs, as
+// encoding/xml would encode it within its struct: an element with
+// its namespace, or an attribute.
+func (cfg *Config) addFieldMarshalers(decls map[string]spec) error {
+	for name, s := range decls {
+		str, ok := s.expr.(*ast.StructType)
+		if !ok || hasMethod(s, "MarshalField") {
+			continue
+		}
+		fn, err := cfg.genFieldMarshaler(s, marshalFields(s, str, decls, "v.", 0))
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+func (cfg *Config) genFieldMarshaler(s spec, fields []marshalField) (*ast.FuncDecl, error) {
+	var cases bytes.Buffer
+	for _, f := range fields {
+		fmt.Fprintf(&cases, `
+			case %q:
+				return _marshalFragment(struct {
+					XMLName xml.Name
+					%s %s %s
+				}{xml.Name{Local: "_"}, %s})`, f.name, f.name, f.typ, f.tag, f.value)
+	}
+	fn, err := gen.Func("MarshalField").
+		Comment("// MarshalField returns the XML encoding of the field of v called\n"+
+			"// name: the element it holds, or the attribute, as in name=\"value\".").
+		Receiver("v *"+s.name).
+		Args("name string").
+		Returns("[]byte", "error").
+		Body(`
+			switch name {
+			%s
+			}
+			return nil, fmt.Errorf("%s has no field %%q", name)
+		`, cases.String(), s.name).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalField %s: %v", s.name, err)
+	}
+	return fn, nil
+}
+
+// genFragmentHelper generates the function that MarshalField methods
+// use to remove the element that a field is encoded in.
+func (cfg *Config) genFragmentHelper() ([]ast.Decl, error) {
+	fn, err := gen.Func("_marshalFragment").
+		Args("v interface{}").
+		Returns("[]byte", "error").
+		Body(`
+			data, err := xml.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			// data is <_ attr="value">content</_>. A > in the
+			// value of an attribute is escaped.
+			end := bytes.IndexByte(data, '>')
+			attr := bytes.TrimSpace(data[len("<_"):end])
+			content := data[end+1 : len(data)-len("</_>")]
+			return append(attr, content...), nil
+		`).
+		Decl()
+	if err != nil {
+		return nil, err
+	}
+	return []ast.Decl{fn}, nil
+}
+
+// fieldXMLTag returns the name of the element or attribute held by
+// a struct field, from its xml tag. ok is false if the field holds
+// neither, such as a chardata or wildcard field.
+func fieldXMLTag(field *ast.Field) (space, local string, ok bool) {
+	space, local, flags, ok := xmlTag(field)
+	for _, flag := range flags {
+		switch flag {
+		case "chardata", "innerxml", "comment", "any":
+			return "", "", false
+		}
+	}
+	return space, local, ok && local != ""
+}
+
+// xmlTag splits the xml tag of a struct field into the name and the
+// flags that follow it. ok is false if the field has no xml tag.
+func xmlTag(field *ast.Field) (space, local string, flags []string, ok bool) {
+	if field.Tag == nil {
+		return "", "", nil, false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", "", nil, false
+	}
+	value, ok := reflect.StructTag(tag).Lookup("xml")
+	if !ok {
+		return "", "", nil, false
+	}
+	parts := strings.Split(value, ",")
+	switch name := strings.Fields(parts[0]); len(name) {
+	case 0:
+	case 1:
+		local = name[0]
+	default:
+		space, local = name[0], name[1]
+	}
+	return space, local, parts[1:], true
+}
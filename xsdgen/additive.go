@@ -0,0 +1,136 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// In additive mode, the generated declarations live between two marker
+// comments in the output file. On each run, only the code between the
+// markers is replaced; the package clause, imports and any code that
+// the user has written outside of the markers are kept. Imports are
+// fixed up afterwards, so generated code may depend on packages that
+// the user's code does not import, and vice versa.
+const (
+	beginGenerated = "// xsdgen: begin generated code. DO NOT EDIT."
+	endGenerated   = "// xsdgen: end generated code."
+)
+
+// mergeGenerated combines the formatted Go source generated by xsdgen
+// with the existing contents of the output file. If existing is nil,
+// the generated source is returned, with its declarations enclosed
+// in markers.
+func (cfg *Config) mergeGenerated(filename string, existing, generated []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	genFile, err := parser.ParseFile(fset, "", generated, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	start := fset.Position(genFile.Name.End()).Offset
+	for _, decl := range genFile.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			start = fset.Position(d.End()).Offset
+		}
+	}
+	header := generated[:start]
+	body := bytes.TrimSpace(generated[start:])
+
+	var buf bytes.Buffer
+	if existing == nil {
+		buf.Write(header)
+		fmt.Fprintf(&buf, "\n\n%s\n\n%s\n\n%s\n", beginGenerated, body, endGenerated)
+		return buf.Bytes(), nil
+	}
+
+	begin := bytes.Index(existing, []byte(beginGenerated))
+	end := bytes.Index(existing, []byte(endGenerated))
+	if begin < 0 || end < begin {
+		return nil, fmt.Errorf("%s exists and has no generated code section; "+
+			"add the lines %q and %q to mark where generated code should go",
+			filename, beginGenerated, endGenerated)
+	}
+	user := make([]byte, 0, len(existing))
+	user = append(user, existing[:begin]...)
+	user = append(user, existing[end+len(endGenerated):]...)
+	if err := checkCollisions(filename, user, genFile); err != nil {
+		return nil, err
+	}
+	buf.Write(existing[:begin])
+	fmt.Fprintf(&buf, "%s\n\n%s\n\n%s", beginGenerated, body, endGenerated)
+	buf.Write(existing[end+len(endGenerated):])
+	return imports.Process(filename, buf.Bytes(), nil)
+}
+
+// checkCollisions returns an error if the user code in src declares any
+// of the top-level names declared by the generated code.
+func checkCollisions(filename string, src []byte, generated *ast.File) error {
+	user, err := parser.ParseFile(token.NewFileSet(), filename, src, 0)
+	if err != nil {
+		return err
+	}
+	names := topLevelNames(user)
+	var conflicts []string
+	for name := range topLevelNames(generated) {
+		if names[name] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("%s: code outside the generated section declares %s, which xsdgen generates",
+			filename, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// topLevelNames returns the package-level identifiers declared in
+// a file. Methods are identified as Type.Method.
+func topLevelNames(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		for _, name := range declNames(decl) {
+			names[name] = true
+		}
+	}
+	delete(names, "_")
+	return names
+}
+
+// declNames returns the identifiers declared by a top-level
+// declaration, in the form used by topLevelNames.
+func declNames(decl ast.Decl) []string {
+	var names []string
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		name := d.Name.Name
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			recv := d.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				name = ident.Name + "." + name
+			}
+		}
+		names = append(names, name)
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, ident := range s.Names {
+					names = append(names, ident.Name)
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,88 @@
+package xsdgen
+
+import (
+	"strings"
+
+	"github.com/lajonat/go-xml/xmltree"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// Schema authors can control the generated code from the schema
+// itself, with directives in <xs:appinfo> annotations:
+//
+//	<xs:simpleType name="Timestamp">
+//	  <xs:annotation>
+//	    <xs:appinfo>
+//	      <goType>time.Time</goType>
+//	    </xs:appinfo>
+//	  </xs:annotation>
+//	  ...
+//
+// The goType directive replaces the type generated for a simpleType
+// with an existing Go type, which must implement encoding.TextMarshaler
+// and encoding.TextUnmarshaler, or be a built-in type. If the type is
+// not in the standard library, its import path is given with a goImport
+// directive. The jsonTag directive, on an element or attribute, adds
+// a json struct tag to its field. Other directives are ignored.
+var appInfoDirectives = map[string]bool{
+	"goType":   true,
+	"goImport": true,
+	"jsonTag":  true,
+}
+
+// appInfo returns the values of the directives recognized by xsdgen
+// in the appinfo of a schema component.
+func appInfo(info []xmltree.Element) map[string]string {
+	var result map[string]string
+	for _, el := range info {
+		if !appInfoDirectives[el.Name.Local] {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[el.Name.Local] = strings.TrimSpace(string(el.Content))
+	}
+	return result
+}
+
+// logAppInfo logs the appinfo directives of a type, and of its
+// elements and attributes, that xsdgen does not recognize.
+func (cfg *Config) logAppInfo(t xsd.Type) {
+	check := func(component string, info []xmltree.Element) {
+		for _, el := range info {
+			if !appInfoDirectives[el.Name.Local] {
+				cfg.debugf("%s: ignoring unknown appinfo directive %s", component, el.Name.Local)
+			}
+		}
+	}
+	switch t := t.(type) {
+	case *xsd.SimpleType:
+		check("simpleType "+t.Name.Local, t.AppInfo)
+	case *xsd.ComplexType:
+		for _, el := range t.Elements {
+			check(t.Name.Local+" element "+el.Name.Local, el.AppInfo)
+		}
+		for _, attr := range t.Attributes {
+			check(t.Name.Local+" attribute "+attr.Name.Local, attr.AppInfo)
+		}
+	}
+}
+
+// goType returns the Go type that a simpleType is replaced with by
+// its appinfo, or the empty string.
+func goType(t *xsd.SimpleType) string {
+	return appInfo(t.AppInfo)["goType"]
+}
+
+// appInfoImport returns the package name and import path of the Go
+// type that replaces a simpleType, if its appinfo has a goImport
+// directive.
+func appInfoImport(t *xsd.SimpleType) (name, importPath string) {
+	info := appInfo(t.AppInfo)
+	i := strings.LastIndex(info["goType"], ".")
+	if i < 0 || info["goImport"] == "" {
+		return "", ""
+	}
+	return strings.TrimPrefix(info["goType"][:i], "*"), info["goImport"]
+}
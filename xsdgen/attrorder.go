@@ -0,0 +1,62 @@
+package xsdgen
+
+import (
+	"sort"
+
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// encoding/xml writes attributes in the order of their struct fields.
+// That is not always the order they are declared in: a type derived
+// by restriction lists its own attributes before those it inherits,
+// and the CoreType option moves optional attributes to another
+// struct. Consumers that expect a particular order, such as those
+// that check signatures computed over the document, are given the
+// declared order by the MarshalXML methods of addStructMarshalers.
+
+// declaredAttributes returns the position of each attribute of a type
+// in the order it is declared, counting the attributes of the types it
+// is derived from first. Attributes are keyed by their local names, as
+// in the tags of their fields.
+func declaredAttributes(t *xsd.ComplexType) map[string]int {
+	var chain []*xsd.ComplexType
+	for c := t; c != nil; {
+		chain = append(chain, c)
+		c, _ = c.Base.(*xsd.ComplexType)
+	}
+	order := make(map[string]int)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, attr := range chain[i].Attributes {
+			if _, ok := order[attr.Name.Local]; !ok {
+				order[attr.Name.Local] = len(order)
+			}
+		}
+	}
+	return order
+}
+
+// orderAttributes returns the fields with the fields that hold
+// attributes moved before the others, in the order the attributes are
+// declared. Attributes that are not declared keep their field order,
+// after those that are.
+func orderAttributes(t *xsd.ComplexType, fields []marshalField) []marshalField {
+	order := declaredAttributes(t)
+	rank := func(f marshalField) int {
+		if i, ok := order[f.attrName.Local]; ok {
+			return i
+		}
+		return len(order)
+	}
+	var attrs, others []marshalField
+	for _, f := range fields {
+		if f.attr {
+			attrs = append(attrs, f)
+		} else {
+			others = append(others, f)
+		}
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		return rank(attrs[i]) < rank(attrs[j])
+	})
+	return append(attrs, others...)
+}
@@ -0,0 +1,143 @@
+package xsdgen
+
+import (
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// Builders are generated from the final struct expression of a type,
+// after all user-defined post-processing has been done, so that they
+// always agree with the fields that end up in the Go source. For a
+// struct type Person, genBuilder generates
+//
+//	type PersonBuilder struct {
+//		v Person
+//	}
+//	func NewPersonBuilder() *PersonBuilder
+//	func (b *PersonBuilder) WithName(v string) *PersonBuilder
+//	func (b *PersonBuilder) AddPhone(v string) *PersonBuilder
+//	func (b *PersonBuilder) Build() Person
+//
+// Repeating fields get an Add method that appends a single item, and
+// pointer fields get a With method that takes the pointed-to value.
+// If Person has a Validate method, from the EmitValidators option,
+// Build returns (Person, error) instead, with the error from Validate.
+func (cfg *Config) genBuilder(s spec) (spec, bool) {
+	if _, ok := s.xsdType.(*xsd.ComplexType); !ok {
+		return spec{}, false
+	}
+	str, ok := s.expr.(*ast.StructType)
+	if !ok {
+		return spec{}, false
+	}
+	name := s.name + "Builder"
+	cfg.debugf("generating builder %s for type %s", name, s.name)
+	b := spec{
+		name:    name,
+		expr:    gen.Struct(ast.NewIdent("v"), ast.NewIdent(s.name), nil),
+		xsdType: s.xsdType,
+	}
+	newBuilder := gen.Func("New"+name).
+		Returns("*"+name).
+		Body(`return new(%s)`, name)
+	if hasMethod(s, "New"+s.name) {
+		// The builder starts from the value returned by the
+		// constructor, so that Build returns empty slices.
+		newBuilder.Body(`return &%s{v: *New%s()}`, name, s.name)
+	}
+	build := gen.Func("Build").
+		Receiver("b *" + name).
+		Returns(s.name).
+		Body(`return b.v`)
+	if hasMethod(s, "Validate") {
+		build.Returns(s.name, "error").
+			Body(`
+				if err := b.v.Validate(); err != nil {
+					return b.v, err
+				}
+				return b.v, nil
+			`)
+	}
+	fns := []*gen.Function{newBuilder, build}
+	for _, field := range str.Fields.List {
+		var fieldName string
+		if len(field.Names) > 0 {
+			fieldName = field.Names[0].Name
+		} else {
+			// embedded structs are set as a whole
+			fieldName = gen.ExprString(field.Type)
+			if star, ok := field.Type.(*ast.StarExpr); ok {
+				fieldName = gen.ExprString(star.X)
+			}
+		}
+		if !ast.IsExported(fieldName) {
+			continue
+		}
+		switch typ := field.Type.(type) {
+		case *ast.ArrayType:
+			if isByteSlice(typ) {
+				break
+			}
+			fns = append(fns, gen.Func("Add"+fieldName).
+				Receiver("b *"+name).
+				Args("v "+gen.ExprString(typ.Elt)).
+				Returns("*"+name).
+				Body(`
+					b.v.%[1]s = append(b.v.%[1]s, v)
+					return b
+				`, fieldName))
+			continue
+		case *ast.StarExpr:
+			fns = append(fns, gen.Func("With"+fieldName).
+				Receiver("b *"+name).
+				Args("v "+gen.ExprString(typ.X)).
+				Returns("*"+name).
+				Body(`
+					b.v.%s = &v
+					return b
+				`, fieldName))
+			continue
+		}
+		fns = append(fns, gen.Func("With"+fieldName).
+			Receiver("b *"+name).
+			Args("v "+gen.ExprString(field.Type)).
+			Returns("*"+name).
+			Body(`
+				b.v.%s = v
+				return b
+			`, fieldName))
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			cfg.logf("error generating builder for %s: %v", s.name, err)
+			return spec{}, false
+		}
+		b.methods = append(b.methods, decl)
+	}
+	return b, true
+}
+
+func isByteSlice(t *ast.ArrayType) bool {
+	ident, ok := t.Elt.(*ast.Ident)
+	return ok && t.Len == nil && ident.Name == "byte"
+}
+
+func (cfg *Config) addBuilders(decls map[string]spec) error {
+	var builders []spec
+	for _, s := range decls {
+		if b, ok := cfg.genBuilder(s); ok {
+			builders = append(builders, b)
+		}
+	}
+	for _, b := range builders {
+		if _, ok := decls[b.name]; ok {
+			return fmt.Errorf("builder %s conflicts with a generated type of the same name", b.name)
+		}
+		decls[b.name] = b
+	}
+	return nil
+}
@@ -24,19 +24,20 @@ var builtinTbl = []ast.Expr{
 	xsd.NCName:       &ast.Ident{Name: "string"},
 	xsd.NMTOKEN:      &ast.Ident{Name: "string"},
 	xsd.NMTOKENS:     &ast.ArrayType{Elt: &ast.Ident{Name: "string"}},
-	xsd.NOTATION:     &ast.ArrayType{Elt: &ast.Ident{Name: "string"}},
+	xsd.NOTATION:     &ast.Ident{Name: "string"},
 	xsd.Name:         &ast.Ident{Name: "string"},
 	xsd.QName:        &ast.Ident{Name: "xml.Name"},
 	xsd.AnyURI:       &ast.Ident{Name: "string"},
 	xsd.Base64Binary: &ast.ArrayType{Elt: &ast.Ident{Name: "byte"}},
 	xsd.Boolean:      &ast.Ident{Name: "bool"},
-	xsd.Byte:         &ast.Ident{Name: "byte"},
+	xsd.Byte:         &ast.Ident{Name: "int8"},
 	xsd.Date:         &ast.Ident{Name: "xsdDate"},
 	xsd.DateTime:     &ast.Ident{Name: "xsdDateTime"},
 	xsd.Decimal:      &ast.Ident{Name: "float64"},
 	xsd.Double:       &ast.Ident{Name: "float64"},
 	// the "duration" built-in is especially broken, so we
-	// don't parse it at all.
+	// don't parse it at all, unless the UseDurationType
+	// option is used.
 	xsd.Duration:           &ast.Ident{Name: "string"},
 	xsd.Float:              &ast.Ident{Name: "float32"},
 	xsd.GDay:               &ast.Ident{Name: "gDay"},
@@ -9,7 +9,9 @@ import (
 	"go/format"
 	"go/token"
 	"io/ioutil"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/lajonat/go-xml/xsd"
@@ -52,14 +54,30 @@ func (s *stringSlice) String() string {
 	return strings.Join(*s, ",")
 }
 
+// Set adds the comma-separated values in val that are not
+// already in the slice.
 func (s *stringSlice) Set(val string) error {
-	*s = append(*s, val)
+Outer:
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		for _, have := range *s {
+			if have == v {
+				continue Outer
+			}
+		}
+		*s = append(*s, v)
+	}
 	return nil
 }
 
 // GenAST creates an *ast.File containing type declarations and
 // associated methods based on a set of XML schema.
 func (cfg *Config) GenAST(files ...string) (*ast.File, error) {
+	if cfg.prefixErr != nil {
+		return nil, cfg.prefixErr
+	}
 	data := make([][]byte, 0, len(files))
 	for _, filename := range files {
 		b, err := ioutil.ReadFile(filename)
@@ -70,19 +88,70 @@ func (cfg *Config) GenAST(files ...string) (*ast.File, error) {
 		data = append(data, b)
 	}
 	if len(cfg.namespaces) == 0 {
-		cfg.debugf("setting namespaces to %s", cfg.namespaces)
-		cfg.Option(Namespaces(lookupTargetNS(data...)...))
+		var namespaces []string
+		for _, ns := range lookupTargetNS(data...) {
+			if !cfg.skippedNamespaces[ns] {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		cfg.debugf("setting namespaces to %s", namespaces)
+		cfg.Option(Namespaces(namespaces...))
 	}
 	deps, err := xsd.Parse(data...)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.emitSchemaDigest {
+		if cfg.schemaDigest, err = SchemaDigest(data...); err != nil {
+			return nil, err
+		}
+		defer func() { cfg.schemaDigest = "" }()
+	}
+	schemas := make([]*xsd.Schema, 0, len(deps))
+	for i := range deps {
+		schemas = append(schemas, &deps[i])
+	}
+	return cfg.GenFromSchema(schemas...)
+}
+
+// GenFromSchema is like GenAST, but generates code from schema that
+// have already been parsed, such as those returned by xsd.Parse and
+// modified by the caller. Code is generated for the schema in the
+// namespaces set with the Namespaces option; the other schema are used
+// to look up the types they refer to. If no namespaces are set, code is
+// generated for all of the schema except the standard schema that
+// xsd.Parse adds.
+func (cfg *Config) GenFromSchema(schemas ...*xsd.Schema) (*ast.File, error) {
+	if cfg.prefixErr != nil {
+		return nil, cfg.prefixErr
+	}
+	deps := make([]xsd.Schema, 0, len(schemas))
+	for _, s := range schemas {
+		deps = append(deps, *s)
+	}
+	standard := make(map[string]bool)
+	for _, ns := range lookupTargetNS(xsd.StandardSchema...) {
+		standard[ns] = true
+	}
+	if len(cfg.namespaces) == 0 {
+		var namespaces []string
+		for _, s := range deps {
+			if !standard[s.TargetNS] && !cfg.skippedNamespaces[s.TargetNS] {
+				namespaces = append(namespaces, s.TargetNS)
+			}
+		}
+		sort.Strings(namespaces)
+		cfg.debugf("setting namespaces to %s", namespaces)
+		cfg.Option(Namespaces(namespaces...))
+	}
+	// Generate the namespaces in a fixed order, so that the order
+	// of the declarations in the file does not depend on the order
+	// of the schema.
 	primaries := make([]xsd.Schema, 0, len(cfg.namespaces))
-	for _, s := range deps {
-		for _, ns := range cfg.namespaces {
+	for _, ns := range cfg.namespaces {
+		for _, s := range deps {
 			if s.TargetNS == ns {
 				primaries = append(primaries, s)
-				break
 			}
 		}
 	}
@@ -91,13 +160,34 @@ func (cfg *Config) GenAST(files ...string) (*ast.File, error) {
 			cfg.namespaces)
 	}
 
+	if err := cfg.resolveNameCollisions(deps, standard); err != nil {
+		return nil, err
+	}
+
+	cfg.rootTypes = nil
+	cfg.globalElements = nil
+	cfg.appInfoImports = nil
 	var file *ast.File
 	for _, s := range primaries {
 		f, err := cfg.genAST(s, deps...)
 		if err != nil {
 			return nil, err
 		}
-		file = mergeASTFile(file, f)
+		if file != nil && file.Name.Name != f.Name.Name {
+			return nil, fmt.Errorf("cannot generate packages %s and %s (for namespace %s) into one file",
+				file.Name.Name, f.Name.Name, s.TargetNS)
+		}
+		if file, err = mergeASTFile(file, f); err != nil {
+			return nil, err
+		}
+	}
+	if file != nil {
+		cfg.addImports(file)
+		helpers, err := cfg.genDocumentHelpers(file)
+		if err != nil {
+			return nil, err
+		}
+		file.Decls = append(file.Decls, helpers...)
 	}
 
 	return file, nil
@@ -137,15 +227,16 @@ func (cfg *Config) GenCLI(arguments ...string) error {
 		fs           = flag.NewFlagSet("xsdgen", flag.ExitOnError)
 		packageName  = fs.String("pkg", "", "name of the the generated package")
 		output       = fs.String("o", "xsdgen_output.go", "name of the output file")
+		additive     = fs.Bool("a", false, "only replace the generated code section of the output file")
 		verbose      = fs.Bool("v", false, "print verbose output")
 		debug        = fs.Bool("vv", false, "print debug output")
 	)
 	fs.Var(&replaceRules, "r", "replacement rule 'regex -> repl' (can be used multiple times)")
-	fs.Var(&xmlns, "ns", "target namespace(s) to generate types for")
+	fs.Var(&xmlns, "ns", "target namespace(s) to generate types for (can be used multiple times, or be a comma-separated list)")
 
 	fs.Parse(arguments)
 	if fs.NArg() == 0 {
-		return errors.New("Usage: xsdgen [-ns xmlns] [-r rule] [-o file] [-pkg pkg] file ...")
+		return errors.New("Usage: xsdgen [-a] [-ns xmlns] [-r rule] [-o file] [-pkg pkg] file ...")
 	}
 	if *debug {
 		cfg.Option(LogLevel(5))
@@ -159,6 +250,9 @@ func (cfg *Config) GenCLI(arguments ...string) error {
 	if *packageName != "" {
 		cfg.Option(PackageName(*packageName))
 	}
+	if *additive {
+		cfg.Option(Additive())
+	}
 
 	file, err := cfg.GenAST(fs.Args()...)
 	if err != nil {
@@ -174,5 +268,14 @@ func (cfg *Config) GenCLI(arguments ...string) error {
 	if err != nil {
 		return err
 	}
+	if cfg.additive {
+		existing, err := ioutil.ReadFile(*output)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if out, err = cfg.mergeGenerated(*output, existing, out); err != nil {
+			return err
+		}
+	}
 	return ioutil.WriteFile(*output, out, 0666)
 }
@@ -33,6 +33,102 @@ type Config struct {
 	filterTypes propertyFilter
 	// Transform for names
 	nameTransform func(xml.Name) xml.Name
+	// Generate fluent builders for complex types
+	emitBuilders bool
+	// Namespace prefixes pinned by the user, keyed by namespace URI
+	prefixes map[string]string
+	// Conflict between pinned prefixes, found when they were pinned
+	prefixErr error
+	// Go import paths for namespaces, keyed by namespace URI
+	packages map[string]string
+	// Map xs:duration to a struct type instead of a string
+	durationType bool
+	// Declare constants for enumerated values
+	enumConstants bool
+	// Generate Validate methods
+	emitValidators bool
+	// Generate the DecodeStream function
+	streamDecoder bool
+	// Generate MarshalText and UnmarshalText for simpleTypes
+	textMarshalers bool
+	// Keep user code in the output file of GenCLI
+	additive bool
+	// User-defined struct tags, in addition to xml tags
+	extraTags []extraTag
+	// Generate the Unmarshal function
+	unmarshalHelper bool
+	// Generate MarshalJSON and UnmarshalJSON for choices and unions
+	emitJSON bool
+	// Import paths of the Go types named by appinfo, keyed by package name
+	appInfoImports map[string]string
+	// The XML declaration written by MarshalDocument
+	xmlDeclaration string
+	// Go names of struct fields, overriding the default
+	fieldNames map[fieldKey]string
+	// Struct fields to leave out
+	droppedFields map[fieldKey]bool
+	// Go names of types whose names collide with types in other
+	// namespaces
+	typeNames map[xml.Name]string
+	// Generate FieldByXMLName methods
+	emitFieldAccessors bool
+	// Generate EstimatedXMLSize methods
+	emitSize bool
+	// Types to split into required and optional parts
+	coreTypes map[xml.Name]bool
+	// Generate MarshalXML methods that leave out empty wrappers
+	omitEmptyWrappers bool
+	// Generate typed values for wildcards of the target namespace
+	typedWildcards bool
+	// Declare constants for the target namespaces
+	namespaceConstants bool
+	// Generate MarshalXML methods that order attributes as declared
+	attributeOrder bool
+	// Generate UnmarshalXML methods that validate while decoding
+	validateOnDecode bool
+	// Namespaces whose types are never declared
+	skippedNamespaces map[string]bool
+	// Generate the RoundTripOK function
+	roundTripTest bool
+	// Go types of top-level elements, for RoundTripOK
+	rootTypes map[xml.Name]string
+	// Use xsdFlag for optional elements with no content
+	emptyElementsAsBool bool
+	// Generate MarshalField methods
+	emitFieldMarshalers bool
+	// How decoders handle integers that are out of range
+	overflowPolicy OverflowPolicy
+	// Struct types declared for repeating groups
+	groupTypes map[*xsd.ComplexType]bool
+	// Declare xml.Name variables for top-level elements
+	elementNames bool
+	// Top-level elements of the target namespaces
+	globalElements []xml.Name
+	// Decode child elements in the wrong namespace by local name
+	lenientNamespaces bool
+	// Declare the SchemaDigest constant
+	emitSchemaDigest bool
+	// Digest of the schema documents read by GenAST
+	schemaDigest string
+	// Generate Walk methods
+	emitVisitor bool
+	// Generate constructors that make empty slices
+	nonNilSlices bool
+	// Local names of the notations declared in the schema
+	notations []string
+	// Keep the elements of repeating groups together
+	repeatingGroups bool
+	// Complex types that other complex types extend
+	extendedTypes map[*xsd.ComplexType]bool
+	// Struct types of CoreType types before they were split, for
+	// those whose fields are no longer in schema order
+	splitStructs map[string]*ast.StructType
+}
+
+// A fieldKey identifies an element or attribute of a complexType.
+type fieldKey struct {
+	typ  xml.Name
+	name string
 }
 
 func (cfg *Config) helper(name string) *ast.FuncDecl {
@@ -103,6 +199,55 @@ func (cfg *Config) Option(opts ...Option) (previous Option) {
 	return previous
 }
 
+// NamespacePrefix pins the prefix used for the XML namespace uri in
+// documents marshalled with the generated MarshalDocument function,
+// which is included in the Go source when at least one prefix is
+// pinned. Struct tags always refer to namespaces by their URI, since
+// encoding/xml resolves prefixes before matching elements. An empty
+// prefix removes a previously pinned prefix. Pinning the same prefix
+// to more than one namespace, or pinning the reserved prefixes xml and
+// xmlns, is an error. It is found when the option is applied, and
+// GenAST and GenFromSchema return it before reading any schema.
+func NamespacePrefix(uri, prefix string) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.prefixes[uri]
+		if prefix == "" {
+			delete(cfg.prefixes, uri)
+		} else {
+			if cfg.prefixes == nil {
+				cfg.prefixes = make(map[string]string)
+			}
+			cfg.prefixes[uri] = prefix
+		}
+		cfg.prefixErr = cfg.checkPrefixes()
+		return NamespacePrefix(uri, prev)
+	}
+}
+
+// PackageForNamespace sets the Go package for types in the XML
+// namespace uri. pkg is an import path, whose last element is the
+// package name. If uri is one of the namespaces that code is generated
+// for, the package name is used for the package clause of the
+// generated source, in place of the one set by PackageName. Otherwise,
+// types in uri are not declared in the generated source; they are
+// referred to as pkg.Type, and pkg is imported. Namespaces without a
+// package are generated alongside the types that refer to them, as
+// before. An empty pkg removes the package for uri.
+func PackageForNamespace(uri, pkg string) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.packages[uri]
+		if pkg == "" {
+			delete(cfg.packages, uri)
+		} else {
+			if cfg.packages == nil {
+				cfg.packages = make(map[string]string)
+			}
+			cfg.packages[uri] = pkg
+		}
+		return PackageForNamespace(uri, prev)
+	}
+}
+
 // Types implementing the Logger interface can receive
 // debug information from the code generation process.
 // The Logger interface is implemented by *log.Logger.
@@ -200,6 +345,593 @@ func OnlyTypes(patterns ...string) Option {
 	}
 }
 
+func replaceFlag(p *bool, v bool) Option {
+	return func(*Config) Option {
+		prev := *p
+		*p = v
+		return replaceFlag(p, prev)
+	}
+}
+
+// The EmitBuilders option generates a builder type for every struct
+// type in the Go source, providing a fluent API for constructing
+// deeply nested values. For a type T, the builder is created with
+// NewTBuilder, and has a WithField method for every field of T. For
+// repeating elements, an AddField method appends a single item
+// instead. The Build method returns the constructed value. With
+// EmitValidators, it also checks the value with its Validate method,
+// and returns the error, if any, along with the value.
+func EmitBuilders() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitBuilders, true)(cfg)
+	}
+}
+
+// The UseDurationType option maps xs:duration values to a generated
+// struct type, instead of a string. The type keeps each component of
+// the duration, and has two methods for use with the time package:
+//
+// 	// ApproxDuration converts d to a time.Duration, assuming that a
+// 	// month is 30 days long and a year is 365 days long.
+// 	func (d xsdDuration) ApproxDuration() time.Duration
+//
+// 	// AddTo returns the time t+d, adding years, months and days
+// 	// with t.AddDate.
+// 	func (d xsdDuration) AddTo(t time.Time) time.Time
+func UseDurationType() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.durationType, true)(cfg)
+	}
+}
+
+// The EmitEnumConstants option declares a constant for every value
+// of a simpleType restricted by <enumeration> facets. Each constant
+// is named after the type and the value, so the value "in-stock" of
+// the simpleType Availability becomes the constant
+// AvailabilityInStock. A simpleType restricted by a pattern that only
+// lists literal alternatives, such as (GET|PUT|POST), is treated as
+// an enumeration of those alternatives.
+func EmitEnumConstants() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.enumConstants, true)(cfg)
+	}
+}
+
+// The EmitValidators option generates a Validate method for every
+// simpleType restricted by enumeration, length or pattern facets,
+// which returns a non-nil error if a value does not satisfy the
+// facets. Complex types get a ValidateAll method, which also checks
+// occurrence constraints and required elements and attributes, and
+// returns every violation as a *ValidationError giving the path to
+// the offending field, such as Items[1].Sku. Optional elements and
+// attributes that hold the zero value are taken to be absent, and
+// are not checked. Attributes that a restriction prohibits, which are
+// otherwise left out of the Go type, are kept as string fields so
+// that ValidateAll can report them when they are present.
+func EmitValidators() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitValidators, true)(cfg)
+	}
+}
+
+// The EmitStreamDecoder option generates a function
+//
+// 	func DecodeStream(r io.Reader, recordName string, fn func(v interface{}) error) error
+//
+// which decodes the elements named recordName in a document one at
+// a time, passing each one to fn as a pointer to its generated type.
+// Use it to process documents that are too large to unmarshal at once.
+func EmitStreamDecoder() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.streamDecoder, true)(cfg)
+	}
+}
+
+// The EmitTextMarshalers option generates MarshalText and
+// UnmarshalText methods for simpleTypes derived from strings,
+// numbers, booleans and dates, so that they satisfy
+// encoding.TextMarshaler and encoding.TextUnmarshaler. The methods
+// use the same lexical form as the XML document, so a value of such
+// a type can be used as a JSON map key, for example.
+func EmitTextMarshalers() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.textMarshalers, true)(cfg)
+	}
+}
+
+// The Additive option changes GenCLI to regenerate only a section of
+// its output file, so that hand-written code can live in the same
+// file as generated code. The section is marked by the lines
+//
+// 	// xsdgen: begin generated code. DO NOT EDIT.
+// 	// xsdgen: end generated code.
+//
+// If the output file does not exist, it is created with these
+// markers. If it exists but has no markers, GenCLI returns an error
+// rather than overwrite it. GenCLI also returns an error if the code
+// outside the markers declares a name that xsdgen generates.
+func Additive() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.additive, true)(cfg)
+	}
+}
+
+// The EmitUnmarshal option generates a function
+//
+// 	func Unmarshal(data []byte, v interface{}) error
+//
+// which behaves like xml.Unmarshal, but skips a leading byte order
+// mark and white space, and decodes documents declared as US-ASCII or
+// ISO-8859-1. If the document cannot be decoded, the error is a
+// *DecodeError, which gives the path to the element where decoding
+// failed, such as /Order/item[2]/quantity, and the text that could
+// not be decoded, if any. Unlike xml.Unmarshal, Unmarshal also fails
+// with a *DecodeError if v has the type of top-level elements of the
+// schema and the root element is not one of them. If the
+// EmitStreamDecoder option is also used, DecodeStream accepts the
+// same character sets.
+func EmitUnmarshal() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.unmarshalHelper, true)(cfg)
+	}
+}
+
+// The ExtraTag option adds a struct tag with the given key to the
+// fields generated for elements and attributes. The value of the tag
+// is computed by fn; if fn returns the empty string, the tag is left
+// out. Extra tags follow the xml tag, in the order that they were
+// added. Using ExtraTag again with the same key replaces fn.
+func ExtraTag(key string, fn func(FieldInfo) string) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.extraTags
+		var tags []extraTag
+		for _, t := range prev {
+			if t.key != key {
+				tags = append(tags, t)
+			}
+		}
+		cfg.extraTags = append(tags, extraTag{key: key, fn: fn})
+		return replaceExtraTags(prev)
+	}
+}
+
+func replaceExtraTags(tags []extraTag) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.extraTags
+		cfg.extraTags = tags
+		return replaceExtraTags(prev)
+	}
+}
+
+// The EmitJSON option generates MarshalJSON and UnmarshalJSON methods
+// for types that encoding/json cannot represent well on its own. A
+// complexType whose content is a single choice is encoded as an
+// object naming the alternative that is present, such as
+// {"type":"car","car":{...}}. A choice with no alternative present,
+// which is not valid in XML, is encoded as null, and null decodes to
+// the zero value, so that such values still round-trip through JSON.
+// A union simpleType is encoded as a JSON number or boolean, if its
+// value is valid for a numeric or boolean member type, and as a
+// string otherwise. Other types can be given JSON names with the
+// ExtraTag option.
+func EmitJSON() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitJSON, true)(cfg)
+	}
+}
+
+// The XMLDeclaration option generates a MarshalDocument function that
+// begins the document with an XML declaration, such as
+// <?xml version="1.0" encoding="UTF-8" standalone="yes"?>, which
+// xml.Marshal never writes. The encoding and standalone attributes are
+// left out if empty, and version defaults to 1.0. If all three are
+// empty, no declaration is written. The document's content is always
+// encoded in UTF-8, whatever encoding is declared.
+func XMLDeclaration(version, encoding, standalone string) Option {
+	return func(cfg *Config) Option {
+		var decl string
+		if version != "" || encoding != "" || standalone != "" {
+			if version == "" {
+				version = "1.0"
+			}
+			decl = fmt.Sprintf("<?xml version=%q", version)
+			if encoding != "" {
+				decl += fmt.Sprintf(" encoding=%q", encoding)
+			}
+			if standalone != "" {
+				decl += fmt.Sprintf(" standalone=%q", standalone)
+			}
+			decl += "?>"
+		}
+		return replaceXMLDeclaration(decl)(cfg)
+	}
+}
+
+func replaceXMLDeclaration(decl string) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.xmlDeclaration
+		cfg.xmlDeclaration = decl
+		return replaceXMLDeclaration(prev)
+	}
+}
+
+// FieldRename sets the name of the struct field generated for the
+// element or attribute named name, in the complexType typeName, to
+// goName. This can be used to avoid a collision with a method or
+// another field. The xml tag of the field is unchanged. An empty
+// goName restores the default name.
+func FieldRename(typeName xml.Name, name, goName string) Option {
+	return func(cfg *Config) Option {
+		key := fieldKey{typeName, name}
+		prev := cfg.fieldNames[key]
+		if goName == "" {
+			delete(cfg.fieldNames, key)
+		} else {
+			if cfg.fieldNames == nil {
+				cfg.fieldNames = make(map[fieldKey]string)
+			}
+			cfg.fieldNames[key] = goName
+		}
+		return FieldRename(typeName, name, prev)
+	}
+}
+
+// FieldDrop leaves the element or attribute named name, in the
+// complexType typeName, out of the generated struct type. Dropping
+// a required element or attribute is allowed, but logged, since
+// documents marshalled from the type will not be valid.
+func FieldDrop(typeName xml.Name, name string) Option {
+	return func(cfg *Config) Option {
+		return replaceFieldDrop(fieldKey{typeName, name}, true)(cfg)
+	}
+}
+
+func replaceFieldDrop(key fieldKey, drop bool) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.droppedFields[key]
+		if drop {
+			if cfg.droppedFields == nil {
+				cfg.droppedFields = make(map[fieldKey]bool)
+			}
+			cfg.droppedFields[key] = true
+		} else {
+			delete(cfg.droppedFields, key)
+		}
+		return replaceFieldDrop(key, prev)
+	}
+}
+
+// The EmitFieldAccessorsByXMLName option generates a FieldByXMLName
+// method for every struct type, which returns the value of a field
+// given the name of its element or attribute. This is useful for
+// converting between the types generated from two versions of a
+// schema, whose Go names may differ.
+func EmitFieldAccessorsByXMLName() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitFieldAccessors, true)(cfg)
+	}
+}
+
+// The EmitFieldMarshalers option generates a MarshalField method for
+// every struct type, which returns the XML encoding of one of its
+// fields, given its Go name: the element it holds, with its namespace,
+// or the attribute, as in name="value". This is useful for logging
+// part of a large value.
+func EmitFieldMarshalers() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitFieldMarshalers, true)(cfg)
+	}
+}
+
+// The EmitSize option generates an EstimatedXMLSize method for every
+// struct type, which returns the approximate length of the value's
+// XML encoding, for callers that must keep documents under a size
+// limit. Characters that xml.Marshal escapes are counted once, and
+// the values of types that xsdgen cannot measure, such as SOAP
+// arrays, are measured by their fmt.Sprint formatting.
+func EmitSize() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitSize, true)(cfg)
+	}
+}
+
+// The CoreType option splits the struct type generated for the
+// complexType typeName into two struct types, both embedded in it:
+// one named with a Core suffix, which holds the fields of required
+// elements and attributes, and one with an Extensions suffix, which
+// holds the rest. The type encodes and decodes as before. Decoding
+// a document into the Core type skips its optional content, which
+// can be faster when only the required fields are used.
+func CoreType(typeName xml.Name) Option {
+	return replaceCoreType(typeName, true)
+}
+
+func replaceCoreType(typeName xml.Name, split bool) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.coreTypes[typeName]
+		if split {
+			if cfg.coreTypes == nil {
+				cfg.coreTypes = make(map[xml.Name]bool)
+			}
+			cfg.coreTypes[typeName] = true
+		} else {
+			delete(cfg.coreTypes, typeName)
+		}
+		return replaceCoreType(typeName, prev)
+	}
+}
+
+// The OmitEmptyWrappers option generates MarshalXML methods for
+// struct types with optional elements of complex types, that leave
+// out such an element if all of its content is empty, rather than
+// write an empty element. Elements with minOccurs greater than zero
+// are always written. For a type split by CoreType, the same method
+// also writes the fields in schema order. Code generation fails for
+// a type that already has a different MarshalXML method.
+func OmitEmptyWrappers() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.omitEmptyWrappers, true)(cfg)
+	}
+}
+
+// The TypedWildcards option changes the type of the field generated
+// for an <any> element with a namespace of ##targetNamespace, from a
+// slice of strings to a slice of an interface that is implemented by
+// the types of the top-level elements of the target namespace. Each
+// element is decoded into the type declared for it in the schema.
+// If one of the top-level elements does not have a struct type of
+// its own, the field is generated as before.
+func TypedWildcards() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.typedWildcards, true)(cfg)
+	}
+}
+
+// The EmitNamespaceConstants option declares a string constant for
+// each of the target namespaces that code is generated for. The name
+// of the constant is Namespace followed by the last segment of the
+// namespace URI that is not a version, such as NamespaceLibrary for
+// http://dyomedea.com/ns/library. If two namespaces would have the
+// same constant, the later one in the Namespaces option is given a
+// numeric suffix.
+func EmitNamespaceConstants() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.namespaceConstants, true)(cfg)
+	}
+}
+
+// The EmitElementNames option declares an xml.Name variable for
+// each top-level element of the target namespaces, named Elem
+// followed by the Go name of the element, such as
+//
+// 	var ElemOrder = xml.Name{Space: NamespaceOrders, Local: "order"}
+//
+// for matching elements, or building xmltree nodes, without restating
+// their names. As the Space fields refer to the namespace constants,
+// EmitElementNames also applies the EmitNamespaceConstants option.
+func EmitElementNames() Option {
+	return func(cfg *Config) Option {
+		undoConstants := replaceFlag(&cfg.namespaceConstants, true)(cfg)
+		undoNames := replaceFlag(&cfg.elementNames, true)(cfg)
+		return func(cfg *Config) Option {
+			undoNames(cfg)
+			undoConstants(cfg)
+			return EmitElementNames()
+		}
+	}
+}
+
+// The SchemaAttributeOrder option generates MarshalXML methods for
+// struct types whose attribute fields are not in the order in which
+// the attributes are declared in the schema, such as types derived by
+// restriction, so that the attributes are written in declared order.
+// The attributes of a base type are declared before those of the
+// types derived from it. The same method also applies CoreType and
+// OmitEmptyWrappers; code generation fails for a type that already
+// has a different MarshalXML method.
+func SchemaAttributeOrder() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.attributeOrder, true)(cfg)
+	}
+}
+
+// The ValidateOnDecode option generates UnmarshalXML methods for
+// struct types that check the decoded value with its Validate method,
+// so that decoding fails at the first element that does not satisfy
+// the schema. The error gives the line and column of the element and
+// wraps the *ValidationError locating the offending field. It implies
+// EmitValidators. The UnmarshalXML methods of types that already have
+// one, such as those with attribute defaults, are kept as the
+// unexported method unmarshalUnvalidated, which decodes the element
+// before it is checked.
+func ValidateOnDecode() Option {
+	return func(cfg *Config) Option {
+		undoValidators := replaceFlag(&cfg.emitValidators, true)(cfg)
+		undo := replaceFlag(&cfg.validateOnDecode, true)(cfg)
+		return func(cfg *Config) Option {
+			undo(cfg)
+			undoValidators(cfg)
+			return ValidateOnDecode()
+		}
+	}
+}
+
+// The LenientNamespaces option generates UnmarshalXML methods for
+// struct types that decode a child element whose name matches no
+// field, because it is in another namespace or in none, into the
+// field with the same local name. Each such element is passed to the
+// NamespaceMismatch variable of the generated package, which logs it
+// by default. Local names shared by fields in different namespaces
+// are still matched strictly. The UnmarshalXML methods of types that
+// already have one, such as those with attribute defaults, are kept
+// as the unexported method unmarshalStrict, which decodes the element
+// once its namespaces are corrected. With ValidateOnDecode, the
+// elements within such a type are checked as well, but their errors
+// do not give a line and column.
+func LenientNamespaces() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.lenientNamespaces, true)(cfg)
+	}
+}
+
+// The EmitSchemaDigest option declares a constant,
+//
+// 	const SchemaDigest = "sha256:..."
+//
+// holding the digest of the schema documents, as returned by the
+// SchemaDigest function. Checking it against the digest of the
+// current schema, such as in a test, shows when generated code that
+// is checked in has fallen behind changes to the schema. The digest
+// is computed from the documents read by GenAST, so no constant is
+// declared by GenFromSchema.
+func EmitSchemaDigest() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitSchemaDigest, true)(cfg)
+	}
+}
+
+// The EmitVisitor option generates a method
+//
+// 	func (t *T) Walk(visit func(path string, v interface{}))
+//
+// for every struct type, that calls visit with a pointer to t and to
+// each value within it: its fields, the items of its slices, and the
+// fields of the struct values they hold, with the dotted path of Go
+// field names leading to each, such as Orders[0].Address.City. As
+// visit receives pointers, it may change the values, such as to
+// redact them.
+func EmitVisitor() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emitVisitor, true)(cfg)
+	}
+}
+
+// The NonNilSlices option generates a function NewT for every struct
+// type T with a slice field, or a field of such a struct type, that
+// returns a *T whose slices are empty rather than nil, all the way
+// down. encoding/json writes such slices as [] instead of null. A
+// value decoded into after it is constructed keeps an empty slice for
+// each element that is absent; to encoding/xml there is no difference,
+// and a field with an omitempty tag is left out either way. The
+// builders generated by EmitBuilders start from the constructed value.
+func NonNilSlices() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.nonNilSlices, true)(cfg)
+	}
+}
+
+// The KeepRepeatingGroups option keeps the elements of a sequence or
+// choice that may appear more than once together. Without it, each
+// element of such a group gets a slice of its own, and which items
+// of the slices were in the same repetition of the group is lost.
+// With it, the elements of the group are moved to a struct type named
+// after the type that holds them, such as SeriesGroup, and the type
+// has a slice of it, named Groups, in their place. An UnmarshalXML
+// method starts a new item each time the group repeats. The method
+// cannot decode types that extend another complex type, are extended
+// by one, have wildcard elements, have attributes with default values
+// or are split by CoreType. If such a type has a repeating group with
+// more than one element, generating code fails with an error.
+func KeepRepeatingGroups() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.repeatingGroups, true)(cfg)
+	}
+}
+
+// The EmitRoundTripTest option generates a function,
+//
+// 	func RoundTripOK(data []byte, root xml.Name) (ok bool, diff []byte, err error)
+//
+// that decodes a document whose root element is root into the type
+// generated for it, encodes the value again, and reports whether the
+// two documents are the same. If they are not, diff holds a unified
+// diff of their canonical forms, as reported by xmltree.DiffString.
+// Running it on sample documents shows whether the generated
+// types lose any of their content. Only top-level elements of complex
+// types can be decoded.
+func EmitRoundTripTest() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.roundTripTest, true)(cfg)
+	}
+}
+
+// The EmptyElementsAsBool option changes the type of the fields of
+// optional elements whose type has no content, such as <deleted/>,
+// from *struct{} to a bool type that is true if the element is
+// present. The element is written, with no content, if the field
+// is true.
+func EmptyElementsAsBool() Option {
+	return func(cfg *Config) Option {
+		return replaceFlag(&cfg.emptyElementsAsBool, true)(cfg)
+	}
+}
+
+// An OverflowPolicy tells the generated types what to do with an
+// integer that is too large or too small for its type.
+type OverflowPolicy int
+
+const (
+	// OverflowError fails to decode a document with an integer
+	// outside of the range of its schema type, such as 70000 for an
+	// xs:short.
+	OverflowError OverflowPolicy = iota + 1
+	// OverflowClamp replaces an integer outside of the range of its
+	// schema type with the nearest value inside it. Where the range
+	// is unbounded, as for xs:integer, the 64-bit range is used.
+	OverflowClamp
+	// OverflowSaturate replaces an integer that does not fit in the
+	// size of its schema type, such as the 16 bits of xs:short, with
+	// the nearest value that does. Types without a fixed size, such
+	// as xs:integer and xs:nonNegativeInteger, are limited to 64 bits,
+	// so that a negative xs:nonNegativeInteger is kept.
+	OverflowSaturate
+)
+
+// The IntegerOverflow option sets the policy for decoding integers
+// that are out of range. The fields of the integer built-in types are
+// given types of their own, such as xsdShort, with an UnmarshalText
+// method that applies the policy. Simple types derived from the
+// integer types are decoded as before, unless EmitTextMarshalers is
+// also set. Without this option, integers are decoded by encoding/xml,
+// which fails only on values that do not fit in the Go type of their
+// field, such as int for xs:short.
+func IntegerOverflow(p OverflowPolicy) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.overflowPolicy
+		cfg.overflowPolicy = p
+		return IntegerOverflow(prev)
+	}
+}
+
+// SkipNamespace prevents the types in the XML namespace uri from
+// being declared in the generated source, even if they are referred
+// to, or uri would otherwise be one of the namespaces that code is
+// generated for. Elements of complex types in uri are given a field
+// of a type that keeps their raw content, as for elements of anyType,
+// and elements and attributes of simple types are given the Go type
+// of the built-in type they are derived from. Complex types derived
+// from a type in uri are derived from that built-in type, or from
+// anyType, whose content they do not keep. To refer to Go types
+// written for the namespace instead, use PackageForNamespace.
+func SkipNamespace(uri string) Option {
+	return replaceSkipNamespace(uri, true)
+}
+
+func replaceSkipNamespace(uri string, skip bool) Option {
+	return func(cfg *Config) Option {
+		prev := cfg.skippedNamespaces[uri]
+		if skip {
+			if cfg.skippedNamespaces == nil {
+				cfg.skippedNamespaces = make(map[string]bool)
+			}
+			cfg.skippedNamespaces[uri] = true
+		} else {
+			delete(cfg.skippedNamespaces, uri)
+		}
+		return replaceSkipNamespace(uri, prev)
+	}
+}
+
 func replacePreprocessType(p *typeTransform, fn typeTransform) Option {
 	return func(*Config) Option {
 		prev := *p
@@ -279,7 +1011,12 @@ func replaceAllNamesRegex(reg *regexp.Regexp, repl string) Option {
 }
 
 // The UseFieldNames Option names anonymous types based on the name
-// of the element or attribute they describe.
+// of the element or attribute they describe. A complexType that
+// restricts another redeclares the elements of its base, usually
+// to narrow their types, so the anonymous types of its elements are
+// also named after the complexType, as in SpecialContainerContent for
+// the content element of SpecialContainer, to keep them apart from
+// the types of the base's elements.
 func UseFieldNames() Option {
 	return ProcessTypes(useFieldNames)
 }
@@ -289,41 +1026,77 @@ func useFieldNames(s xsd.Schema, t xsd.Type) xsd.Type {
 	if !ok {
 		return t
 	}
+	_, restricts := c.Base.(*xsd.ComplexType)
+	restricts = restricts && !c.Extends && !c.Anonymous
 	for _, el := range c.Elements {
+		name := el.Name
+		if restricts {
+			name.Local = c.Name.Local + gen.PublicName(el.Name.Local)
+		}
+		if anonymous(el.Type) && nameTaken(s, name, el.Type) {
+			name.Local += "Element"
+		}
 		switch base := el.Type.(type) {
 		case *xsd.SimpleType:
 			if !base.Anonymous {
 				break
 			}
-			base.Name = el.Name
+			base.Name = name
 			base.Anonymous = false
 		case *xsd.ComplexType:
 			if !base.Anonymous {
 				break
 			}
-			base.Name = el.Name
+			base.Name = name
 			base.Anonymous = false
 		}
 	}
 	for _, attr := range c.Attributes {
+		name := attr.Name
+		if anonymous(attr.Type) && nameTaken(s, name, attr.Type) {
+			name.Local += "Attribute"
+		}
 		switch base := attr.Type.(type) {
 		case *xsd.SimpleType:
 			if !base.Anonymous {
 				break
 			}
-			base.Name = attr.Name
+			base.Name = name
 			base.Anonymous = false
 		case *xsd.ComplexType:
 			if !base.Anonymous {
 				break
 			}
-			base.Name = attr.Name
+			base.Name = name
 			base.Anonymous = false
 		}
 	}
 	return t
 }
 
+// Elements and types are in different symbol spaces, so an element
+// may have the same name as a type, but their Go types may not.
+// nameTaken reports whether a type other than t, in the namespace of
+// name, has a name that would become the same Go identifier as name.
+func nameTaken(s xsd.Schema, name xml.Name, t xsd.Type) bool {
+	for other, ot := range s.Types {
+		if ot != t && other.Space == name.Space && gen.PublicName(other.Local) == gen.PublicName(name.Local) {
+			return true
+		}
+	}
+	return false
+}
+
+func anonymous(t xsd.Type) bool {
+	switch t := t.(type) {
+	case *xsd.SimpleType:
+		return t.Anonymous
+	case *xsd.ComplexType:
+		return t.Anonymous
+	}
+	return false
+}
+
 // ProcessTypes allows for users to make arbitrary changes to a type before
 // Go source code is generated.
 func ProcessTypes(fn func(xsd.Schema, xsd.Type) xsd.Type) Option {
@@ -395,15 +1168,34 @@ func (cfg *Config) filterFields(t *xsd.ComplexType) ([]xsd.Attribute, []xsd.Elem
 		attributes []xsd.Attribute
 	)
 	for _, attr := range t.Attributes {
+		if attr.Prohibited && !cfg.emitValidators {
+			cfg.debugf("complexType %s: omitting prohibited attribute %s",
+				t.Name.Local, attr.Name.Local)
+			continue
+		}
 		if cfg.filterAttributes != nil && cfg.filterAttributes(&attr) {
 			continue
 		}
+		if cfg.droppedFields[fieldKey{t.Name, attr.Name.Local}] {
+			if attr.Required {
+				cfg.logf("warning: complexType %s: dropping required attribute %s",
+					t.Name.Local, attr.Name.Local)
+			}
+			continue
+		}
 		attributes = append(attributes, attr)
 	}
 	for _, el := range t.Elements {
 		if cfg.filterElements != nil && cfg.filterElements(&el) {
 			continue
 		}
+		if cfg.droppedFields[fieldKey{t.Name, el.Name.Local}] {
+			if el.MinOccurs > 0 && el.Choice == 0 {
+				cfg.logf("warning: complexType %s: dropping required element %s",
+					t.Name.Local, el.Name.Local)
+			}
+			continue
+		}
 		elements = append(elements, el)
 	}
 	return attributes, elements
@@ -413,16 +1205,46 @@ func (cfg *Config) filterFields(t *xsd.ComplexType) ([]xsd.Attribute, []xsd.Elem
 // mapped to the built-in type.
 func (cfg *Config) expr(t xsd.Type) (ast.Expr, error) {
 	if t, ok := t.(xsd.Builtin); ok {
+		if t == xsd.Duration && cfg.durationType {
+			return ast.NewIdent("xsdDuration"), nil
+		}
+		if name := cfg.overflowType(t); name != "" {
+			return ast.NewIdent(name), nil
+		}
+		if name := cfg.notationType(t); name != "" {
+			return ast.NewIdent(name), nil
+		}
 		ex := builtinExpr(t)
 		if ex == nil {
 			return nil, fmt.Errorf("Unknown built-in type %q", t.Name().Local)
 		}
 		return ex, nil
 	}
-	return ast.NewIdent(cfg.typeName(xsd.XMLName(t))), nil
+	if t, ok := t.(*xsd.SimpleType); ok {
+		if typ := goType(t); typ != "" {
+			return ast.NewIdent(typ), nil
+		}
+	}
+	name := xsd.XMLName(t)
+	if _, pkg := cfg.foreignPackage(name.Space); pkg != "" {
+		return ast.NewIdent(pkg + "." + cfg.typeName(name)), nil
+	}
+	return ast.NewIdent(cfg.typeName(name)), nil
+}
+
+// fieldName returns the name of the struct field for an element or
+// attribute of a complexType.
+func (cfg *Config) fieldName(t *xsd.ComplexType, name xml.Name) string {
+	if goName := cfg.fieldNames[fieldKey{t.Name, name.Local}]; goName != "" {
+		return goName
+	}
+	return cfg.public(name)
 }
 
 func (cfg *Config) typeName(name xml.Name) string {
+	if goName, ok := cfg.typeNames[name]; ok {
+		return goName
+	}
 	return cfg.public(name)
 }
 
@@ -430,7 +1252,7 @@ func (cfg *Config) public(name xml.Name) string {
 	if cfg.nameTransform != nil {
 		name = cfg.nameTransform(name)
 	}
-	return strings.Title(name.Local)
+	return gen.PublicName(name.Local)
 }
 
 //
@@ -583,6 +1405,11 @@ func (cfg *Config) soapArrayToSlice(s spec) spec {
 	if len(str.Fields.List) != 1 {
 		return s
 	}
+	// The methods of the struct type, such as those decoding a
+	// repeating group, would be lost.
+	if hasMethod(s, "UnmarshalXML") || hasMethod(s, "MarshalXML") {
+		return s
+	}
 	slice, ok := str.Fields.List[0].Type.(*ast.ArrayType)
 	if !ok {
 		return s
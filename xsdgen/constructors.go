@@ -0,0 +1,102 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// A nil slice and an empty one encode to the same XML, but not to the
+// same JSON: encoding/json writes null for the first and [] for the
+// second. The NewT functions generated here return values whose
+// slices, including those of the struct values they hold, are empty
+// but not nil.
+
+func (cfg *Config) addConstructors(decls map[string]spec) error {
+	// A struct type needs a constructor if it has a slice field, or
+	// a field of a struct type that does.
+	needed := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for name, s := range decls {
+			str, ok := s.expr.(*ast.StructType)
+			if !ok || needed[name] {
+				continue
+			}
+			for _, field := range str.Fields.List {
+				typ := gen.ExprString(field.Type)
+				if sliceType(field.Type, decls) || needed[typ] {
+					needed[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	for name := range needed {
+		s := decls[name]
+		ctor := "New" + s.name
+		if _, ok := decls[ctor]; ok || hasMethod(s, ctor) {
+			cfg.logf("%s is already declared; no constructor is generated for %s", ctor, s.name)
+			delete(needed, name)
+		}
+	}
+	for name := range needed {
+		s := decls[name]
+		fn, err := cfg.genConstructor(s, s.expr.(*ast.StructType), decls, needed)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+// sliceType reports whether expr is a slice type, or the name of a
+// declared one.
+func sliceType(expr ast.Expr, decls map[string]spec) bool {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if s, ok := decls[ident.Name]; ok {
+			expr = s.expr
+		}
+	}
+	arr, ok := expr.(*ast.ArrayType)
+	return ok && arr.Len == nil
+}
+
+func (cfg *Config) genConstructor(s spec, str *ast.StructType, decls map[string]spec, needed map[string]bool) (*ast.FuncDecl, error) {
+	var values bytes.Buffer
+	for _, field := range str.Fields.List {
+		typ := gen.ExprString(field.Type)
+		var value string
+		switch {
+		case sliceType(field.Type, decls):
+			value = fmt.Sprintf("make(%s, 0)", typ)
+		case needed[typ]:
+			value = fmt.Sprintf("*New%s()", typ)
+		default:
+			continue
+		}
+		name := typ
+		if len(field.Names) > 0 {
+			name = field.Names[0].Name
+		}
+		fmt.Fprintf(&values, "%s: %s,\n", name, value)
+	}
+	fn, err := gen.Func("New"+s.name).
+		Comment(fmt.Sprintf("// New%s returns a new %s whose slices are empty, rather than nil.", s.name, s.name)).
+		Returns("*"+s.name).
+		Body(`
+			return &%s{
+				%s
+			}
+		`, s.name, values.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("New%s: %v", s.name, err)
+	}
+	return fn, nil
+}
@@ -0,0 +1,78 @@
+package xsdgen
+
+import (
+	"go/ast"
+
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// A decoder that only needs the required content of a large message
+// can decode it into a struct that holds nothing else; encoding/xml
+// skips the elements that have no field. splitCoreType moves the
+// required fields of a struct type into a Core struct and the rest
+// into an Extensions struct, and embeds both in the original type,
+// so that it decodes as before. Embedding changes the order of the
+// fields, so if the optional fields are not all after the required
+// ones, the original struct is kept in splitStructs, and the
+// MarshalXML method generated by addStructMarshalers writes the
+// elements in schema order.
+func (cfg *Config) splitCoreType(s spec, t *xsd.ComplexType, attributes []xsd.Attribute, elements []xsd.Element) ([]spec, error) {
+	str, ok := s.expr.(*ast.StructType)
+	if !ok {
+		return []spec{s}, nil
+	}
+	requiredAttr := make(map[string]bool)
+	for _, attr := range attributes {
+		requiredAttr[attr.Name.Local] = attr.Required
+	}
+	requiredEl := make(map[string]bool)
+	for _, el := range elements {
+		requiredEl[el.Name.Local] = el.MinOccurs > 0 && el.Choice == 0 && !el.Wildcard
+	}
+	core := &ast.StructType{Fields: &ast.FieldList{}}
+	ext := &ast.StructType{Fields: &ast.FieldList{}}
+	inOrder, extAttrs := true, 0
+	for _, field := range str.Fields.List {
+		required := len(field.Names) == 0
+		attr := false
+		if _, local, flags, ok := xmlTag(field); ok {
+			switch {
+			case hasFlag(flags, "attr"):
+				required, attr = requiredAttr[local], true
+			case hasFlag(flags, "chardata"):
+				required = true
+			case local != "":
+				required = requiredEl[local]
+			}
+		}
+		if required {
+			// The order of attributes does not matter.
+			if !attr && len(ext.Fields.List) > extAttrs {
+				inOrder = false
+			}
+			core.Fields.List = append(core.Fields.List, field)
+		} else {
+			if attr {
+				extAttrs++
+			}
+			ext.Fields.List = append(ext.Fields.List, field)
+		}
+	}
+	coreName, extName := s.name+"Core", s.name+"Extensions"
+	cfg.debugf("complexType %s: moving %d required fields to %s and %d others to %s",
+		t.Name.Local, len(core.Fields.List), coreName, len(ext.Fields.List), extName)
+
+	composite := s
+	composite.expr = &ast.StructType{Fields: &ast.FieldList{List: []*ast.Field{
+		{Type: ast.NewIdent(coreName)},
+		{Type: ast.NewIdent(extName)},
+	}}}
+	if !inOrder {
+		cfg.splitStructs[s.name] = str
+	}
+	return []spec{
+		composite,
+		{name: coreName, expr: core},
+		{name: extName, expr: ext},
+	}, nil
+}
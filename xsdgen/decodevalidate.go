@@ -0,0 +1,65 @@
+package xsdgen
+
+import (
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// The Validate methods generated by EmitValidators are only called if
+// the user remembers to, after the whole document has been decoded.
+// With the ValidateOnDecode option, the UnmarshalXML methods generated
+// here validate each element of a struct type as soon as its end tag
+// is read, so decoding stops at the first element that violates the
+// schema, and the error says where in the document it starts.
+
+func (cfg *Config) addDecodeValidators(decls map[string]spec) error {
+	for name, s := range decls {
+		if !hasValidateAll(s) {
+			continue
+		}
+		fn, err := cfg.genValidatingUnmarshal(&s)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+// genValidatingUnmarshal generates an UnmarshalXML method that
+// validates t after decoding it. A type that already has an
+// UnmarshalXML method, such as one setting attribute defaults, is
+// decoded with it first.
+func (cfg *Config) genValidatingUnmarshal(s *spec) (*ast.FuncDecl, error) {
+	fn, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML decodes the element start into t, and returns an\n"+
+			"// error if t does not satisfy the constraints of the schema.").
+		Receiver("t *"+s.name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			line, column := d.InputPos()
+			// A decoder reading tokens from another one, such as
+			// that of a type decoded with LenientNamespaces, does
+			// not know where they are.
+			known := d.InputOffset() > 0
+			%s
+			if err != nil {
+				return err
+			}
+			if err := t.Validate(); err != nil && !known {
+				return fmt.Errorf("element %%s: %%w", start.Name.Local, err)
+			} else if err != nil {
+				return fmt.Errorf("element %%s at line %%d, column %%d: %%w", start.Name.Local, line, column, err)
+			}
+			return nil
+		`, wrapUnmarshal(s, "unmarshalUnvalidated")).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", s.name, err)
+	}
+	return fn, nil
+}
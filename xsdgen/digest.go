@@ -0,0 +1,106 @@
+package xsdgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lajonat/go-xml/xmltree"
+)
+
+const schemaNS = "http://www.w3.org/2001/XMLSchema"
+
+// Attributes of schema elements whose values are QNames, and change
+// with the prefixes declared for namespaces.
+var qnameAttrs = map[string]bool{
+	"base":              true,
+	"itemType":          true,
+	"memberTypes":       true,
+	"ref":               true,
+	"refer":             true,
+	"substitutionGroup": true,
+	"type":              true,
+}
+
+// SchemaDigest returns the digest of a set of schema documents that
+// the EmitSchemaDigest option declares in generated code, of the form
+// "sha256:" followed by a hex-encoded SHA-256 sum. Comparing the two
+// shows whether the code was generated from the same schema.
+//
+// The digest is computed over the canonical form of each document
+// returned by xmltree.Canonical, with the QNames in schema attributes
+// expanded, so it does not change with the prefixes bound to
+// namespaces, the order of attributes, comments, or white space
+// around text, and it does not depend on the order of the documents.
+// The documents should be the same ones GenAST reads, including those
+// they include or import.
+func SchemaDigest(docs ...[]byte) (string, error) {
+	sums := make([]string, 0, len(docs))
+	for i, data := range docs {
+		root, err := xmltree.Parse(data)
+		if err != nil {
+			return "", fmt.Errorf("schema %d: %v", i+1, err)
+		}
+		expandQNames(root)
+		canonical, err := xmltree.Canonical(root)
+		if err != nil {
+			return "", fmt.Errorf("schema %d: %v", i+1, err)
+		}
+		sum := sha256.Sum256(canonical)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	sort.Strings(sums)
+	sum := sha256.Sum256([]byte(strings.Join(sums, "\n")))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// expandQNames rewrites the attributes of the schema elements in el
+// whose values are QNames, so that they hold the expanded names of the
+// form used by xmltree.Canonical.
+func expandQNames(el *xmltree.Element) {
+	if el.Name.Space == schemaNS {
+		for i, attr := range el.StartElement.Attr {
+			if attr.Name.Space != "" || !qnameAttrs[attr.Name.Local] {
+				continue
+			}
+			var names []string
+			for _, qname := range strings.Fields(attr.Value) {
+				name := el.Resolve(qname)
+				if name.Space != "" {
+					names = append(names, "{"+name.Space+"}"+name.Local)
+				} else {
+					names = append(names, name.Local)
+				}
+			}
+			el.StartElement.Attr[i].Value = strings.Join(names, " ")
+		}
+	}
+	for i := range el.Children {
+		expandQNames(&el.Children[i])
+	}
+}
+
+func (cfg *Config) genSchemaDigest(taken map[string]bool) ast.Decl {
+	name := unusedName(taken, "SchemaDigest")
+	if name != "SchemaDigest" {
+		cfg.logf("SchemaDigest is already declared; declaring the schema digest as %s", name)
+	}
+	return &ast.GenDecl{
+		Tok: token.CONST,
+		Doc: &ast.CommentGroup{List: []*ast.Comment{
+			{Text: fmt.Sprintf("// %s is the digest of the schema this file was generated", name)},
+			{Text: "// from, as returned by xsdgen.SchemaDigest."},
+		}},
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent(name)},
+				Values: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(cfg.schemaDigest)}},
+			},
+		},
+	}
+}
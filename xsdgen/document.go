@@ -0,0 +1,636 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// The encoding/xml package always writes namespaces as xmlns="..."
+// attributes on the elements that use them, and makes up its own
+// prefixes for namespaced attributes. Struct tags cannot change this,
+// because the decoder resolves prefixes before matching tags. Instead,
+// pinned prefixes are applied by a generated MarshalDocument function,
+// which re-encodes the output of xml.Marshal, declaring every prefix
+// it uses on the root element.
+
+// checkPrefixes reports a pinned prefix that is reserved or pinned to
+// more than one namespace. NamespacePrefix calls it each time a prefix
+// is pinned or removed.
+func (cfg *Config) checkPrefixes() error {
+	uris := make([]string, 0, len(cfg.prefixes))
+	for uri := range cfg.prefixes {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	pinned := make(map[string]string, len(uris))
+	for _, uri := range uris {
+		prefix := cfg.prefixes[uri]
+		switch prefix {
+		case "xml", "xmlns":
+			return fmt.Errorf("namespace prefix %q is reserved and cannot be pinned to %s", prefix, uri)
+		}
+		if other, ok := pinned[prefix]; ok {
+			return fmt.Errorf("namespace prefix %q pinned to both %s and %s", prefix, other, uri)
+		}
+		pinned[prefix] = uri
+	}
+	return nil
+}
+
+// declaredNames returns the names of the package-level declarations
+// in a file.
+func declaredNames(file *ast.File) map[string]bool {
+	taken := make(map[string]bool)
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				taken[decl.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					taken[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						taken[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return taken
+}
+
+// unusedName returns base, or base with the smallest numeric suffix
+// from 2 that is not taken, and marks it as taken.
+func unusedName(taken map[string]bool, base string) string {
+	name := base
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	taken[name] = true
+	return name
+}
+
+// genNamespaceConstants declares the constants for the target
+// namespaces, with names that are not used by another declaration
+// in the file. It returns the name of the constant for each namespace.
+func (cfg *Config) genNamespaceConstants(taken map[string]bool) (ast.Decl, map[string]string) {
+	var args []string
+	names := make(map[string]string)
+	for _, ns := range cfg.namespaces {
+		if _, ok := names[ns]; ok {
+			continue
+		}
+		name := unusedName(taken, "Namespace"+namespaceIdent(ns))
+		names[ns] = name
+		args = append(args, name, "", ns)
+	}
+	return gen.ConstString(args...), names
+}
+
+// genElementNames declares an xml.Name variable for each top-level
+// element of the target namespaces, in the order of the Namespaces
+// option and then by local name.
+func (cfg *Config) genElementNames(taken map[string]bool, namespaces map[string]string) ast.Decl {
+	rank := make(map[string]int)
+	for i, ns := range cfg.namespaces {
+		if _, ok := rank[ns]; !ok {
+			rank[ns] = i
+		}
+	}
+	names := append([]xml.Name(nil), cfg.globalElements...)
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return rank[names[i].Space] < rank[names[j].Space]
+		}
+		return names[i].Local < names[j].Local
+	})
+	decl := &ast.GenDecl{Tok: token.VAR, Lparen: 1}
+	for _, name := range names {
+		space := ast.Expr(gen.String(name.Space))
+		if c, ok := namespaces[name.Space]; ok {
+			space = ast.NewIdent(c)
+		}
+		decl.Specs = append(decl.Specs, &ast.ValueSpec{
+			Names: []*ast.Ident{ast.NewIdent(unusedName(taken, "Elem"+gen.PublicName(name.Local)))},
+			Values: []ast.Expr{&ast.CompositeLit{
+				Type: ast.NewIdent("xml.Name"),
+				Elts: []ast.Expr{
+					&ast.KeyValueExpr{Key: ast.NewIdent("Space"), Value: space},
+					&ast.KeyValueExpr{Key: ast.NewIdent("Local"), Value: gen.String(name.Local)},
+				},
+			}},
+		})
+	}
+	return decl
+}
+
+// genDocumentHelpers generates top-level functions that are not tied
+// to any one type, and should be declared only once per file.
+func (cfg *Config) genDocumentHelpers(file *ast.File) ([]ast.Decl, error) {
+	var result []ast.Decl
+	taken := declaredNames(file)
+	var namespaces map[string]string
+	if cfg.namespaceConstants {
+		var decl ast.Decl
+		decl, namespaces = cfg.genNamespaceConstants(taken)
+		result = append(result, decl)
+	}
+	if cfg.elementNames && len(cfg.globalElements) > 0 {
+		result = append(result, cfg.genElementNames(taken, namespaces))
+	}
+	if cfg.emitSchemaDigest {
+		if cfg.schemaDigest != "" {
+			result = append(result, cfg.genSchemaDigest(taken))
+		} else {
+			cfg.logf("the schema documents are not known; SchemaDigest is not declared")
+		}
+	}
+	if cfg.emitValidators {
+		decls, err := cfg.genValidationError()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.lenientNamespaces {
+		decls, err := cfg.genLenientHelpers()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.unmarshalHelper {
+		decls, err := cfg.genUnmarshalHelper()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.streamDecoder {
+		decls, err := cfg.genStreamDecoder(file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.emitFieldMarshalers {
+		decls, err := cfg.genFragmentHelper()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.emitSize {
+		decls, err := cfg.genSizeHelper()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.emitVisitor {
+		decls, err := cfg.genWalkHelper()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if cfg.roundTripTest {
+		decls, err := cfg.genRoundTripHelper()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decls...)
+	}
+	if len(cfg.prefixes) == 0 && cfg.xmlDeclaration == "" {
+		return result, nil
+	}
+	decls, err := cfg.genMarshalDocument()
+	if err != nil {
+		return nil, err
+	}
+	return append(result, decls...), nil
+}
+
+// genMarshalDocument generates the MarshalDocument function, which
+// applies pinned namespace prefixes and writes the configured XML
+// declaration.
+func (cfg *Config) genMarshalDocument() ([]ast.Decl, error) {
+	var result []ast.Decl
+	var body bytes.Buffer
+	comment := "// MarshalDocument returns the XML encoding of v, like xml.Marshal"
+	body.WriteString(`
+		data, err := xml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	`)
+	if len(cfg.prefixes) > 0 {
+		uris := make([]string, 0, len(cfg.prefixes))
+		for uri := range cfg.prefixes {
+			uris = append(uris, uri)
+		}
+		sort.Strings(uris)
+		var lit bytes.Buffer
+		for _, uri := range uris {
+			fmt.Fprintf(&lit, "%q: %q,\n", uri, cfg.prefixes[uri])
+		}
+		comment += ", but\n" +
+			"// writes elements and attributes in the pinned namespaces with their\n" +
+			"// pinned prefixes, and declares all prefixes on the root element."
+		fmt.Fprintf(&body, `
+			data, err = _prefixNamespaces(data, map[string]string{
+				%s
+			})
+			if err != nil {
+				return nil, err
+			}
+		`, lit.String())
+	} else {
+		comment += "."
+	}
+	if cfg.xmlDeclaration != "" {
+		comment += "\n// The document begins with the declaration " + cfg.xmlDeclaration + "."
+		// A MarshalXML method may have written a declaration of
+		// its own; there must be only one, at the very start.
+		fmt.Fprintf(&body, `
+			if bytes.HasPrefix(data, []byte("<?xml")) {
+				if i := bytes.Index(data, []byte("?>")); i >= 0 {
+					data = bytes.TrimLeft(data[i+2:], " \t\r\n")
+				}
+			}
+			return append([]byte(%q), data...), nil
+		`, cfg.xmlDeclaration+"\n")
+	} else {
+		body.WriteString("return data, nil\n")
+	}
+	fns := []*gen.Function{
+		gen.Func("MarshalDocument").
+			Comment(comment).
+			Args("v interface{}").
+			Returns("[]byte", "error").
+			Body("%s", body.String()),
+	}
+	if len(cfg.prefixes) > 0 {
+		fns = append(fns, prefixNamespaces())
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decl)
+	}
+	return result, nil
+}
+
+// prefixNamespaces returns the _prefixNamespaces function, which
+// re-encodes the output of xml.Marshal with the pinned prefixes.
+func prefixNamespaces() *gen.Function {
+	return gen.Func("_prefixNamespaces").
+		Args("data []byte", "prefixes map[string]string").
+		Returns("[]byte", "error").
+		Body(`
+				const xmlURI = "http://www.w3.org/XML/1998/namespace"
+				var (
+					tokens []xml.Token
+					used   = make(map[string]bool)
+					taken  = make(map[string]bool)
+				)
+				for _, p := range prefixes {
+					taken[p] = true
+				}
+				d := xml.NewDecoder(bytes.NewReader(data))
+				for {
+					tok, err := d.Token()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						return nil, err
+					}
+					if start, ok := tok.(xml.StartElement); ok {
+						if _, ok := prefixes[start.Name.Space]; ok {
+							used[start.Name.Space] = true
+						}
+						for _, a := range start.Attr {
+							switch a.Name.Space {
+							case "", "xmlns", xmlURI:
+							default:
+								used[a.Name.Space] = true
+							}
+						}
+					}
+					tokens = append(tokens, xml.CopyToken(tok))
+				}
+
+				// Namespaced attributes must be prefixed; make up prefixes
+				// for the ones that are not pinned.
+				var uris []string
+				for uri := range used {
+					uris = append(uris, uri)
+				}
+				sort.Strings(uris)
+				local := make(map[string]string, len(uris))
+				for _, uri := range uris {
+					if p, ok := prefixes[uri]; ok {
+						local[uri] = p
+						continue
+					}
+					for i := len(local) + 1; ; i++ {
+						if p := fmt.Sprintf("ns%%d", i); !taken[p] {
+							taken[p] = true
+							local[uri] = p
+							break
+						}
+					}
+				}
+
+				var (
+					buf      bytes.Buffer
+					names    []xml.Name
+					defaults = []string{""}
+				)
+				e := xml.NewEncoder(&buf)
+				for _, tok := range tokens {
+					var err error
+					switch tok := tok.(type) {
+					case xml.StartElement:
+						def := defaults[len(defaults)-1]
+						out := xml.StartElement{Name: xml.Name{Local: tok.Name.Local}}
+						if p, ok := prefixes[tok.Name.Space]; ok {
+							out.Name.Local = p + ":" + tok.Name.Local
+						} else if tok.Name.Space != def {
+							def = tok.Name.Space
+							out.Attr = append(out.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: def})
+						}
+						if len(names) == 0 {
+							for _, uri := range uris {
+								out.Attr = append(out.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:" + local[uri]}, Value: uri})
+							}
+						}
+						for _, a := range tok.Attr {
+							switch a.Name.Space {
+							case "":
+								if a.Name.Local == "xmlns" {
+									continue
+								}
+							case "xmlns":
+								continue
+							case xmlURI:
+								a.Name.Local = "xml:" + a.Name.Local
+							default:
+								a.Name.Local = local[a.Name.Space] + ":" + a.Name.Local
+							}
+							a.Name.Space = ""
+							out.Attr = append(out.Attr, a)
+						}
+						names = append(names, out.Name)
+						defaults = append(defaults, def)
+						err = e.EncodeToken(out)
+					case xml.EndElement:
+						err = e.EncodeToken(xml.EndElement{Name: names[len(names)-1]})
+						names = names[:len(names)-1]
+						defaults = defaults[:len(defaults)-1]
+					default:
+						err = e.EncodeToken(tok)
+					}
+					if err != nil {
+						return nil, err
+					}
+				}
+				if err := e.Flush(); err != nil {
+					return nil, err
+				}
+				return buf.Bytes(), nil
+			`)
+}
+
+// Documents found in the wild often begin with a byte order mark,
+// some white space, or an XML declaration for a character set other
+// than UTF-8. The generated Unmarshal function accepts these, as well
+// as what xml.Unmarshal accepts. Only character sets that can be
+// decoded with the standard library are supported.
+//
+// The errors returned by encoding/xml rarely say where in a document
+// they occurred. On failure, Unmarshal reads the document again, up to
+// the offset where decoding stopped, to find the path to the element
+// that was being decoded, and its text. encoding/xml does not check
+// the name of the root element either, so Unmarshal compares it with
+// the top-level elements declared with the type of v, if there are
+// any.
+func (cfg *Config) genUnmarshalHelper() ([]ast.Decl, error) {
+	typ := gen.TypeDecl(ast.NewIdent("DecodeError"), gen.Struct(
+		ast.NewIdent("Path"), ast.NewIdent("string"), nil,
+		ast.NewIdent("Expected"), ast.NewIdent("[]xml.Name"), nil,
+		ast.NewIdent("Got"), ast.NewIdent("xml.Name"), nil,
+		ast.NewIdent("Value"), ast.NewIdent("string"), nil,
+		ast.NewIdent("Err"), ast.NewIdent("error"), nil,
+	))
+	typ.Doc = &ast.CommentGroup{List: []*ast.Comment{
+		{Text: "// A DecodeError is returned by Unmarshal when a document cannot be"},
+		{Text: "// decoded. Path is the location of the element that was being decoded,"},
+		{Text: "// in the form of an XPath expression such as /Order/item[2]/quantity."},
+		{Text: "// If the element is not one of those expected there, Expected lists"},
+		{Text: "// those and Got is its name. If the text of the element could not be"},
+		{Text: "// decoded, Value holds it."},
+	}}
+
+	names := make([]xml.Name, 0, len(cfg.rootTypes))
+	for name := range cfg.rootTypes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	var types []string
+	roots := make(map[string][]xml.Name)
+	for _, name := range names {
+		typ := cfg.rootTypes[name]
+		if _, ok := roots[typ]; !ok {
+			types = append(types, typ)
+		}
+		roots[typ] = append(roots[typ], name)
+	}
+	sort.Strings(types)
+	var cases bytes.Buffer
+	for _, typ := range types {
+		fmt.Fprintf(&cases, "case *%s:\nreturn []xml.Name{", typ)
+		for _, name := range roots[typ] {
+			fmt.Fprintf(&cases, "{Space: %q, Local: %q},", name.Space, name.Local)
+		}
+		cases.WriteString("}\n")
+	}
+
+	result := []ast.Decl{typ}
+	fns := []*gen.Function{
+		gen.Func("Unmarshal").
+			Comment("// Unmarshal parses the XML document in data and stores the result in\n"+
+				"// the value pointed to by v, like xml.Unmarshal. A leading byte order\n"+
+				"// mark and white space are skipped, and documents in the US-ASCII\n"+
+				"// and ISO-8859-1 character sets are accepted. If the type of v is\n"+
+				"// that of top-level elements of the schema, the root element must\n"+
+				"// be one of them.").
+			Args("data []byte", "v interface{}").
+			Returns("error").
+			Body(`
+				data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+				data = bytes.TrimLeft(data, " \t\r\n")
+				if err := _checkRoot(data, v); err != nil {
+					return err
+				}
+				d := xml.NewDecoder(bytes.NewReader(data))
+				d.CharsetReader = _charsetReader
+				if err := d.Decode(v); err != nil {
+					path, value := _decodePath(data, d.InputOffset())
+					return &DecodeError{Path: path, Value: value, Err: err}
+				}
+				return nil
+			`),
+		gen.Func("Error").
+			Receiver("e *DecodeError").
+			Returns("string").
+			Body(`
+				if e.Value != "" {
+					return fmt.Sprintf("%%s: value %%q: %%v", e.Path, e.Value, e.Err)
+				}
+				return e.Path + ": " + e.Err.Error()
+			`),
+		gen.Func("_rootElements").
+			Args("v interface{}").
+			Returns("[]xml.Name").
+			Body(`
+				switch v.(type) {
+				%s
+				}
+				return nil
+			`, cases.String()),
+		gen.Func("_checkRoot").
+			Args("data []byte", "v interface{}").
+			Returns("error").
+			Body(`
+				want := _rootElements(v)
+				if len(want) == 0 {
+					return nil
+				}
+				d := xml.NewDecoder(bytes.NewReader(data))
+				d.CharsetReader = _charsetReader
+				for {
+					tok, err := d.Token()
+					if err != nil {
+						// Decoding the document reports it.
+						return nil
+					}
+					start, ok := tok.(xml.StartElement)
+					if !ok {
+						continue
+					}
+					expected := make([]string, len(want))
+					for i, name := range want {
+						if start.Name == name {
+							return nil
+						}
+						expected[i] = fmt.Sprintf("<%%s xmlns=%%q>", name.Local, name.Space)
+					}
+					return &DecodeError{
+						Path:     "/" + start.Name.Local,
+						Expected: want,
+						Got:      start.Name,
+						Err: fmt.Errorf("unexpected element <%%s xmlns=%%q>, expected %%s",
+							start.Name.Local, start.Name.Space, strings.Join(expected, " or ")),
+					}
+				}
+			`),
+		gen.Func("_decodePath").
+			Args("data []byte", "offset int64").
+			Returns("path string", "value string").
+			Body(`
+				type frame struct {
+					path     string
+					counts   map[string]int
+					text     []byte
+					children bool
+				}
+				stack := []frame{{counts: make(map[string]int)}}
+				path = "/"
+				d := xml.NewDecoder(bytes.NewReader(data))
+				d.CharsetReader = _charsetReader
+				for d.InputOffset() < offset {
+					tok, err := d.Token()
+					if err != nil {
+						// A syntax error is in the element that is open.
+						if len(stack) > 1 {
+							path, value = stack[len(stack)-1].path, ""
+						}
+						break
+					}
+					switch tok := tok.(type) {
+					case xml.StartElement:
+						top := &stack[len(stack)-1]
+						top.counts[tok.Name.Local]++
+						top.children = true
+						path, value = top.path+"/"+tok.Name.Local, ""
+						if n := top.counts[tok.Name.Local]; n > 1 {
+							path += fmt.Sprintf("[%%d]", n)
+						}
+						stack = append(stack, frame{path: path, counts: make(map[string]int)})
+					case xml.CharData:
+						top := &stack[len(stack)-1]
+						top.text = append(top.text, tok...)
+					case xml.EndElement:
+						// Values are parsed once their end tag is read,
+						// so the element just closed is the one that
+						// failed, if nothing follows it.
+						if len(stack) > 1 {
+							top := stack[len(stack)-1]
+							path, value = top.path, ""
+							if !top.children {
+								value = string(top.text)
+							}
+							stack = stack[:len(stack)-1]
+						}
+					}
+				}
+				return path, value
+			`),
+		gen.Func("_charsetReader").
+			Args("charset string", "input io.Reader").
+			Returns("io.Reader", "error").
+			Body(`
+				switch strings.ToLower(charset) {
+				case "utf-8", "utf8", "us-ascii", "ascii":
+					return input, nil
+				case "iso-8859-1", "iso_8859-1", "latin1", "latin-1":
+					data, err := ioutil.ReadAll(input)
+					if err != nil {
+						return nil, err
+					}
+					buf := make([]rune, len(data))
+					for i, b := range data {
+						buf[i] = rune(b)
+					}
+					return strings.NewReader(string(buf)), nil
+				}
+				return nil, fmt.Errorf("unsupported character set %%q", charset)
+			`),
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decl)
+	}
+	return result, nil
+}
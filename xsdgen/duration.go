@@ -0,0 +1,171 @@
+package xsdgen
+
+import (
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// An xs:duration is a number of years, months, days, hours, minutes and
+// seconds. Because months and years differ in length, it cannot be
+// converted to a time.Duration exactly. The generated type keeps each
+// component, and provides methods to approximate a time.Duration or to
+// add the duration to a point in time.
+func (cfg *Config) genDurationSpec(t xsd.Builtin) ([]spec, error) {
+	cfg.debugf("generating Go source for duration type %q", xsd.XMLName(t).Local)
+	s := spec{
+		name: "xsdDuration",
+		expr: gen.Struct(
+			ast.NewIdent("Negative"), ast.NewIdent("bool"), nil,
+			ast.NewIdent("Years"), ast.NewIdent("int"), nil,
+			ast.NewIdent("Months"), ast.NewIdent("int"), nil,
+			ast.NewIdent("Days"), ast.NewIdent("int"), nil,
+			ast.NewIdent("Hours"), ast.NewIdent("int"), nil,
+			ast.NewIdent("Minutes"), ast.NewIdent("int"), nil,
+			ast.NewIdent("Seconds"), ast.NewIdent("float64"), nil,
+		),
+		xsdType: t,
+	}
+	fns := []*gen.Function{
+		gen.Func("UnmarshalText").
+			Receiver("d *" + s.name).
+			Args("text []byte").
+			Returns("error").
+			Body(`
+				var v xsdDuration
+				s := string(bytes.TrimSpace(text))
+				if strings.HasPrefix(s, "-") {
+					v.Negative = true
+					s = s[1:]
+				}
+				if !strings.HasPrefix(s, "P") || len(s) < 2 || strings.HasSuffix(s, "T") {
+					return fmt.Errorf("invalid duration %%q", text)
+				}
+				s = s[1:]
+				for inTime := false; len(s) > 0; {
+					if s[0] == 'T' && !inTime {
+						inTime = true
+						s = s[1:]
+						continue
+					}
+					i := strings.IndexFunc(s, func(r rune) bool {
+						return (r < '0' || r > '9') && r != '.'
+					})
+					if i <= 0 {
+						return fmt.Errorf("invalid duration %%q", text)
+					}
+					num, designator := s[:i], s[i]
+					s = s[i+1:]
+					if inTime && designator == 'S' {
+						sec, err := strconv.ParseFloat(num, 64)
+						if err != nil {
+							return fmt.Errorf("invalid duration %%q: %%v", text, err)
+						}
+						v.Seconds = sec
+						continue
+					}
+					n, err := strconv.Atoi(num)
+					if err != nil {
+						return fmt.Errorf("invalid duration %%q: %%v", text, err)
+					}
+					switch {
+					case !inTime && designator == 'Y':
+						v.Years = n
+					case !inTime && designator == 'M':
+						v.Months = n
+					case !inTime && designator == 'D':
+						v.Days = n
+					case inTime && designator == 'H':
+						v.Hours = n
+					case inTime && designator == 'M':
+						v.Minutes = n
+					default:
+						return fmt.Errorf("invalid duration %%q", text)
+					}
+				}
+				*d = v
+				return nil
+			`),
+		gen.Func("MarshalText").
+			Receiver("d "+s.name).
+			Returns("[]byte", "error").
+			Body(`
+				var buf bytes.Buffer
+				if d.Negative {
+					buf.WriteString("-")
+				}
+				buf.WriteString("P")
+				for _, c := range []struct {
+					n          int
+					designator string
+				}{{d.Years, "Y"}, {d.Months, "M"}, {d.Days, "D"}} {
+					if c.n != 0 {
+						fmt.Fprintf(&buf, "%%d%%s", c.n, c.designator)
+					}
+				}
+				if d.Hours != 0 || d.Minutes != 0 || d.Seconds != 0 {
+					buf.WriteString("T")
+					if d.Hours != 0 {
+						fmt.Fprintf(&buf, "%%dH", d.Hours)
+					}
+					if d.Minutes != 0 {
+						fmt.Fprintf(&buf, "%%dM", d.Minutes)
+					}
+					if d.Seconds != 0 {
+						buf.WriteString(strconv.FormatFloat(d.Seconds, 'f', -1, 64) + "S")
+					}
+				}
+				if buf.Len() == 1 || d.Negative && buf.Len() == 2 {
+					return []byte("PT0S"), nil
+				}
+				return buf.Bytes(), nil
+			`),
+		gen.Func("ApproxDuration").
+			Comment("// ApproxDuration converts d to a time.Duration, assuming that a month\n" +
+				"// is 30 days long and a year is 365 days long. Use AddTo for\n" +
+				"// calendar-correct arithmetic.").
+			Receiver("d " + s.name).
+			Returns("time.Duration").
+			Body(`
+				const day = 24 * time.Hour
+				x := time.Duration(d.Years)*365*day +
+					time.Duration(d.Months)*30*day +
+					time.Duration(d.Days)*day +
+					d.clock()
+				if d.Negative {
+					return -x
+				}
+				return x
+			`),
+		gen.Func("AddTo").
+			Comment("// AddTo returns the time t+d. The years, months and days of d are\n" +
+				"// added with t.AddDate, so the length of a month depends on t.").
+			Receiver("d " + s.name).
+			Args("t time.Time").
+			Returns("time.Time").
+			Body(`
+				if d.Negative {
+					return t.AddDate(-d.Years, -d.Months, -d.Days).Add(-d.clock())
+				}
+				return t.AddDate(d.Years, d.Months, d.Days).Add(d.clock())
+			`),
+		gen.Func("clock").
+			Receiver("d " + s.name).
+			Returns("time.Duration").
+			Body(`
+				return time.Duration(d.Hours)*time.Hour +
+					time.Duration(d.Minutes)*time.Minute +
+					time.Duration(d.Seconds*float64(time.Second))
+			`),
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, fmt.Errorf("xsdDuration: %v", err)
+		}
+		s.methods = append(s.methods, decl)
+	}
+	return []spec{s}, nil
+}
@@ -0,0 +1,166 @@
+package xsdgen
+
+import (
+	"go/ast"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// enumValues returns the fixed set of values that a simpleType may
+// hold, if there is one. Besides <enumeration> facets, some schema use
+// a pattern that is nothing more than a list of alternatives, such as
+// (GET|PUT|POST), to the same effect.
+func enumValues(t *xsd.SimpleType) []string {
+	if len(t.Restriction.Enum) > 0 {
+		return t.Restriction.Enum
+	}
+	if t.Restriction.Pattern != nil {
+		if lits, ok := patternLiterals(t.Restriction.Pattern.String()); ok {
+			return lits
+		}
+	}
+	return nil
+}
+
+// patternLiterals returns the alternatives of a regular expression of
+// the form lit1|lit2|..., optionally enclosed in parentheses, where
+// each alternative matches exactly one string. Any other pattern
+// returns false.
+func patternLiterals(pat string) ([]string, bool) {
+	if strings.HasPrefix(pat, "(") && strings.HasSuffix(pat, ")") &&
+		strings.Count(pat, "(") == 1 && strings.Count(pat, ")") == 1 {
+		pat = strings.TrimPrefix(pat[1:len(pat)-1], "?:")
+	}
+	var (
+		result []string
+		lit    []rune
+	)
+	for i := 0; i < len(pat); {
+		r, size := utf8.DecodeRuneInString(pat[i:])
+		i += size
+		switch r {
+		case '|':
+			if len(lit) == 0 {
+				return nil, false
+			}
+			result = append(result, string(lit))
+			lit = lit[:0]
+			continue
+		case '\\':
+			// Only escaped metacharacters are literals; \d, \i
+			// and the like match more than one character.
+			if i == len(pat) || !strings.ContainsRune(`\|.-^$?*+{}()[]`, rune(pat[i])) {
+				return nil, false
+			}
+			r = rune(pat[i])
+			i++
+		case '.', '^', '$', '?', '*', '+', '{', '}', '(', ')', '[', ']':
+			return nil, false
+		}
+		lit = append(lit, r)
+	}
+	if len(lit) == 0 {
+		return nil, false
+	}
+	return append(result, string(lit)), true
+}
+
+// constName derives the name of a constant for an enumerated value
+// from its type name and the value itself, by title-casing each run
+// of letters and digits in the value.
+func constName(typeName, value string) string {
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(parts) == 0 {
+		return ""
+	}
+	for i, p := range parts {
+		r, size := utf8.DecodeRuneInString(p)
+		parts[i] = string(unicode.ToUpper(r)) + p[size:]
+	}
+	return typeName + strings.Join(parts, "")
+}
+
+// genEnumConstants declares a constant for each of the values that a
+// simpleType may hold. Values that cannot be expressed as a Go
+// constant of the type, or that would produce a duplicate name, are
+// skipped.
+func (cfg *Config) genEnumConstants(s spec, t *xsd.SimpleType) ast.Decl {
+	values := enumValues(t)
+	if len(values) == 0 {
+		return nil
+	}
+	kind := exprKind(s.expr)
+	var args []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		name := constName(s.name, v)
+		if name == "" || seen[name] {
+			cfg.logf("simpleType %s: no constant declared for value %q", t.Name.Local, v)
+			continue
+		}
+		switch kind {
+		case "string":
+		case "int":
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				cfg.logf("simpleType %s: enumerated value %q is not an integer", t.Name.Local, v)
+				continue
+			}
+			v = strconv.FormatInt(n, 10)
+		case "float":
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+				cfg.logf("simpleType %s: enumerated value %q is not a finite number", t.Name.Local, v)
+				continue
+			}
+			v = strconv.FormatFloat(f, 'g', -1, 64)
+			if !strings.ContainsAny(v, ".e") {
+				v += ".0"
+			}
+		default:
+			cfg.debugf("simpleType %s: cannot declare constants of type %s",
+				t.Name.Local, gen.ExprString(s.expr))
+			return nil
+		}
+		seen[name] = true
+		args = append(args, name, s.name, v)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	cfg.debugf("declaring %d constants for simpleType %s", len(args)/3, t.Name.Local)
+	switch kind {
+	case "int":
+		return gen.ConstInt(args...)
+	case "float":
+		return gen.ConstFloat(args...)
+	}
+	return gen.ConstString(args...)
+}
+
+// exprKind reports whether a Go type expression for a built-in type
+// is a "string", "int", "float", or something else (the empty string).
+func exprKind(expr ast.Expr) string {
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	switch ident.Name {
+	case "string":
+		return "string"
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "byte":
+		return "int"
+	case "float32", "float64":
+		return "float"
+	}
+	return ""
+}
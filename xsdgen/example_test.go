@@ -282,10 +282,7 @@ func ExampleUseFieldNames() {
 	// 	Author    string  `xml:"http://www.example.com/ author"`
 	// }
 	// type Library struct {
-	// 	Book      []Book  `xml:"http://www.example.com/ book"`
-	// 	Title     string  `xml:"http://www.example.com/ title"`
-	// 	Published xsdDate `xml:"http://www.example.com/ published"`
-	// 	Author    string  `xml:"http://www.example.com/ author"`
+	// 	Book []Book `xml:"http://www.example.com/ book"`
 	// }
 	// type xsdDate time.Time
 	//
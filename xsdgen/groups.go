@@ -0,0 +1,341 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"strconv"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// When a sequence or choice may appear more than once, as in
+//
+// 	<sequence maxOccurs="unbounded">
+// 	  <element name="date" type="date" />
+// 	  <element name="value" type="decimal" />
+// 	</sequence>
+//
+// giving each of its elements a slice of its own would lose track of
+// which date goes with which value. With the KeepRepeatingGroups
+// option, the elements of the group are moved to a struct type of
+// their own, and the type that holds the group has a slice of it, with
+// an UnmarshalXML method that starts a new item each time the group
+// repeats. Where such a method cannot decode the type, the option is
+// an error rather than a silent loss of the pairing.
+
+// genGroups replaces the elements of the repeating groups of t with
+// elements of struct types declared for the groups. It returns the
+// specs of those types, and the UnmarshalXML method of t.
+func (cfg *Config) genGroups(t *xsd.ComplexType) ([]spec, *ast.FuncDecl, error) {
+	if !cfg.repeatingGroups {
+		return nil, nil, nil
+	}
+	_, elements := cfg.filterFields(t)
+	var (
+		order   []int
+		members = make(map[int][]xsd.Element)
+	)
+	for _, el := range elements {
+		if el.Group == 0 {
+			continue
+		}
+		if _, ok := members[el.Group]; !ok {
+			order = append(order, el.Group)
+		}
+		members[el.Group] = append(members[el.Group], el)
+	}
+	// A group whose elements are alternatives has no elements to
+	// keep together.
+	var kept []int
+	for _, n := range order {
+		positions := make(map[int]bool)
+		for _, el := range members[n] {
+			positions[el.GroupIndex] = true
+		}
+		if len(positions) > 1 {
+			kept = append(kept, n)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil, nil
+	}
+	if reason := cfg.groupConflict(t); reason != "" {
+		return nil, nil, fmt.Errorf("complexType %s: cannot keep the elements of its repeating groups together, because %s",
+			t.Name.Local, reason)
+	}
+
+	var (
+		result []spec
+		groups []repeatedGroup
+	)
+	for _, n := range kept {
+		suffix := ""
+		if len(groups) > 0 {
+			suffix = strconv.Itoa(len(groups) + 1)
+		}
+		g := &xsd.ComplexType{
+			Name: xml.Name{Space: t.Name.Space, Local: t.Name.Local + "Group" + suffix},
+			Base: xsd.AnyType,
+		}
+		for _, el := range members[n] {
+			el.Group = 0
+			el.MinOccurs, el.MaxOccurs = el.GroupMinOccurs, el.GroupMaxOccurs
+			el.Optional = el.MinOccurs == 0
+			el.Plural = el.MinOccurs > 1 || el.MaxOccurs < 0 || el.MaxOccurs > 1
+			g.Elements = append(g.Elements, el)
+		}
+		specs, err := cfg.genComplexType(g)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, s := range specs {
+			if s.xsdType != g {
+				continue
+			}
+			marshal, err := cfg.genGroupMarshal(s, g)
+			if err != nil {
+				return nil, nil, err
+			}
+			specs[i].methods = append(specs[i].methods, marshal)
+		}
+		result = append(result, specs...)
+		if cfg.groupTypes == nil {
+			cfg.groupTypes = make(map[*xsd.ComplexType]bool)
+		}
+		cfg.groupTypes[g] = true
+		groups = append(groups, repeatedGroup{
+			n:     n,
+			field: "Groups" + suffix,
+			typ:   g,
+		})
+	}
+	if len(groups) == 0 {
+		return nil, nil, nil
+	}
+
+	// The elements holding the groups take the place of the first
+	// elements of the groups.
+	var rest []xsd.Element
+	placed := make(map[int]bool)
+	for _, el := range t.Elements {
+		g := findGroup(groups, el.Group)
+		if g == nil {
+			rest = append(rest, el)
+			continue
+		}
+		if !placed[el.Group] {
+			rest = append(rest, xsd.Element{
+				Name:      xml.Name{Space: t.Name.Space, Local: g.field},
+				Type:      g.typ,
+				Plural:    true,
+				Optional:  true,
+				MaxOccurs: -1,
+			})
+			placed[el.Group] = true
+		}
+	}
+	t.Elements = rest
+
+	unmarshal, err := cfg.genGroupUnmarshal(t, groups)
+	if err != nil {
+		return nil, nil, err
+	}
+	helper, err := cfg.genGroupElementSpec()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(result, helper), unmarshal, nil
+}
+
+// A repeatedGroup is a repeating group of a complex type, and the
+// struct field and type that hold it.
+type repeatedGroup struct {
+	n     int
+	field string
+	typ   *xsd.ComplexType
+}
+
+func findGroup(groups []repeatedGroup, n int) *repeatedGroup {
+	for i := range groups {
+		if groups[i].n == n && n > 0 {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// groupConflict returns the reason that the repeating groups of t
+// cannot be decoded by an UnmarshalXML method of its own, or the
+// empty string if they can. The method decodes t through an overlay
+// that hides the methods of the types t embeds, so it would skip the
+// decoding of a base type, be promoted to the types that extend t,
+// take the elements meant for a wildcard, or replace the method that
+// sets attribute defaults.
+func (cfg *Config) groupConflict(t *xsd.ComplexType) string {
+	if _, ok := t.Base.(*xsd.ComplexType); ok && t.Extends {
+		return "it extends " + xsd.XMLName(t.Base).Local
+	}
+	// A type that embeds t would be decoded by the UnmarshalXML
+	// method of t, which does not know its other fields.
+	if cfg.extendedTypes[t] {
+		return "it is extended by another type"
+	}
+	for _, el := range t.Elements {
+		if el.Wildcard {
+			return "it has a wildcard element"
+		}
+	}
+	if cfg.coreTypes[t.Name] {
+		return "it is split by the CoreType option"
+	}
+	if len(cfg.defaultAttributes(t)) > 0 {
+		return "it has attributes with default values"
+	}
+	return ""
+}
+
+func (cfg *Config) genGroupUnmarshal(t *xsd.ComplexType, groups []repeatedGroup) (*ast.FuncDecl, error) {
+	name := cfg.typeName(t.Name)
+	var cases bytes.Buffer
+	for k, g := range groups {
+		for _, el := range g.typ.Elements {
+			match := fmt.Sprintf("name.Local == %q", el.Name.Local)
+			if el.Name.Space != "" {
+				match += fmt.Sprintf(" && name.Space == %q", el.Name.Space)
+			}
+			// An element that may repeat within the group
+			// continues it; otherwise, an element at or before
+			// the position of the last one starts a new item.
+			cmp := ">="
+			if el.Plural {
+				cmp = ">"
+			}
+			fmt.Fprintf(&cases, `case %s:
+				if len(t.%[2]s) == 0 || last[%[3]d] %[4]s %[5]d {
+					t.%[2]s = append(t.%[2]s, %[6]s{})
+				}
+				last[%[3]d] = %[5]d
+				err = el.decode(&t.%[2]s[len(t.%[2]s)-1].%[7]s)
+			`, match, g.field, k, cmp, el.GroupIndex, cfg.typeName(g.typ.Name), cfg.fieldName(g.typ, el.Name))
+		}
+	}
+	fn, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML decodes the elements of the repeating groups of t\n"+
+			"// in document order, starting a new item of a group each time it\n"+
+			"// repeats.").
+		Receiver("t *"+name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			%[1]s
+			if err := d.DecodeElement(&overlay, &start); err != nil {
+				return err
+			}
+			last := make([]int, %[2]d)
+			for _, el := range overlay.Elements {
+				var err error
+				switch name := el[0].(xml.StartElement).Name; {
+				%[3]s
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		`, decodeOverlay(name, "Elements []xsdGroupElement `xml:\",any\"`"), len(groups), cases.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", name, err)
+	}
+	return fn, nil
+}
+
+// The elements of a group are written in the order of their fields,
+// without an element for the group itself.
+func (cfg *Config) genGroupMarshal(s spec, g *xsd.ComplexType) (*ast.FuncDecl, error) {
+	var body bytes.Buffer
+	_, elements := cfg.filterFields(g)
+	for _, el := range elements {
+		fmt.Fprintf(&body, `if err := e.EncodeElement(&g.%s, xml.StartElement{Name: xml.Name{Space: %q, Local: %q}}); err != nil {
+				return err
+			}
+		`, cfg.fieldName(g, el.Name), el.Name.Space, el.Name.Local)
+	}
+	fn, err := gen.Func("MarshalXML").
+		Comment("// MarshalXML writes the elements of g. The group is not an element\n"+
+			"// of its own, so start is not written.").
+		Receiver("g "+s.name).
+		Args("e *xml.Encoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			%s
+			return nil
+		`, body.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalXML %s: %v", s.name, err)
+	}
+	return fn, nil
+}
+
+// An xsdGroupElement holds the tokens of an element, including its
+// start and end, so that it can be decoded once the group it belongs
+// to is known.
+func (cfg *Config) genGroupElementSpec() (spec, error) {
+	s := spec{
+		name: "xsdGroupElement",
+		expr: &ast.ArrayType{Elt: ast.NewIdent("xml.Token")},
+	}
+	fns := []*gen.Function{
+		gen.Func("UnmarshalXML").
+			Receiver("el *"+s.name).
+			Args("d *xml.Decoder", "start xml.StartElement").
+			Returns("error").
+			Body(`
+				*el = append(*el, start.Copy())
+				for depth := 1; depth > 0; {
+					tok, err := d.Token()
+					if err != nil {
+						return err
+					}
+					switch tok.(type) {
+					case xml.StartElement:
+						depth++
+					case xml.EndElement:
+						depth--
+					}
+					*el = append(*el, xml.CopyToken(tok))
+				}
+				return nil
+			`),
+		gen.Func("Token").
+			Receiver("el *"+s.name).
+			Returns("xml.Token", "error").
+			Body(`
+				if len(*el) == 0 {
+					return nil, io.EOF
+				}
+				tok := (*el)[0]
+				*el = (*el)[1:]
+				return tok, nil
+			`),
+		gen.Func("decode").
+			Receiver("el " + s.name).
+			Args("v interface{}").
+			Returns("error").
+			Body(`
+				return xml.NewTokenDecoder(&el).Decode(v)
+			`),
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return s, fmt.Errorf("%s: %v", s.name, err)
+		}
+		s.methods = append(s.methods, decl)
+	}
+	return s, nil
+}
@@ -0,0 +1,234 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// encoding/json sees a choice as a struct with one field for every
+// alternative, all but one of them empty, and a union as the string
+// it is declared as. The methods generated here give both a more
+// natural JSON form.
+func (cfg *Config) addJSONMarshalers(decls map[string]spec) error {
+	for name, s := range decls {
+		var fns []*ast.FuncDecl
+		var err error
+		switch t := s.xsdType.(type) {
+		case *xsd.ComplexType:
+			fns, err = cfg.genChoiceJSON(s, t)
+		case *xsd.SimpleType:
+			if len(t.Union) > 0 {
+				fns, err = cfg.genUnionJSON(s, t)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fns...)
+		decls[name] = s
+	}
+	return nil
+}
+
+// choiceFields returns the fields of a struct that hold the
+// alternatives of a choice, keyed by element name, if the struct
+// holds nothing else.
+func choiceFields(s spec, t *xsd.ComplexType) (names []string, fields []*ast.Field) {
+	str, ok := s.expr.(*ast.StructType)
+	if !ok || len(t.Attributes) > 0 || len(t.Elements) == 0 {
+		return nil, nil
+	}
+	choices := make(map[string]bool)
+	for _, el := range t.Elements {
+		if el.Wildcard || el.Choice != t.Elements[0].Choice || el.Choice == 0 {
+			return nil, nil
+		}
+		choices[el.Name.Local] = true
+	}
+	for _, field := range str.Fields.List {
+		local := elementName(field)
+		if len(field.Names) == 0 || !choices[local] {
+			return nil, nil
+		}
+		names = append(names, local)
+		fields = append(fields, field)
+	}
+	return names, fields
+}
+
+func (cfg *Config) genChoiceJSON(s spec, t *xsd.ComplexType) ([]*ast.FuncDecl, error) {
+	names, fields := choiceFields(s, t)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	var marshal, unmarshal bytes.Buffer
+	for i, field := range fields {
+		fmt.Fprintf(&marshal, `
+			case !reflect.ValueOf(v.%[1]s).IsZero():
+				return json.Marshal(struct {
+					Type string `+"`json:\"type\"`"+`
+					Value %[2]s `+"`json:%[3]q`"+`
+				}{%[3]q, v.%[1]s})`,
+			field.Names[0].Name, gen.ExprString(field.Type), names[i])
+		fmt.Fprintf(&unmarshal, `
+			case %q:
+				return json.Unmarshal(obj[%[1]q], &v.%s)`,
+			names[i], field.Names[0].Name)
+	}
+	marshalFn, err := gen.Func("MarshalJSON").
+		Comment("// MarshalJSON encodes the alternative of the choice that is present\n"+
+			"// as an object with its element name in the type field. If no\n"+
+			"// alternative is present, v is encoded as null.").
+		Receiver("v "+s.name).
+		Returns("[]byte", "error").
+		Body(`
+			switch {
+			%s
+			}
+			return []byte("null"), nil
+		`, marshal.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalJSON %s: %v", s.name, err)
+	}
+	unmarshalFn, err := gen.Func("UnmarshalJSON").
+		Receiver("v *"+s.name).
+		Args("data []byte").
+		Returns("error").
+		Body(`
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(data, &obj); err != nil {
+				return err
+			}
+			*v = %[1]s{}
+			if obj == nil {
+				return nil
+			}
+			var typ string
+			if raw, ok := obj["type"]; !ok {
+				return errors.New("%[1]s: missing type field")
+			} else if err := json.Unmarshal(raw, &typ); err != nil {
+				return fmt.Errorf("%[1]s: type field: %%v", err)
+			}
+			switch typ {
+			%[2]s
+			}
+			return fmt.Errorf("%[1]s: unknown type %%q", typ)
+		`, s.name, unmarshal.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalJSON %s: %v", s.name, err)
+	}
+	return []*ast.FuncDecl{marshalFn, unmarshalFn}, nil
+}
+
+// unionKinds returns the kinds of value that a value of a union
+// type may be, in the order that they are tried: bool, float, string,
+// or the name of a Go integer type, depending on the built-in type
+// that each member type is derived from.
+func unionKinds(t *xsd.SimpleType) []string {
+	var kinds []string
+	seen := make(map[string]bool)
+	for _, member := range t.Union {
+		kind := "string"
+		for b := member; b != nil; b = xsd.Base(b) {
+			if st, ok := b.(*xsd.SimpleType); ok && (st.List || len(st.Union) > 0) {
+				break
+			}
+			if builtin, ok := b.(xsd.Builtin); ok {
+				switch expr := builtinExpr(builtin); exprKind(expr) {
+				case "int":
+					kind = gen.ExprString(expr)
+				case "float":
+					kind = "float"
+				default:
+					if gen.ExprString(expr) == "bool" {
+						kind = "bool"
+					}
+				}
+				break
+			}
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+		if kind == "string" {
+			break
+		}
+	}
+	return kinds
+}
+
+func (cfg *Config) genUnionJSON(s spec, t *xsd.SimpleType) ([]*ast.FuncDecl, error) {
+	if gen.ExprString(s.expr) != "string" {
+		return nil, nil
+	}
+	var body bytes.Buffer
+	for _, kind := range unionKinds(t) {
+		switch kind {
+		case "bool":
+			body.WriteString(`
+				switch s {
+				case "true", "1":
+					return []byte("true"), nil
+				case "false", "0":
+					return []byte("false"), nil
+				}`)
+		case "float":
+			body.WriteString(`
+				if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+					return json.Marshal(f)
+				}`)
+		case "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+			body.WriteString(`
+				if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+					return []byte(strconv.FormatUint(n, 10)), nil
+				}`)
+		case "string":
+		default:
+			body.WriteString(`
+				if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return []byte(strconv.FormatInt(n, 10)), nil
+				}`)
+		}
+	}
+	marshalFn, err := gen.Func("MarshalJSON").
+		Comment("// MarshalJSON encodes v as the JSON value of the first member type\n"+
+			"// of the union that v is valid for.").
+		Receiver("v "+s.name).
+		Returns("[]byte", "error").
+		Body(`
+			s := strings.TrimSpace(string(v))
+			%s
+			return json.Marshal(string(v))
+		`, body.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalJSON %s: %v", s.name, err)
+	}
+	unmarshalFn, err := gen.Func("UnmarshalJSON").
+		Receiver("v *"+s.name).
+		Args("data []byte").
+		Returns("error").
+		Body(`
+			data = bytes.TrimSpace(data)
+			if string(data) == "null" {
+				return nil
+			}
+			if len(data) > 0 && data[0] == '"' {
+				return json.Unmarshal(data, (*string)(v))
+			}
+			*v = %s(data)
+			return nil
+		`, s.name).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalJSON %s: %v", s.name, err)
+	}
+	return []*ast.FuncDecl{marshalFn, unmarshalFn}, nil
+}
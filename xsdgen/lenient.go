@@ -0,0 +1,197 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// encoding/xml only matches an element to a field whose tag has the
+// same namespace, and silently skips an element in another namespace,
+// or in none. With the LenientNamespaces option, the UnmarshalXML
+// methods generated here move child elements that match no field into
+// the namespace of the field with the same local name, before they are
+// decoded.
+
+func (cfg *Config) addLenientDecoders(decls map[string]spec) error {
+	for name, s := range decls {
+		if !hasValidateAll(s) {
+			continue
+		}
+		spaces := make(map[string]string)
+		elementSpaces(s, decls, spaces)
+		for local, space := range spaces {
+			if space == "" {
+				delete(spaces, local)
+			}
+		}
+		if len(spaces) == 0 {
+			continue
+		}
+		fn, err := cfg.genLenientUnmarshal(&s, spaces)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+// elementSpaces maps the local names of the elements of a struct
+// type, including those of the struct types it embeds, to their
+// namespaces. A local name used in more than one namespace is mapped
+// to the empty string, as an element with that name cannot be placed.
+func elementSpaces(s spec, decls map[string]spec, spaces map[string]string) {
+	str, ok := s.expr.(*ast.StructType)
+	if !ok {
+		return
+	}
+	for _, field := range str.Fields.List {
+		if len(field.Names) == 0 {
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				if base, ok := decls[ident.Name]; ok {
+					elementSpaces(base, decls, spaces)
+				}
+			}
+			continue
+		}
+		space, local, ok := fieldXMLTag(field)
+		if !ok {
+			continue
+		}
+		if _, _, flags, _ := xmlTag(field); hasFlag(flags, "attr") {
+			continue
+		}
+		if prev, ok := spaces[local]; ok && prev != space {
+			space = ""
+		}
+		spaces[local] = space
+	}
+}
+
+// genLenientUnmarshal generates an UnmarshalXML method that decodes
+// t from a reader that corrects the namespaces of its child elements.
+// A type that already has an UnmarshalXML method, such as one setting
+// attribute defaults, is decoded with it from the reader.
+func (cfg *Config) genLenientUnmarshal(s *spec, spaces map[string]string) (*ast.FuncDecl, error) {
+	locals := make([]string, 0, len(spaces))
+	for local := range spaces {
+		locals = append(locals, local)
+	}
+	sort.Strings(locals)
+	var lit bytes.Buffer
+	for _, local := range locals {
+		fmt.Fprintf(&lit, "%q: %q,\n", local, spaces[local])
+	}
+	fn, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML decodes the element start into t, decoding child\n"+
+			"// elements that are in the wrong namespace, or in none, into the\n"+
+			"// fields with the same local names.").
+		Receiver("t *"+s.name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			r := &_lenientReader{d: d, start: start, spaces: map[string]string{
+				%s
+			}}
+			// The reader starts with the start element, which
+			// has been read from d already.
+			d = xml.NewTokenDecoder(r)
+			if _, err := d.Token(); err != nil {
+				return err
+			}
+			%s
+			return err
+		`, lit.String(), wrapUnmarshal(s, "unmarshalStrict")).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", s.name, err)
+	}
+	return fn, nil
+}
+
+func (cfg *Config) genLenientHelpers() ([]ast.Decl, error) {
+	logger, err := parser.ParseExpr(`func(got xml.Name, want string) {
+		log.Printf("decoding element %s in namespace %q as if it were in %q", got.Local, got.Space, want)
+	}`)
+	if err != nil {
+		return nil, err
+	}
+	result := []ast.Decl{
+		&ast.GenDecl{
+			Tok: token.VAR,
+			Doc: &ast.CommentGroup{List: []*ast.Comment{
+				{Text: "// NamespaceMismatch is called for each element that is decoded in"},
+				{Text: "// spite of being in the wrong namespace, with the name of the element"},
+				{Text: "// and the namespace it should be in. By default, it logs the element"},
+				{Text: "// with the log package. It may be set to nil."},
+			}},
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names:  []*ast.Ident{ast.NewIdent("NamespaceMismatch")},
+					Values: []ast.Expr{logger},
+				},
+			},
+		},
+		gen.TypeDecl(ast.NewIdent("_lenientReader"), gen.Struct(
+			ast.NewIdent("d"), ast.NewIdent("*xml.Decoder"), nil,
+			ast.NewIdent("start"), ast.NewIdent("xml.StartElement"), nil,
+			ast.NewIdent("spaces"), ast.NewIdent("map[string]string"), nil,
+			ast.NewIdent("depth"), ast.NewIdent("int"), nil,
+			ast.NewIdent("renamed"), ast.NewIdent("bool"), nil,
+		)),
+	}
+	fn, err := gen.Func("Token").
+		Receiver("r *_lenientReader").
+		Returns("xml.Token", "error").
+		Body(`
+			// The start element has been read from d already,
+			// and the reader ends with the end of it.
+			switch {
+			case r.depth == 0 && r.start.Name.Local != "":
+				start := r.start
+				r.start = xml.StartElement{}
+				r.depth++
+				return start, nil
+			case r.depth == 0:
+				return nil, io.EOF
+			}
+			tok, err := r.d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				r.depth++
+				want, ok := r.spaces[tok.Name.Local]
+				if r.depth != 2 || !ok || tok.Name.Space == want {
+					return tok, nil
+				}
+				if NamespaceMismatch != nil {
+					NamespaceMismatch(tok.Name, want)
+				}
+				r.renamed = true
+				tok.Name.Space = want
+				return tok, nil
+			case xml.EndElement:
+				r.depth--
+				if r.depth == 1 && r.renamed {
+					r.renamed = false
+					tok.Name.Space = r.spaces[tok.Name.Local]
+				}
+				return tok, nil
+			}
+			return tok, nil
+		`).
+		Decl()
+	if err != nil {
+		return nil, err
+	}
+	return append(result, fn), nil
+}
@@ -0,0 +1,83 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// The value of a NOTATION attribute names one of the notations
+// declared in the schema. When the schema declares any, fields of
+// type NOTATION are given the type xsdNotation, whose Validate method
+// checks the name against them.
+
+// collectNotations records the names of the notations declared in a
+// set of schema.
+func (cfg *Config) collectNotations(schemas ...xsd.Schema) {
+	seen := make(map[string]bool)
+	cfg.notations = nil
+	for _, s := range schemas {
+		for name := range s.Notations {
+			if !seen[name.Local] {
+				seen[name.Local] = true
+				cfg.notations = append(cfg.notations, name.Local)
+			}
+		}
+	}
+	sort.Strings(cfg.notations)
+}
+
+// notationType returns the name of the type declared for a built-in
+// when it is NOTATION and the schema declares notations, or the empty
+// string.
+func (cfg *Config) notationType(t xsd.Builtin) string {
+	if t != xsd.NOTATION || len(cfg.notations) == 0 {
+		return ""
+	}
+	return "xsdNotation"
+}
+
+func (cfg *Config) genNotationSpec(t xsd.Builtin) ([]spec, error) {
+	cfg.debugf("generating Go source for %d notations", len(cfg.notations))
+	s := spec{
+		name:    cfg.notationType(t),
+		expr:    builtinExpr(xsd.String),
+		xsdType: t,
+	}
+	if !cfg.emitValidators {
+		return []spec{s}, nil
+	}
+	var cases bytes.Buffer
+	for i, name := range cfg.notations {
+		if i > 0 {
+			cases.WriteString(", ")
+		}
+		fmt.Fprintf(&cases, "%q", name)
+	}
+	fn, err := gen.Func("Validate").
+		Comment("// Validate checks that v names a notation declared in the schema.\n"+
+			"// The prefix of the name, if any, is not resolved. An empty name\n"+
+			"// is that of an attribute that is absent, and is accepted.").
+		Receiver("v "+s.name).
+		Returns("error").
+		Body(`
+			name := string(v)
+			if i := strings.IndexByte(name, ':'); i >= 0 {
+				name = name[i+1:]
+			}
+			switch name {
+			case "", %s:
+				return nil
+			}
+			return fmt.Errorf("%%q is not a declared notation", string(v))
+		`, cases.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("Validate %s: %v", s.name, err)
+	}
+	s.methods = append(s.methods, fn)
+	return []spec{s}, nil
+}
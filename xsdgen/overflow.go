@@ -0,0 +1,129 @@
+package xsdgen
+
+import (
+	"fmt"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// encoding/xml decodes integers with strconv, and fails only if a
+// value does not fit in the Go type of its field, which for xs:short
+// and others is wider than the schema type. With the IntegerOverflow
+// option, the integer built-ins are given types of their own, whose
+// UnmarshalText methods check values against the range of the schema
+// type.
+
+// An integerRange is the range of values of an integer built-in. The
+// schema range is its value space, limited to 64 bits. The size range
+// is that of a fixed-size integer of the same type: the schema range
+// for xs:short and the other sized types, and the 64-bit range for
+// xs:integer and the types derived from it by bounding one side.
+type integerRange struct {
+	schemaMin, schemaMax string
+	sizeMin, sizeMax     string
+	unsigned             bool
+}
+
+const (
+	minInt64  = "-9223372036854775808"
+	maxInt64  = "9223372036854775807"
+	maxUint64 = "18446744073709551615"
+)
+
+var integerRanges = map[xsd.Builtin]integerRange{
+	xsd.Byte:               {"-128", "127", "-128", "127", false},
+	xsd.Int:                {"-2147483648", "2147483647", "-2147483648", "2147483647", false},
+	xsd.Integer:            {minInt64, maxInt64, minInt64, maxInt64, false},
+	xsd.Long:               {minInt64, maxInt64, minInt64, maxInt64, false},
+	xsd.NegativeInteger:    {minInt64, "-1", minInt64, maxInt64, false},
+	xsd.NonNegativeInteger: {"0", maxInt64, minInt64, maxInt64, false},
+	xsd.NonPositiveInteger: {minInt64, "0", minInt64, maxInt64, false},
+	xsd.PositiveInteger:    {"1", maxInt64, minInt64, maxInt64, false},
+	xsd.Short:              {"-32768", "32767", "-32768", "32767", false},
+	xsd.UnsignedByte:       {"0", "255", "0", "255", true},
+	xsd.UnsignedInt:        {"0", "4294967295", "0", "4294967295", true},
+	xsd.UnsignedLong:       {"0", maxUint64, "0", maxUint64, true},
+	xsd.UnsignedShort:      {"0", "65535", "0", "65535", true},
+}
+
+// overflowType returns the name of the type declared for an integer
+// built-in under the overflow policy, or the empty string.
+func (cfg *Config) overflowType(t xsd.Builtin) string {
+	if _, ok := integerRanges[t]; !ok || cfg.overflowPolicy == 0 {
+		return ""
+	}
+	return "xsd" + gen.PublicName(t.Name().Local)
+}
+
+func (cfg *Config) genOverflowSpec(t xsd.Builtin) ([]spec, error) {
+	cfg.debugf("generating Go source for integer type %q", t.Name().Local)
+	r := integerRanges[t]
+	s := spec{
+		name:    cfg.overflowType(t),
+		expr:    builtinExpr(t),
+		xsdType: t,
+	}
+	min, max := r.schemaMin, r.schemaMax
+	if cfg.overflowPolicy == OverflowSaturate {
+		min, max = r.sizeMin, r.sizeMax
+	}
+	conv, format := "Int64", "strconv.FormatInt(int64(v), 10)"
+	if r.unsigned {
+		conv, format = "Uint64", "strconv.FormatUint(uint64(v), 10)"
+	}
+	unmarshal := gen.Func("UnmarshalText").
+		Receiver("v *" + s.name).
+		Args("text []byte").
+		Returns("error")
+	if cfg.overflowPolicy == OverflowError {
+		unmarshal.Comment(fmt.Sprintf("// UnmarshalText decodes an %s, and fails if the value is outside\n"+
+			"// of the range [%s, %s].", t.Name().Local, min, max)).
+			Body(`
+				n, ok := new(big.Int).SetString(string(bytes.TrimSpace(text)), 10)
+				if !ok {
+					return fmt.Errorf("invalid integer %%q", text)
+				}
+				lo, _ := new(big.Int).SetString(%[1]q, 10)
+				hi, _ := new(big.Int).SetString(%[2]q, 10)
+				if n.Cmp(lo) < 0 || n.Cmp(hi) > 0 {
+					return fmt.Errorf("%[3]s %%s is out of the range [%[1]s, %[2]s]", n)
+				}
+				*v = %[4]s(n.%[5]s())
+				return nil
+			`, min, max, t.Name().Local, s.name, conv)
+	} else {
+		unmarshal.Comment(fmt.Sprintf("// UnmarshalText decodes an %s, replacing a value outside of the\n"+
+			"// range [%s, %s] with the nearest value inside it.", t.Name().Local, min, max)).
+			Body(`
+				n, ok := new(big.Int).SetString(string(bytes.TrimSpace(text)), 10)
+				if !ok {
+					return fmt.Errorf("invalid integer %%q", text)
+				}
+				if lo, _ := new(big.Int).SetString(%q, 10); n.Cmp(lo) < 0 {
+					n = lo
+				}
+				if hi, _ := new(big.Int).SetString(%q, 10); n.Cmp(hi) > 0 {
+					n = hi
+				}
+				*v = %s(n.%s())
+				return nil
+			`, min, max, s.name, conv)
+	}
+	unmarshalFn, err := unmarshal.Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalText %s: %v", s.name, err)
+	}
+	// Types derived from the integer types delegate to these methods
+	// with the EmitTextMarshalers option, so both are declared.
+	marshalFn, err := gen.Func("MarshalText").
+		Receiver("v "+s.name).
+		Returns("[]byte", "error").
+		Body(`return []byte(%s), nil`, format).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalText %s: %v", s.name, err)
+	}
+	s.methods = append(s.methods, marshalFn, unmarshalFn)
+	return []spec{s}, nil
+}
@@ -0,0 +1,165 @@
+package xsdgen
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// foreignPackage returns the import path and name of the Go package
+// that declares the types in the namespace ns, or empty strings if
+// they are declared in the generated source.
+func (cfg *Config) foreignPackage(ns string) (importPath, name string) {
+	pkg, ok := cfg.packages[ns]
+	if !ok {
+		return "", ""
+	}
+	for _, v := range cfg.namespaces {
+		if v == ns {
+			return "", ""
+		}
+	}
+	return pkg, path.Base(pkg)
+}
+
+// addImports adds import declarations for the packages of other
+// namespaces, and of types named in appinfo annotations, that are
+// referred to in file. Imports of the standard library are added
+// later, by the imports package.
+func (cfg *Config) addImports(file *ast.File) {
+	byName := make(map[string]string)
+	for ns := range cfg.packages {
+		if importPath, name := cfg.foreignPackage(ns); importPath != "" {
+			byName[name] = importPath
+		}
+	}
+	for name, importPath := range cfg.appInfoImports {
+		byName[name] = importPath
+	}
+	if len(byName) == 0 {
+		return
+	}
+	used := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			name := strings.TrimLeft(ident.Name, "*[]")
+			if i := strings.Index(name, "."); i > 0 {
+				if importPath, ok := byName[name[:i]]; ok {
+					used[importPath] = name[:i]
+				}
+			}
+		}
+		return true
+	})
+	paths := make([]string, 0, len(used))
+	for p := range used {
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	decl := &ast.GenDecl{Tok: token.IMPORT, Lparen: 1}
+	for _, p := range paths {
+		spec := &ast.ImportSpec{
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(p)},
+		}
+		if name := used[p]; name != path.Base(p) {
+			spec.Name = ast.NewIdent(name)
+		}
+		decl.Specs = append(decl.Specs, spec)
+	}
+	file.Decls = append([]ast.Decl{decl}, file.Decls...)
+}
+
+// Types with the same name in different namespaces would be given
+// the same Go name when they are generated into one package. Types
+// in namespaces set with the Packages option do not collide, since
+// they are qualified with their package name. The others are renamed
+// by prefixing their Go name with a name derived from their namespace,
+// so <Address> in http://example.com/billing becomes BillingAddress.
+func (cfg *Config) resolveNameCollisions(schemas []xsd.Schema, standard map[string]bool) error {
+	cfg.typeNames = nil
+	byIdent := make(map[string][]xml.Name)
+	seen := make(map[xml.Name]bool)
+	for _, s := range schemas {
+		if standard[s.TargetNS] || cfg.skippedNamespaces[s.TargetNS] {
+			continue
+		}
+		if importPath, _ := cfg.foreignPackage(s.TargetNS); importPath != "" {
+			continue
+		}
+		for name := range s.Types {
+			if !seen[name] {
+				seen[name] = true
+				ident := cfg.public(name)
+				byIdent[ident] = append(byIdent[ident], name)
+			}
+		}
+	}
+	idents := make([]string, 0, len(byIdent))
+	for ident, names := range byIdent {
+		if len(names) > 1 {
+			idents = append(idents, ident)
+		}
+	}
+	sort.Strings(idents)
+	for _, ident := range idents {
+		names := byIdent[ident]
+		renamed := make(map[string]string)
+		for _, name := range names {
+			prefix := namespaceIdent(name.Space)
+			if prefix == "" {
+				return fmt.Errorf("type %s is declared in more than one namespace, "+
+					"and no name can be derived from namespace %q", name.Local, name.Space)
+			}
+			goName := prefix + ident
+			if other, ok := renamed[goName]; ok {
+				return fmt.Errorf("type %s in namespaces %s and %s would both be named %s",
+					name.Local, other, name.Space, goName)
+			}
+			if len(byIdent[goName]) > 0 {
+				return fmt.Errorf("type %s in namespace %s would be named %s, which is already used",
+					name.Local, name.Space, goName)
+			}
+			renamed[goName] = name.Space
+			if cfg.typeNames == nil {
+				cfg.typeNames = make(map[xml.Name]string)
+			}
+			cfg.typeNames[name] = goName
+			cfg.logf("type %s in namespace %s is declared in other namespaces; naming it %s",
+				name.Local, name.Space, goName)
+		}
+	}
+	return nil
+}
+
+var versionSegment = regexp.MustCompile(`^[vV]?[0-9][0-9._-]*$`)
+
+// namespaceIdent derives a Go identifier from the last path segment
+// of a namespace URI that is not a version number, such as Billing
+// for http://example.com/billing/v2 or urn:example:billing.
+func namespaceIdent(ns string) string {
+	segments := strings.FieldsFunc(ns, func(r rune) bool {
+		return r == '/' || r == ':' || r == '#' || r == '?'
+	})
+	for i := len(segments) - 1; i >= 0; i-- {
+		seg := strings.TrimSuffix(segments[i], ".xsd")
+		if versionSegment.MatchString(seg) {
+			continue
+		}
+		if ident := gen.PublicName(seg); ident != "" {
+			return ident
+		}
+	}
+	return ""
+}
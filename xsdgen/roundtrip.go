@@ -0,0 +1,268 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// The generated types leave out anything the schema does not declare,
+// and some of what it does, such as ignored attributes. RoundTripOK
+// lets users check, on documents of their own, that nothing they care
+// about is lost: it decodes a document into the type of its root
+// element, encodes it again, and compares the two after removing the
+// differences that do not matter to XML, such as prefixes and the
+// order of attributes.
+
+// addRootTypes records the generated types of the top-level elements
+// of a namespace, which RoundTripOK decodes documents into, and whose
+// root elements Unmarshal checks.
+func (cfg *Config) addRootTypes(ns string, elements map[xml.Name]xsd.Element, decls map[string]spec) {
+	for name, el := range elements {
+		if name.Space != ns || el.Abstract {
+			continue
+		}
+		t, ok := el.Type.(*xsd.ComplexType)
+		if !ok {
+			continue
+		}
+		typ := cfg.typeName(t.Name)
+		if _, ok := decls[typ]; !ok {
+			cfg.debugf("no type is generated for element %s; it is not a root type", name.Local)
+			continue
+		}
+		if cfg.rootTypes == nil {
+			cfg.rootTypes = make(map[xml.Name]string)
+		}
+		cfg.rootTypes[name] = typ
+	}
+}
+
+func (cfg *Config) genRoundTripHelper() ([]ast.Decl, error) {
+	names := make([]xml.Name, 0, len(cfg.rootTypes))
+	for name := range cfg.rootTypes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	var lit bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&lit, "{Space: %q, Local: %q}: func() interface{} { return new(%s) },\n",
+			name.Space, name.Local, cfg.rootTypes[name])
+	}
+	expr, err := parser.ParseExpr(fmt.Sprintf("map[xml.Name]func() interface{}{\n%s}", lit.String()))
+	if err != nil {
+		return nil, fmt.Errorf("round trip types: %v", err)
+	}
+	result := []ast.Decl{&ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("_roundTripTypes")},
+				Values: []ast.Expr{expr},
+			},
+		},
+	}}
+	fns := []*gen.Function{
+		gen.Func("RoundTripOK").
+			Comment("// RoundTripOK decodes the document in data into the type generated\n"+
+				"// for its root element, named root, and encodes the value again. ok\n"+
+				"// reports whether the two documents are the same, ignoring namespace\n"+
+				"// prefixes, the order of attributes, comments, and white space around\n"+
+				"// text. If they are not, diff holds a unified diff of their canonical\n"+
+				"// forms, with the lines of data prefixed by - and those of the encoded\n"+
+				"// value by +.").
+			Args("data []byte", "root xml.Name").
+			Returns("ok bool", "diff []byte", "err error").
+			Body(`
+				newValue, found := _roundTripTypes[root]
+				if !found {
+					return false, nil, fmt.Errorf("no generated type for element %%s", root.Local)
+				}
+				v := newValue()
+				if err := xml.Unmarshal(data, v); err != nil {
+					return false, nil, err
+				}
+				var buf bytes.Buffer
+				e := xml.NewEncoder(&buf)
+				if err := e.EncodeElement(v, xml.StartElement{Name: root}); err != nil {
+					return false, nil, err
+				}
+				if err := e.Flush(); err != nil {
+					return false, nil, err
+				}
+				in, err := _canonicalXML(data)
+				if err != nil {
+					return false, nil, err
+				}
+				out, err := _canonicalXML(buf.Bytes())
+				if err != nil {
+					return false, nil, err
+				}
+				if bytes.Equal(in, out) {
+					return true, nil, nil
+				}
+				return false, _diffLines(in, out), nil
+			`),
+		gen.Func("_canonicalXML").
+			Comment("// _canonicalXML returns the canonical form of the document in data, as\n"+
+				"// returned by xmltree.Canonical from github.com/lajonat/go-xml.").
+			Args("data []byte").
+			Returns("[]byte", "error").
+			Body(`
+				name := func(n xml.Name) string {
+					if n.Space == "" {
+						return n.Local
+					}
+					return "{" + n.Space + "}" + n.Local
+				}
+				var buf, text bytes.Buffer
+				depth := 0
+				flush := func() {
+					if s := strings.TrimSpace(text.String()); s != "" {
+						fmt.Fprintf(&buf, "%%s%%q\n", strings.Repeat("  ", depth), s)
+					}
+					text.Reset()
+				}
+				d := xml.NewDecoder(bytes.NewReader(data))
+				for {
+					tok, err := d.Token()
+					if err == io.EOF {
+						return buf.Bytes(), nil
+					} else if err != nil {
+						return nil, err
+					}
+					switch tok := tok.(type) {
+					case xml.StartElement:
+						flush()
+						var attrs []string
+						for _, attr := range tok.Attr {
+							if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+								continue
+							}
+							attrs = append(attrs, fmt.Sprintf(" %%s=%%q", name(attr.Name), attr.Value))
+						}
+						sort.Strings(attrs)
+						fmt.Fprintf(&buf, "%%s<%%s%%s>\n", strings.Repeat("  ", depth), name(tok.Name), strings.Join(attrs, ""))
+						depth++
+					case xml.EndElement:
+						flush()
+						depth--
+						fmt.Fprintf(&buf, "%%s</%%s>\n", strings.Repeat("  ", depth), name(tok.Name))
+					case xml.CharData:
+						if depth > 0 {
+							text.Write(tok)
+						}
+					}
+				}
+			`),
+		gen.Func("_diffLines").
+			Comment("// _diffLines returns the differences between the lines of a and b as\n"+
+				"// hunks of a unified diff, in the form reported by xmltree.DiffString\n"+
+				"// from github.com/lajonat/go-xml.").
+			Args("a []byte", "b []byte").
+			Returns("[]byte").
+			Body(`
+				const context = 2
+				x := strings.Split(strings.TrimSuffix(string(a), "\n"), "\n")
+				y := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+
+				// lcs[i][j] is the length of the longest common
+				// subsequence of x[i:] and y[j:].
+				lcs := make([][]int, len(x)+1)
+				for i := range lcs {
+					lcs[i] = make([]int, len(y)+1)
+				}
+				for i := len(x) - 1; i >= 0; i-- {
+					for j := len(y) - 1; j >= 0; j-- {
+						if x[i] == y[j] {
+							lcs[i][j] = lcs[i+1][j+1] + 1
+						} else if lcs[i+1][j] >= lcs[i][j+1] {
+							lcs[i][j] = lcs[i+1][j]
+						} else {
+							lcs[i][j] = lcs[i][j+1]
+						}
+					}
+				}
+				type edit struct {
+					op   byte
+					line string
+					i, j int
+				}
+				var edits []edit
+				i, j := 0, 0
+				for i < len(x) || j < len(y) {
+					switch {
+					case i < len(x) && j < len(y) && x[i] == y[j]:
+						edits = append(edits, edit{' ', x[i], i, j})
+						i, j = i+1, j+1
+					case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+						edits = append(edits, edit{'-', x[i], i, j})
+						i++
+					default:
+						edits = append(edits, edit{'+', y[j], i, j})
+						j++
+					}
+				}
+
+				var buf bytes.Buffer
+				for k := 0; k < len(edits); {
+					if edits[k].op == ' ' {
+						k++
+						continue
+					}
+					start := k - context
+					if start < 0 {
+						start = 0
+					}
+					end := k
+					for n := k; n < len(edits) && n <= end+2*context; n++ {
+						if edits[n].op != ' ' {
+							end = n
+						}
+					}
+					end += context + 1
+					if end > len(edits) {
+						end = len(edits)
+					}
+					var countX, countY int
+					for _, e := range edits[start:end] {
+						if e.op != '+' {
+							countX++
+						}
+						if e.op != '-' {
+							countY++
+						}
+					}
+					fmt.Fprintf(&buf, "@@ -%%d,%%d +%%d,%%d @@\n", edits[start].i+1, countX, edits[start].j+1, countY)
+					for _, e := range edits[start:end] {
+						fmt.Fprintf(&buf, "%%c%%s\n", e.op, e.line)
+					}
+					k = end
+				}
+				if buf.Len() == 0 {
+					return nil
+				}
+				return append([]byte("--- a\n+++ b\n"), buf.Bytes()...)
+			`),
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decl)
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// Services that send documents in batches need to know how large a
+// document will be before they marshal it. The EstimatedXMLSize
+// methods generated here add up the sizes of the tags, attributes and
+// values that xml.Marshal would write for a value. The size of each
+// element's tags is known when the code is generated; only the sizes
+// of the values are computed at run time.
+func (cfg *Config) addSizeEstimators(decls map[string]spec) error {
+	for name, s := range decls {
+		str, ok := s.expr.(*ast.StructType)
+		if !ok || hasMethod(s, "EstimatedXMLSize") {
+			continue
+		}
+		fns, err := cfg.genSizeEstimator(s, str, decls)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fns...)
+		decls[name] = s
+	}
+	return nil
+}
+
+// elementTagSize returns the length of the start and end tags that
+// encoding/xml writes for an element, which declare its namespace
+// as the default namespace.
+func elementTagSize(space, local string) int {
+	n := len("<></>") + 2*len(local)
+	if space != "" {
+		n += len(` xmlns=""`) + len(space)
+	}
+	return n
+}
+
+func (cfg *Config) genSizeEstimator(s spec, str *ast.StructType, decls map[string]spec) ([]*ast.FuncDecl, error) {
+	// valueSize returns the expression for the size of
+	// the value of a field, or of an item in a slice.
+	valueSize := func(typ ast.Expr, value string) string {
+		if ident, ok := typ.(*ast.Ident); ok {
+			if t, ok := decls[ident.Name]; ok {
+				if _, ok := t.expr.(*ast.StructType); ok {
+					return value + ".estimatedXMLContentSize()"
+				}
+			}
+		}
+		return "_xmlValueSize(&" + value + ")"
+	}
+	var body bytes.Buffer
+	for _, field := range str.Fields.List {
+		if len(field.Names) == 0 {
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				fmt.Fprintf(&body, "n += %s\n", valueSize(ident, "t."+ident.Name))
+			}
+			continue
+		}
+		space, local, flags, ok := xmlTag(field)
+		if !ok || local == "-" {
+			continue
+		}
+		value := "t." + field.Names[0].Name
+		var tagSize int
+		switch {
+		case hasFlag(flags, "attr"):
+			tagSize = len(` =""`) + len(local)
+		case hasFlag(flags, "comment"):
+			tagSize = len("<!---->")
+		case hasFlag(flags, "chardata"), hasFlag(flags, "innerxml"), hasFlag(flags, "any"):
+		default:
+			tagSize = elementTagSize(space, local)
+		}
+		if slice, ok := field.Type.(*ast.ArrayType); ok && gen.ExprString(slice.Elt) != "byte" {
+			fmt.Fprintf(&body, "for i := range %s {\nn += %d + %s\n}\n",
+				value, tagSize, valueSize(slice.Elt, value+"[i]"))
+		} else {
+			fmt.Fprintf(&body, "n += %d + %s\n", tagSize, valueSize(field.Type, value))
+		}
+	}
+	size, err := gen.Func("EstimatedXMLSize").
+		Comment("// EstimatedXMLSize returns an estimate of the length of the XML\n"+
+			"// encoding of t, as written by xml.Marshal. Characters that are\n"+
+			"// escaped in the encoding are counted once, so the estimate is\n"+
+			"// low for values with many of them.").
+		Receiver("t *"+s.name).
+		Returns("int").
+		Body(`return %d + t.estimatedXMLContentSize()`, elementTagSize("", s.name)).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("EstimatedXMLSize %s: %v", s.name, err)
+	}
+	content, err := gen.Func("estimatedXMLContentSize").
+		Receiver("t *"+s.name).
+		Returns("int").
+		Body(`
+			n := 0
+			%s
+			return n
+		`, body.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("estimatedXMLContentSize %s: %v", s.name, err)
+	}
+	return []*ast.FuncDecl{size, content}, nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// genSizeHelper generates the function that estimates the length of
+// the text of a value that is not a generated struct.
+func (cfg *Config) genSizeHelper() ([]ast.Decl, error) {
+	fn, err := gen.Func("_xmlValueSize").
+		Args("v interface{}").
+		Returns("int").
+		Body(`
+			if m, ok := v.(encoding.TextMarshaler); ok {
+				text, err := m.MarshalText()
+				if err != nil {
+					return 0
+				}
+				return len(text)
+			}
+			rv := reflect.ValueOf(v).Elem()
+			switch rv.Kind() {
+			case reflect.String:
+				return rv.Len()
+			case reflect.Slice:
+				if rv.Type().Elem().Kind() == reflect.Uint8 {
+					return rv.Len()
+				}
+			case reflect.Bool:
+				if rv.Bool() {
+					return len("true")
+				}
+				return len("false")
+			}
+			return len(fmt.Sprint(rv.Interface()))
+		`).
+		Decl()
+	if err != nil {
+		return nil, err
+	}
+	return []ast.Decl{fn}, nil
+}
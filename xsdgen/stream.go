@@ -0,0 +1,176 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// Large documents are usually a long list of records: a <feed> of
+// <entry> elements, an <sdnList> of <sdnEntry> elements. Rather than
+// unmarshal the whole list into a slice, DecodeStream lets users decode
+// one record at a time. The Go type of a record is found by looking for
+// struct fields, in the generated source, that hold elements with
+// the record's name.
+func (cfg *Config) genStreamDecoder(file *ast.File) ([]ast.Decl, error) {
+	records := cfg.streamRecords(file)
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lit bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&lit, "%q: func() interface{} { return new(%s) },\n", name, records[name])
+	}
+	var charset string
+	if cfg.unmarshalHelper {
+		charset = "d.CharsetReader = _charsetReader"
+	}
+	fn, err := gen.Func("DecodeStream").
+		Comment("// DecodeStream reads an XML document from r and decodes each element\n"+
+			"// named recordName into a new value of its generated type, passing a\n"+
+			"// pointer to the value to fn. Elements are decoded one at a time, so\n"+
+			"// the whole document is never held in memory. recordName may be\n"+
+			"// qualified with a namespace URI and a space, as in a struct tag.\n"+
+			"// Namespace prefixes declared on ancestors of a record are resolved\n"+
+			"// as usual. If fn returns an error, DecodeStream stops and returns it.").
+		Args("r io.Reader", "recordName string", "fn func(v interface{}) error").
+		Returns("error").
+		Body(`
+			space, local := "", recordName
+			if i := strings.LastIndex(recordName, " "); i >= 0 {
+				space, local = recordName[:i], recordName[i+1:]
+			}
+			newRecord, ok := _streamRecords[local]
+			if !ok {
+				return fmt.Errorf("no generated type for element %%q", recordName)
+			}
+			d := xml.NewDecoder(r)
+			%s
+			for {
+				tok, err := d.Token()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				start, ok := tok.(xml.StartElement)
+				if !ok || start.Name.Local != local {
+					continue
+				}
+				if space != "" && start.Name.Space != space {
+					continue
+				}
+				v := newRecord()
+				if err := d.DecodeElement(v, &start); err != nil {
+					return err
+				}
+				if err := fn(v); err != nil {
+					return err
+				}
+			}
+		`, charset).
+		Decl()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parser.ParseExpr(fmt.Sprintf("map[string]func() interface{}{\n%s}", lit.String()))
+	if err != nil {
+		return nil, fmt.Errorf("stream records: %v", err)
+	}
+	table := &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("_streamRecords")},
+				Values: []ast.Expr{expr},
+			},
+		},
+	}
+	return []ast.Decl{fn, table}, nil
+}
+
+// streamRecords maps the local names of elements to the
+// generated struct types that hold them. Names that are used for
+// elements of more than one type are left out.
+func (cfg *Config) streamRecords(file *ast.File) map[string]string {
+	structs := make(map[string]*ast.StructType)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			continue
+		}
+		for _, s := range decl.Specs {
+			if s, ok := s.(*ast.TypeSpec); ok {
+				if str, ok := s.Type.(*ast.StructType); ok {
+					structs[s.Name.Name] = str
+				}
+			}
+		}
+	}
+	records := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for _, str := range structs {
+		for _, field := range str.Fields.List {
+			local := elementName(field)
+			if local == "" {
+				continue
+			}
+			typ := field.Type
+			switch t := typ.(type) {
+			case *ast.ArrayType:
+				typ = t.Elt
+			case *ast.StarExpr:
+				typ = t.X
+			}
+			ident, ok := typ.(*ast.Ident)
+			if !ok || structs[ident.Name] == nil {
+				continue
+			}
+			if prev, ok := records[local]; ok && prev != ident.Name {
+				ambiguous[local] = true
+			}
+			records[local] = ident.Name
+		}
+	}
+	for local := range ambiguous {
+		cfg.logf("element %s is used for more than one type; DecodeStream will not decode it", local)
+		delete(records, local)
+	}
+	return records
+}
+
+// elementName returns the local name of the element held by a
+// struct field, or the empty string if the field does not hold
+// an element.
+func elementName(field *ast.Field) string {
+	if field.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(reflect.StructTag(tag).Get("xml"), ",")
+	for _, flag := range parts[1:] {
+		switch flag {
+		case "attr", "chardata", "innerxml", "comment", "any":
+			return ""
+		}
+	}
+	name := strings.Fields(parts[0])
+	if len(name) == 0 || strings.Contains(name[len(name)-1], ">") {
+		return ""
+	}
+	return name[len(name)-1]
+}
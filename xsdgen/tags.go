@@ -0,0 +1,60 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"go/ast"
+	"strconv"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xmltree"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// A FieldInfo describes a struct field generated for an element or
+// attribute of a complexType. It is passed to the functions registered
+// with the ExtraTag option.
+type FieldInfo struct {
+	// The complexType that the field is a part of.
+	Parent *xsd.ComplexType
+	// The name of the element or attribute.
+	Name xml.Name
+	// The name of the struct field in the Go source.
+	FieldName string
+	// The type of the element or attribute.
+	Type xsd.Type
+	// True if the field holds an attribute, rather than an element.
+	Attribute bool
+	// True if the element may appear more than once.
+	Plural bool
+}
+
+type extraTag struct {
+	key string
+	fn  func(FieldInfo) string
+}
+
+// fieldTag builds the struct tag for a field, from its xml tag, the
+// jsonTag directive in its appinfo, and any extra tags configured by
+// the user. Extra tags follow the xml tag, in the order they were
+// added. Empty values are left out. A jsonTag directive takes
+// precedence over an extra json tag.
+func (cfg *Config) fieldTag(xmltag string, info FieldInfo, appinfo []xmltree.Element) *ast.BasicLit {
+	jsonTag := appInfo(appinfo)["jsonTag"]
+	if len(cfg.extraTags) == 0 && jsonTag == "" {
+		return gen.String(xmltag)
+	}
+	buf := bytes.NewBufferString(xmltag)
+	if jsonTag != "" {
+		buf.WriteString(" json:" + strconv.Quote(jsonTag))
+	}
+	for _, tag := range cfg.extraTags {
+		if tag.key == "json" && jsonTag != "" {
+			continue
+		}
+		if v := tag.fn(info); v != "" {
+			buf.WriteString(" " + tag.key + ":" + strconv.Quote(v))
+		}
+	}
+	return gen.String(buf.String())
+}
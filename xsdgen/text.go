@@ -0,0 +1,141 @@
+package xsdgen
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// The encoding/xml package already knows how to marshal the
+// built-in types that simpleTypes are derived from, so it does not
+// need these methods. They let other packages, such as encoding/json
+// (for map keys) or flag, use the same lexical form as the XML
+// document.
+func (cfg *Config) genTextMarshalers(s spec, t *xsd.SimpleType) ([]*ast.FuncDecl, error) {
+	for _, fn := range s.methods {
+		if fn.Name.Name == "MarshalText" || fn.Name.Name == "UnmarshalText" {
+			return nil, nil
+		}
+	}
+	ident, ok := s.expr.(*ast.Ident)
+	if !ok || !textMarshalable(ident) {
+		cfg.debugf("simpleType %s: cannot generate text marshalers for %s",
+			t.Name.Local, gen.ExprString(s.expr))
+		return nil, nil
+	}
+	marshal := gen.Func("MarshalText").
+		Receiver("v "+s.name).
+		Returns("[]byte", "error")
+	unmarshal := gen.Func("UnmarshalText").
+		Receiver("v *" + s.name).
+		Args("text []byte").
+		Returns("error")
+
+	switch base := ident.Name; base {
+	case "string":
+		marshal.Body(`return []byte(v), nil`)
+		unmarshal.Body(`
+			*v = %s(text)
+			return nil
+		`, s.name)
+	case "bool":
+		marshal.Body(`return []byte(strconv.FormatBool(bool(v))), nil`)
+		unmarshal.Body(`
+			switch string(bytes.TrimSpace(text)) {
+			case "true", "1":
+				*v = true
+			case "false", "0":
+				*v = false
+			default:
+				return fmt.Errorf("invalid boolean %%q", text)
+			}
+			return nil
+		`)
+	case "int", "int8", "int16", "int32", "int64":
+		marshal.Body(`return []byte(strconv.FormatInt(int64(v), 10)), nil`)
+		unmarshal.Body(`
+			n, err := strconv.ParseInt(string(bytes.TrimSpace(text)), 10, %d)
+			if err != nil {
+				return err
+			}
+			*v = %s(n)
+			return nil
+		`, intBits(base), s.name)
+	case "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+		marshal.Body(`return []byte(strconv.FormatUint(uint64(v), 10)), nil`)
+		unmarshal.Body(`
+			n, err := strconv.ParseUint(string(bytes.TrimSpace(text)), 10, %d)
+			if err != nil {
+				return err
+			}
+			*v = %s(n)
+			return nil
+		`, intBits(base), s.name)
+	case "float32", "float64":
+		// XML Schema spells infinity INF, not +Inf.
+		marshal.Body(`
+			f := float64(v)
+			switch {
+			case math.IsInf(f, 1):
+				return []byte("INF"), nil
+			case math.IsInf(f, -1):
+				return []byte("-INF"), nil
+			case math.IsNaN(f):
+				return []byte("NaN"), nil
+			}
+			return []byte(strconv.FormatFloat(f, 'g', -1, %d)), nil
+		`, intBits(base))
+		unmarshal.Body(`
+			f, err := strconv.ParseFloat(string(bytes.TrimSpace(text)), %d)
+			if err != nil {
+				return err
+			}
+			*v = %s(f)
+			return nil
+		`, intBits(base), s.name)
+	default:
+		// A generated type, such as xsdDate, with its own methods.
+		marshal.Body(`return (*%s)(&v).MarshalText()`, base)
+		unmarshal.Body(`return (*%s)(v).UnmarshalText(text)`, base)
+	}
+	marshalFn, err := marshal.Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalText %s: %v", s.name, err)
+	}
+	unmarshalFn, err := unmarshal.Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalText %s: %v", s.name, err)
+	}
+	return []*ast.FuncDecl{marshalFn, unmarshalFn}, nil
+}
+
+// textMarshalable reports whether genTextMarshalers can generate
+// methods for a type derived from ident.
+func textMarshalable(ident *ast.Ident) bool {
+	switch ident.Name {
+	case "string", "bool":
+		return true
+	}
+	if exprKind(ident) != "" {
+		return true
+	}
+	// generated types for built-ins, such as xsdDate
+	return !strings.Contains(ident.Name, ".") && !ast.IsExported(ident.Name)
+}
+
+func intBits(name string) int {
+	switch name {
+	case "int8", "uint8", "byte":
+		return 8
+	case "int16", "uint16":
+		return 16
+	case "int32", "uint32", "float32":
+		return 32
+	case "int64", "uint64", "float64":
+		return 64
+	}
+	return 0
+}
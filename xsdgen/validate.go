@@ -0,0 +1,449 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// genSimpleValidator generates a Validate method for a simpleType,
+// which checks a value against the facets of the type's restriction.
+// Facets that do not apply to the Go type of the simpleType are
+// ignored. If the type has no facets to check, no method is generated.
+// Patterns are compiled once, into package-level variables, which are
+// returned along with the method.
+func (cfg *Config) genSimpleValidator(s spec, t *xsd.SimpleType) (*ast.FuncDecl, []ast.Decl, error) {
+	var (
+		body  bytes.Buffer
+		decls []ast.Decl
+		r     = t.Restriction
+		kind  = exprKind(s.expr)
+		text  = "string(v)"
+	)
+	if kind != "string" {
+		text = "fmt.Sprint(v)"
+	}
+	if len(r.Enum) > 0 {
+		if cases := enumCases(kind, r.Enum); cases != "" {
+			fmt.Fprintf(&body, `
+				switch v {
+				case %s:
+				default:
+					return fmt.Errorf("%%s: %%q is not one of the allowed values", %q, %s)
+				}
+			`, cases, s.name, text)
+		}
+	}
+	if kind == "string" {
+		if r.MinLength > 0 {
+			fmt.Fprintf(&body, `
+				if n := utf8.RuneCountInString(string(v)); n < %[1]d {
+					return fmt.Errorf("%%s: length %%d is less than the minimum length %[1]d", %[2]q, n)
+				}
+			`, r.MinLength, s.name)
+		}
+		if r.MaxLength > 0 {
+			fmt.Fprintf(&body, `
+				if n := utf8.RuneCountInString(string(v)); n > %[1]d {
+					return fmt.Errorf("%%s: length %%d exceeds the maximum length %[1]d", %[2]q, n)
+				}
+			`, r.MaxLength, s.name)
+		}
+	}
+	if r.Pattern != nil && kind != "" {
+		pat := r.Pattern.String()
+		varName := "_" + s.name + "Pattern"
+		decls = append(decls, &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names: []*ast.Ident{ast.NewIdent(varName)},
+					Values: []ast.Expr{&ast.CallExpr{
+						Fun:  ast.NewIdent("regexp.MustCompile"),
+						Args: []ast.Expr{gen.String("^(?:" + pat + ")$")},
+					}},
+				},
+			},
+		})
+		fmt.Fprintf(&body, `
+			if !%s.MatchString(%s) {
+				return fmt.Errorf("%%s: %%q does not match the pattern %%s", %q, %s, %q)
+			}
+		`, varName, text, s.name, text, pat)
+	}
+	if body.Len() == 0 {
+		return nil, nil, nil
+	}
+	body.WriteString("return nil")
+	fn, err := gen.Func("Validate").
+		Receiver("v "+s.name).
+		Returns("error").
+		Body("%s", body.String()).
+		Decl()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Validate %s: %v", s.name, err)
+	}
+	return fn, decls, nil
+}
+
+// enumCases formats a list of enumerated values as the expression
+// list of a case clause, or returns the empty string if the values
+// cannot be compared to the Go type.
+func enumCases(kind string, values []string) string {
+	list := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		switch kind {
+		case "string":
+			v = strconv.Quote(v)
+		case "int":
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return ""
+			}
+			v = strconv.FormatInt(n, 10)
+		case "float":
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+				return ""
+			}
+			v = strconv.FormatFloat(f, 'g', -1, 64)
+		default:
+			return ""
+		}
+		// duplicate cases are a compile-time error
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// Complex types are validated by a ValidateAll method, which checks
+// the occurrence constraints of each element, the presence of required
+// elements and attributes, and the facets of simpleType fields, then
+// descends into nested structs and slices. Every violation is reported,
+// with the path to the offending field, so that users processing forms
+// can show all the problems at once. A Validate method returns just
+// the first violation.
+//
+// Whether a required element is present can only be judged from the
+// zero value of its Go type, so the check is limited to string and
+// pointer fields.
+func (cfg *Config) addValidators(decls map[string]spec) error {
+	for name, s := range decls {
+		if !hasValidateAll(s) {
+			continue
+		}
+		fns, err := cfg.genComplexValidator(s, decls)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fns...)
+		decls[name] = s
+	}
+	return nil
+}
+
+// hasValidateAll reports whether addValidators generates a
+// ValidateAll method for a type.
+func hasValidateAll(s spec) bool {
+	_, isComplex := s.xsdType.(*xsd.ComplexType)
+	_, isStruct := s.expr.(*ast.StructType)
+	return isComplex && isStruct
+}
+
+func hasMethod(s spec, name string) bool {
+	for _, fn := range s.methods {
+		if fn.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) genComplexValidator(s spec, decls map[string]spec) ([]*ast.FuncDecl, error) {
+	t := s.xsdType.(*xsd.ComplexType)
+	elements := make(map[string]xsd.Element)
+	for _, el := range t.Elements {
+		if !el.Wildcard {
+			elements[el.Name.Local] = el
+		}
+	}
+	attributes := make(map[string]xsd.Attribute)
+	for _, attr := range t.Attributes {
+		attributes[attr.Name.Local] = attr
+	}
+
+	var body bytes.Buffer
+	for _, field := range s.expr.(*ast.StructType).Fields.List {
+		typ := field.Type
+		var star, slice bool
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ, star = t.X, true
+		case *ast.ArrayType:
+			if !isByteSlice(t) {
+				typ, slice = t.Elt, true
+			}
+		}
+		var fieldType spec
+		if ident, ok := typ.(*ast.Ident); ok {
+			fieldType = decls[ident.Name]
+			if fieldType.name == "" {
+				fieldType = cfg.externalSpec(fieldXSDType(t, field, elements, attributes), ident.Name)
+			}
+		}
+		if len(field.Names) == 0 {
+			if hasValidateAll(fieldType) && !star {
+				fmt.Fprintf(&body, "errs = append(errs, v.%s.ValidateAll()...)\n", fieldType.name)
+			}
+			continue
+		}
+		name := field.Names[0].Name
+
+		// Fields that may be absent, such as the alternatives of a
+		// choice, are only checked when they hold a value.
+		var optional bool
+		if local, attr := fieldXMLName(field); attr {
+			a, ok := attributes[local]
+			optional = ok && !a.Required
+			if ok && a.Prohibited {
+				fmt.Fprintf(&body, `
+					if v.%s != "" {
+						errs = append(errs, &ValidationError{Path: %q, Err: errors.New("prohibited attribute %s is present")})
+					}
+				`, name, name, local)
+			} else if ok && a.Required && gen.ExprString(field.Type) == "string" {
+				fmt.Fprintf(&body, `
+					if v.%s == "" {
+						errs = append(errs, &ValidationError{Path: %q, Err: errors.New("required attribute %s is missing")})
+					}
+				`, name, name, local)
+			}
+		} else if el, ok := elements[local]; ok {
+			optional = el.Choice > 0 || el.MinOccurs == 0
+			switch {
+			case slice:
+				if el.MinOccurs > 0 && el.Choice == 0 {
+					fmt.Fprintf(&body, `
+						if n := len(v.%[1]s); n < %[2]d {
+							errs = append(errs, &ValidationError{Path: %[1]q, Err: fmt.Errorf("element %[3]s appears %%d times, but must appear at least %[2]d times", n)})
+						}
+					`, name, el.MinOccurs, local)
+				}
+				if el.MaxOccurs > 0 {
+					fmt.Fprintf(&body, `
+						if n := len(v.%[1]s); n > %[2]d {
+							errs = append(errs, &ValidationError{Path: %[1]q, Err: fmt.Errorf("element %[3]s appears %%d times, but may appear at most %[2]d times", n)})
+						}
+					`, name, el.MaxOccurs, local)
+				}
+			case el.MinOccurs > 0 && el.Choice == 0 && !el.Nillable && el.Default == "":
+				var zero string
+				if star {
+					zero = "nil"
+				} else if gen.ExprString(field.Type) == "string" {
+					zero = `""`
+				}
+				if zero != "" {
+					fmt.Fprintf(&body, `
+						if v.%s == %s {
+							errs = append(errs, &ValidationError{Path: %q, Err: errors.New("required element %s is missing")})
+						}
+					`, name, zero, name, local)
+				}
+			}
+		}
+
+		var check string
+		switch {
+		case hasValidateAll(fieldType):
+			check = `errs = append(errs, _prefixErrors(%s, x.ValidateAll())...)`
+		case hasMethod(fieldType, "Validate"):
+			check = `
+				if err := x.Validate(); err != nil {
+					errs = append(errs, &ValidationError{Path: %s, Err: err})
+				}`
+		default:
+			continue
+		}
+		switch {
+		case slice:
+			fmt.Fprintf(&body, "for i, x := range v.%s {\n%s\n}\n", name,
+				fmt.Sprintf(check, fmt.Sprintf("fmt.Sprintf(\"%s[%%d]\", i)", name)))
+		case star:
+			fmt.Fprintf(&body, "if x := v.%s; x != nil {\n%s\n}\n", name,
+				fmt.Sprintf(check, strconv.Quote(name)))
+		case optional:
+			fmt.Fprintf(&body, "if x := v.%s; !reflect.ValueOf(x).IsZero() {\n%s\n}\n", name,
+				fmt.Sprintf(check, strconv.Quote(name)))
+		default:
+			fmt.Fprintf(&body, "{\nx := v.%s\n%s\n}\n", name,
+				fmt.Sprintf(check, strconv.Quote(name)))
+		}
+	}
+
+	var fns []*ast.FuncDecl
+	validateAll := gen.Func("ValidateAll").
+		Comment("// ValidateAll checks v, and the values it contains, against the\n" +
+			"// constraints of the schema, and returns all of the violations found.").
+		Receiver("v " + s.name).
+		Returns("[]error")
+	if body.Len() == 0 {
+		validateAll.Body(`return nil`)
+	} else {
+		validateAll.Body(`
+			var errs []error
+			%s
+			return errs
+		`, body.String())
+	}
+	validate := gen.Func("Validate").
+		Comment("// Validate returns the first violation found by ValidateAll, if any.").
+		Receiver("v " + s.name).
+		Returns("error").
+		Body(`
+			if errs := v.ValidateAll(); len(errs) > 0 {
+				return errs[0]
+			}
+			return nil
+		`)
+	for _, fn := range []*gen.Function{validateAll, validate} {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.name, err)
+		}
+		fns = append(fns, decl)
+	}
+	return fns, nil
+}
+
+// fieldXSDType returns the XML Schema type of the value held by a
+// field of the struct type generated for t, or nil if it is not known.
+func fieldXSDType(t *xsd.ComplexType, field *ast.Field, elements map[string]xsd.Element, attributes map[string]xsd.Attribute) xsd.Type {
+	if len(field.Names) == 0 {
+		return t.Base
+	}
+	if local, attr := fieldXMLName(field); attr {
+		return attributes[local].Type
+	} else if el, ok := elements[local]; ok {
+		return el.Type
+	}
+	return nil
+}
+
+// externalSpec returns the spec of a type named name, declared for
+// another namespace than the type whose validator is being generated,
+// and so missing from its decls. Complex types are declared as structs
+// with ValidateAll methods; the spec of a simple type is generated
+// again, to learn whether it has a Validate method.
+func (cfg *Config) externalSpec(t xsd.Type, name string) spec {
+	if t == nil || cfg.typeName(xsd.XMLName(t)) != name {
+		return spec{}
+	}
+	switch t := t.(type) {
+	case *xsd.ComplexType:
+		return spec{
+			name:    name,
+			expr:    &ast.StructType{Fields: &ast.FieldList{}},
+			xsdType: t,
+		}
+	case *xsd.SimpleType:
+		specs, err := cfg.genSimpleType(t)
+		if err != nil {
+			return spec{}
+		}
+		for _, s := range specs {
+			if s.name == name {
+				return s
+			}
+		}
+	}
+	return spec{}
+}
+
+// fieldXMLName returns the local name of the element or attribute
+// held by a struct field, and whether it is an attribute.
+func fieldXMLName(field *ast.Field) (local string, attr bool) {
+	if field.Tag == nil {
+		return "", false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(reflect.StructTag(tag).Get("xml"), ",")
+	for _, flag := range parts[1:] {
+		if flag == "attr" {
+			attr = true
+		}
+	}
+	if name := strings.Fields(parts[0]); len(name) > 0 {
+		local = name[len(name)-1]
+	}
+	return local, attr
+}
+
+// The ValidationError type is declared once per file, along with a
+// helper that adds path elements to the errors of nested values.
+func (cfg *Config) genValidationError() ([]ast.Decl, error) {
+	typ := gen.TypeDecl(ast.NewIdent("ValidationError"), gen.Struct(
+		ast.NewIdent("Path"), ast.NewIdent("string"), nil,
+		ast.NewIdent("Err"), ast.NewIdent("error"), nil,
+	))
+	typ.Doc = &ast.CommentGroup{List: []*ast.Comment{
+		{Text: "// A ValidationError reports a value that violates a constraint of the"},
+		{Text: "// schema. Path locates the value within the value being validated,"},
+		{Text: "// such as Items[2].Sku."},
+	}}
+	result := []ast.Decl{typ}
+	fns := []*gen.Function{
+		gen.Func("Error").
+			Receiver("e *ValidationError").
+			Returns("string").
+			Body(`
+				// The errors of simple types start with the name of
+				// the type, which is often the last element of the
+				// path as well.
+				msg := e.Err.Error()
+				last := e.Path[strings.LastIndex(e.Path, ".")+1:]
+				return e.Path + ": " + strings.TrimPrefix(msg, last+": ")
+			`),
+		gen.Func("_prefixErrors").
+			Args("prefix string", "errs []error").
+			Returns("[]error").
+			Body(`
+				for i, err := range errs {
+					e, ok := err.(*ValidationError)
+					if !ok {
+						errs[i] = &ValidationError{Path: prefix, Err: err}
+						continue
+					}
+					path := prefix + "." + e.Path
+					if strings.HasPrefix(e.Path, "[") {
+						path = prefix + e.Path
+					}
+					errs[i] = &ValidationError{Path: path, Err: e.Err}
+				}
+				return errs
+			`),
+	}
+	for _, fn := range fns {
+		decl, err := fn.Decl()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decl)
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package xsdgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"strings"
+
+	"github.com/lajonat/go-xml/internal/gen"
+)
+
+// Code that redacts, checks or collects values throughout a decoded
+// document would otherwise need a function for every type, or
+// reflection. The Walk methods generated here visit each field of a
+// value, the items of its slices, and the fields of the struct values
+// they hold, in turn.
+
+func (cfg *Config) addVisitors(decls map[string]spec) error {
+	walkable := make(map[string]bool)
+	for name, s := range decls {
+		str, ok := s.expr.(*ast.StructType)
+		if !ok {
+			continue
+		}
+		if hasMethod(s, "Walk") {
+			cfg.logf("%s already has a Walk method; it is not visited by the generated ones", s.name)
+			continue
+		}
+		if hasWalkField(s, str, decls) {
+			cfg.logf("%s has a field named Walk; no Walk method is generated for it", s.name)
+			continue
+		}
+		walkable[name] = true
+	}
+	for name := range walkable {
+		s := decls[name]
+		fns, err := cfg.genVisitor(s, s.expr.(*ast.StructType), decls, walkable)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fns...)
+		decls[name] = s
+	}
+	return nil
+}
+
+func hasWalkField(s spec, str *ast.StructType, decls map[string]spec) bool {
+	for _, f := range marshalFields(s, str, decls, "", 0) {
+		if f.name == "Walk" {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *Config) genVisitor(s spec, str *ast.StructType, decls map[string]spec, walkable map[string]bool) ([]*ast.FuncDecl, error) {
+	// elem returns the type of the items of a slice type, or the
+	// empty string.
+	elem := func(typ string) string {
+		if strings.HasPrefix(typ, "[]") {
+			return typ[len("[]"):]
+		}
+		if d, ok := decls[typ]; ok {
+			if arr, ok := d.expr.(*ast.ArrayType); ok && arr.Len == nil {
+				return gen.ExprString(arr.Elt)
+			}
+		}
+		return ""
+	}
+	var body bytes.Buffer
+	for _, f := range marshalFields(s, str, decls, "t.", 0) {
+		typ := strings.TrimPrefix(f.typ, "*")
+		path := fmt.Sprintf("_walkPath(path, %q)", f.name)
+		fmt.Fprintf(&body, "visit(%s, &%s)\n", path, f.path)
+		if walkable[typ] {
+			fmt.Fprintf(&body, "%s.walk(%s, visit, seen)\n", f.path, path)
+			continue
+		}
+		item := elem(typ)
+		if item == "" {
+			continue
+		}
+		if walkable[item] {
+			fmt.Fprintf(&body, `for i := range %[1]s {
+					%[1]s[i].walk(%[2]s+"["+strconv.Itoa(i)+"]", visit, seen)
+				}
+			`, f.path, path)
+		} else {
+			fmt.Fprintf(&body, `for i := range %[1]s {
+					visit(%[2]s+"["+strconv.Itoa(i)+"]", &%[1]s[i])
+				}
+			`, f.path, path)
+		}
+	}
+	walk, err := gen.Func("Walk").
+		Comment("// Walk calls visit with a pointer to t, and to each of its fields\n" +
+			"// in turn, followed by the items of a field that is a slice and the\n" +
+			"// fields of a struct value. path is the dotted path of field names\n" +
+			"// to the value, with the indexes of slice items, as in Items[2].Sku;\n" +
+			"// it is empty for t. A value is not visited again while visit is\n" +
+			"// called for the values within it.").
+		Receiver("t *" + s.name).
+		Args("visit func(path string, v interface{})").
+		Body(`
+			visit("", t)
+			t.walk("", visit, make(map[interface{}]bool))
+		`).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("Walk %s: %v", s.name, err)
+	}
+	inner, err := gen.Func("walk").
+		Receiver("t *"+s.name).
+		Args("path string", "visit func(string, interface{})", "seen map[interface{}]bool").
+		Body(`
+			if seen[t] {
+				return
+			}
+			seen[t] = true
+			defer delete(seen, t)
+			%s
+		`, body.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %v", s.name, err)
+	}
+	return []*ast.FuncDecl{walk, inner}, nil
+}
+
+// genWalkHelper generates the function that Walk methods use to
+// build the path of a field.
+func (cfg *Config) genWalkHelper() ([]ast.Decl, error) {
+	fn, err := gen.Func("_walkPath").
+		Args("path string", "name string").
+		Returns("string").
+		Body(`
+			if path == "" {
+				return name
+			}
+			return path + "." + name
+		`).
+		Decl()
+	if err != nil {
+		return nil, err
+	}
+	return []ast.Decl{fn}, nil
+}
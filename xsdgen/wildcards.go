@@ -0,0 +1,211 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"sort"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// An <any> element with a namespace of ##targetNamespace matches the
+// top-level elements of the target namespace. If the schema declares
+// a struct type for each of them, the field for the wildcard can hold
+// values of those types instead of raw text. The field is given a
+// slice type whose UnmarshalXML method chooses the type to decode an
+// element into by its name, and the element types are given an
+// XMLElementName method, which MarshalXML uses to name the elements
+// again.
+
+// A substitute is a top-level element that a wildcard can hold,
+// and the name of the generated type for its content.
+type substitute struct {
+	name xml.Name
+	typ  string
+}
+
+func (cfg *Config) addTypedWildcards(decls map[string]spec, elements map[xml.Name]xsd.Element) error {
+	names := make([]string, 0, len(decls))
+	for name := range decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := decls[name]
+		t, ok := s.xsdType.(*xsd.ComplexType)
+		if !ok {
+			continue
+		}
+		field := wildcardField(s, t)
+		if field == nil {
+			continue
+		}
+		iface, slice := s.name+"Item", s.name+"Items"
+		if _, ok := decls[iface]; ok {
+			cfg.logf("complexType %s: type %s already exists; using raw wildcard", t.Name.Local, iface)
+			continue
+		}
+		if _, ok := decls[slice]; ok {
+			cfg.logf("complexType %s: type %s already exists; using raw wildcard", t.Name.Local, slice)
+			continue
+		}
+		subs, err := cfg.substitutes(t.Name.Space, elements, decls)
+		if err != nil {
+			cfg.logf("complexType %s: using raw wildcard: %v", t.Name.Local, err)
+			continue
+		}
+		cfg.debugf("complexType %s: wildcard holds %d elements of namespace %s",
+			t.Name.Local, len(subs), t.Name.Space)
+		methods, err := cfg.genWildcardMethods(slice, iface, subs)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			elem := decls[sub.typ]
+			if hasMethod(elem, "XMLElementName") {
+				continue
+			}
+			fn, err := gen.Func("XMLElementName").
+				Comment(fmt.Sprintf("// XMLElementName returns the name of the element declared with type %s.", sub.typ)).
+				Receiver("*"+sub.typ).
+				Returns("xml.Name").
+				Body(`return xml.Name{Space: %q, Local: %q}`, sub.name.Space, sub.name.Local).
+				Decl()
+			if err != nil {
+				return fmt.Errorf("XMLElementName %s: %v", sub.typ, err)
+			}
+			elem.methods = append(elem.methods, fn)
+			decls[sub.typ] = elem
+		}
+		ifaceExpr, err := gen.FieldList("XMLElementName func() xml.Name")
+		if err != nil {
+			return err
+		}
+		decls[iface] = spec{
+			name: iface,
+			expr: &ast.InterfaceType{Methods: ifaceExpr},
+		}
+		decls[slice] = spec{
+			name:    slice,
+			expr:    &ast.ArrayType{Elt: ast.NewIdent(iface)},
+			methods: methods,
+		}
+		field.Type = ast.NewIdent(slice)
+	}
+	return nil
+}
+
+// wildcardField returns the field of a struct type that holds the
+// elements matched by a wildcard of the target namespace, or nil.
+func wildcardField(s spec, t *xsd.ComplexType) *ast.Field {
+	str, ok := s.expr.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+	found := false
+	for _, el := range t.Elements {
+		if el.Wildcard {
+			found = el.Namespace == "##targetNamespace"
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	for _, field := range str.Fields.List {
+		if _, _, flags, ok := xmlTag(field); ok && hasFlag(flags, "any") {
+			return field
+		}
+	}
+	return nil
+}
+
+// substitutes returns the top-level elements of a namespace, sorted
+// by name. It is an error if one of them does not have a struct type
+// that is not shared with another element, as the type would not
+// say which element to encode a value as.
+func (cfg *Config) substitutes(ns string, elements map[xml.Name]xsd.Element, decls map[string]spec) ([]substitute, error) {
+	var result []substitute
+	owner := make(map[string]xml.Name)
+	for name, el := range elements {
+		if name.Space != ns || el.Abstract {
+			continue
+		}
+		t, ok := el.Type.(*xsd.ComplexType)
+		if !ok {
+			return nil, fmt.Errorf("element %s does not have a complex type", name.Local)
+		}
+		typ := cfg.typeName(t.Name)
+		if s, ok := decls[typ]; !ok {
+			return nil, fmt.Errorf("no type is generated for element %s", name.Local)
+		} else if _, ok := s.expr.(*ast.StructType); !ok {
+			return nil, fmt.Errorf("element %s does not have a struct type", name.Local)
+		}
+		if other, ok := owner[typ]; ok {
+			return nil, fmt.Errorf("elements %s and %s have the same type %s", other.Local, name.Local, typ)
+		}
+		owner[typ] = name
+		result = append(result, substitute{name: name, typ: typ})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("namespace %s has no top-level elements", ns)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name.Local < result[j].name.Local
+	})
+	return result, nil
+}
+
+func (cfg *Config) genWildcardMethods(slice, iface string, subs []substitute) ([]*ast.FuncDecl, error) {
+	var cases bytes.Buffer
+	for _, sub := range subs {
+		fmt.Fprintf(&cases, "case xml.Name{Space: %q, Local: %q}:\nitem = new(%s)\n",
+			sub.name.Space, sub.name.Local, sub.typ)
+	}
+	unmarshal, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML decodes an element into the type declared for it\n"+
+			"// in the schema, and appends it to items. Elements that are not\n"+
+			"// declared in the schema are skipped.").
+		Receiver("items *"+slice).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			var item %s
+			switch start.Name {
+			%s
+			default:
+				return d.Skip()
+			}
+			if err := d.DecodeElement(item, &start); err != nil {
+				return err
+			}
+			*items = append(*items, item)
+			return nil
+		`, iface, cases.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", slice, err)
+	}
+	marshal, err := gen.Func("MarshalXML").
+		Comment("// MarshalXML encodes each of the items as the element that\n"+
+			"// holds its type.").
+		Receiver("items "+slice).
+		Args("e *xml.Encoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			for _, item := range items {
+				if err := e.EncodeElement(item, xml.StartElement{Name: item.XMLElementName()}); err != nil {
+					return err
+				}
+			}
+			return nil
+		`).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalXML %s: %v", slice, err)
+	}
+	return []*ast.FuncDecl{unmarshal, marshal}, nil
+}
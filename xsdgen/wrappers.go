@@ -0,0 +1,212 @@
+package xsdgen
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xsd"
+)
+
+// encoding/xml writes an element for every struct field, even if the
+// field is empty, and its omitempty flag does not apply to structs.
+// A schema that declares a wrapper element with minOccurs="0" may not
+// allow it to be empty, though. The MarshalXML methods generated here
+// encode a struct like encoding/xml would, but leave out optional
+// wrapper elements whose content is all empty.
+
+// A marshalField is a field of the struct that a MarshalXML method
+// encodes in place of the value of its type.
+type marshalField struct {
+	name, typ, tag string
+	// The field's selector, and the expression for its value
+	// in the struct
+	path, value string
+	// Number of embedded structs that the field is promoted through
+	depth int
+	// The field holds an optional element of a struct type
+	wrapper bool
+	// The field holds the attribute attrName
+	attr     bool
+	attrName xml.Name
+}
+
+// addStructMarshalers generates a MarshalXML method for each struct
+// type that encoding/xml would not write as the schema requires:
+// types split by CoreType whose fields are out of schema order, with
+// OmitEmptyWrappers, types with optional wrapper elements, and with
+// SchemaAttributeOrder, types whose attributes are not in declared
+// order. A type that needs more than one of these gets a single
+// method that does all of them.
+func (cfg *Config) addStructMarshalers(decls map[string]spec) error {
+	for name, s := range decls {
+		str, ok := s.expr.(*ast.StructType)
+		if !ok {
+			continue
+		}
+		t, ok := s.xsdType.(*xsd.ComplexType)
+		if !ok {
+			continue
+		}
+		split := cfg.splitStructs[name] != nil
+		if split {
+			str = cfg.splitStructs[name]
+		}
+		fields := marshalFields(s, str, decls, "t.", 0)
+		hasWrapper := false
+		for _, f := range fields {
+			hasWrapper = hasWrapper || f.wrapper && cfg.omitEmptyWrappers
+		}
+		attrOrder := false
+		if cfg.attributeOrder {
+			// Only the order of the attributes is changed, so
+			// the other fields may be ignored.
+			ordered, n := orderAttributes(t, fields), 0
+			for _, f := range fields {
+				if f.attr {
+					attrOrder = attrOrder || f.path != ordered[n].path
+					n++
+				}
+			}
+			if attrOrder {
+				fields = ordered
+			}
+		}
+		if !split && !hasWrapper && !attrOrder {
+			continue
+		}
+		if hasMethod(s, "MarshalXML") {
+			return fmt.Errorf("%s already has a MarshalXML method, which cannot be combined with the one that orders its fields or leaves out its empty wrapper elements", s.name)
+		}
+		fn, err := cfg.genStructMarshal(s, fields, split, hasWrapper, attrOrder)
+		if err != nil {
+			return err
+		}
+		s.methods = append(s.methods, fn)
+		decls[name] = s
+	}
+	return nil
+}
+
+// marshalFields returns the fields of a struct type, replacing the
+// fields of embedded struct types with their own fields, since the
+// methods of the embedded types are not used. As in Go, a field is
+// hidden by a field of the same name that is promoted through fewer
+// embedded structs.
+func marshalFields(s spec, str *ast.StructType, decls map[string]spec, prefix string, depth int) []marshalField {
+	optional := make(map[string]bool)
+	if t, ok := s.xsdType.(*xsd.ComplexType); ok {
+		for _, el := range t.Elements {
+			optional[el.Name.Local] = el.MinOccurs == 0 && !el.Plural && !el.Wildcard
+		}
+	}
+	var result []marshalField
+	for _, field := range str.Fields.List {
+		if len(field.Names) == 0 {
+			ident, ok := field.Type.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if base, ok := decls[ident.Name]; ok {
+				if str, ok := base.expr.(*ast.StructType); ok {
+					result = append(result, marshalFields(base, str, decls, prefix+ident.Name+".", depth+1)...)
+				}
+			}
+			continue
+		}
+		name := field.Names[0].Name
+		f := marshalField{
+			name:  name,
+			typ:   "*" + gen.ExprString(field.Type),
+			path:  prefix + name,
+			value: "&" + prefix + name,
+			depth: depth,
+		}
+		if _, ok := field.Type.(*ast.ArrayType); ok {
+			f.typ, f.value = gen.ExprString(field.Type), prefix+name
+		}
+		if field.Tag != nil {
+			f.tag = field.Tag.Value
+		}
+		if space, local, flags, ok := xmlTag(field); ok && len(flags) == 0 && optional[local] {
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				if t, ok := decls[ident.Name]; ok {
+					_, f.wrapper = t.expr.(*ast.StructType)
+				}
+			}
+		} else if ok && hasFlag(flags, "attr") {
+			f.attr, f.attrName = true, xml.Name{Space: space, Local: local}
+		}
+		result = append(result, f)
+	}
+	if depth > 0 {
+		return result
+	}
+	shallowest := make(map[string]int)
+	for _, f := range result {
+		if d, ok := shallowest[f.name]; !ok || f.depth < d {
+			shallowest[f.name] = f.depth
+		}
+	}
+	visible := result[:0]
+	for _, f := range result {
+		if f.depth == shallowest[f.name] {
+			visible = append(visible, f)
+			shallowest[f.name] = -1
+		}
+	}
+	return visible
+}
+
+// genStructMarshal generates a MarshalXML method that encodes fields
+// in the order given. The fields are pointers into t, so that their
+// methods can be called, except for slices, which are copied. If
+// wrappers is true, optional wrapper elements are left out when their
+// content is empty. ordered and attrs only change the doc comment,
+// saying whether the elements or the attributes were reordered.
+func (cfg *Config) genStructMarshal(s spec, fields []marshalField, ordered, wrappers, attrs bool) (*ast.FuncDecl, error) {
+	var wrapped, decl, values bytes.Buffer
+	for i, f := range fields {
+		fmt.Fprintf(&decl, "%s %s %s\n", f.name, f.typ, f.tag)
+		if !f.wrapper || !wrappers {
+			fmt.Fprintf(&values, "%s,\n", f.value)
+			continue
+		}
+		fmt.Fprintf(&wrapped, `
+			var wrapper%[1]d %[2]s
+			if !reflect.ValueOf(%[3]s).IsZero() {
+				wrapper%[1]d = &%[3]s
+			}`, i, f.typ, f.path)
+		fmt.Fprintf(&values, "wrapper%d,\n", i)
+	}
+	comment := "// MarshalXML encodes t as the schema requires."
+	if ordered {
+		comment += "\n// The fields of t are written in the order of the schema."
+	}
+	if attrs {
+		comment += "\n// Attributes are written in the order they are declared."
+	}
+	if wrappers {
+		comment += "\n// Optional elements whose content is empty are left out."
+	}
+	fn, err := gen.Func("MarshalXML").
+		Comment(comment).
+		Receiver("t "+s.name).
+		Args("e *xml.Encoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			%s
+			return e.EncodeElement(struct {
+				%s
+			}{
+				%s
+			}, start)
+		`, wrapped.String(), decl.String(), values.String()).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalXML %s: %v", s.name, err)
+	}
+	return fn, nil
+}
@@ -3,12 +3,16 @@ package xsdgen // import "github.com/lajonat/go-xml/xsdgen"
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/token"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
 	"sort"
 	"strings"
 
@@ -54,15 +58,44 @@ func lookupTargetNS(data ...[]byte) []string {
 	return result
 }
 
-func mergeASTFile(dst, src *ast.File) *ast.File {
+func mergeASTFile(dst, src *ast.File) (*ast.File, error) {
 	if dst == nil {
-		return src
+		return src, nil
 	}
 	if dst.Doc != nil {
 		dst.Doc = src.Doc
 	}
-	dst.Decls = append(dst.Decls, src.Decls...)
-	return dst
+	// Types for built-ins, such as xsdDate, and their methods are
+	// generated once for every namespace that uses them.
+	have := make(map[string]ast.Decl)
+	for _, decl := range dst.Decls {
+		for _, name := range declNames(decl) {
+			have[name] = decl
+		}
+	}
+	for _, decl := range src.Decls {
+		names := declNames(decl)
+		if len(names) > 0 && have[names[0]] != nil {
+			if !sameDecl(have[names[0]], decl) {
+				return nil, fmt.Errorf("%s is declared differently for more than one namespace", names[0])
+			}
+			continue
+		}
+		dst.Decls = append(dst.Decls, decl)
+	}
+	return dst, nil
+}
+
+func sameDecl(a, b ast.Decl) bool {
+	var bufA, bufB bytes.Buffer
+	fset := token.NewFileSet()
+	if err := format.Node(&bufA, fset, a); err != nil {
+		return false
+	}
+	if err := format.Node(&bufB, fset, b); err != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
 }
 
 func (cfg *Config) resolveDependencies(data ...[]byte) ([][]byte, error) {
@@ -106,16 +139,25 @@ func (cfg *Config) resolveDependencies1(ref xsd.Ref, have xsdSet, depth int) ([]
 	}
 
 	if ref.Location == "" {
-		return nil, fmt.Errorf("do not know where to find schema for %s", ref.Namespace)
+		// The schema for this namespace may be among the ones
+		// we are given; xsd.Parse will complain if it is not.
+		cfg.debugf("no schemaLocation for imported namespace %s", ref.Namespace)
+		return nil, nil
 	}
 	rsp, err := http.Get(ref.Location)
 	if err != nil {
 		return nil, err
 	}
 	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
 	if err != nil {
 		return nil, err
 	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", ref.Location, rsp.Status)
+	}
+	cfg.debugf("fetched schema for namespace %q from %s", ref.Namespace, ref.Location)
+	result = append(result, body)
 
 	refs, err := xsd.Imports(body)
 	if err != nil {
@@ -127,9 +169,12 @@ func (cfg *Config) resolveDependencies1(ref xsd.Ref, have xsdSet, depth int) ([]
 	}
 
 	for _, r := range refs {
-		if have[ref.Namespace] {
+		if have[r.Namespace] {
 			continue
 		}
+		if r.Location, err = resolveLocation(ref.Location, r.Location); err != nil {
+			return nil, err
+		}
 		d, err := cfg.resolveDependencies1(r, have, depth+1)
 		if err != nil {
 			return nil, err
@@ -139,11 +184,66 @@ func (cfg *Config) resolveDependencies1(ref xsd.Ref, have xsdSet, depth int) ([]
 	return result, nil
 }
 
+// resolveLocation resolves a schema location that may be relative
+// to the location of the document that refers to it.
+func resolveLocation(base, location string) (string, error) {
+	if base == "" || location == "" {
+		return location, nil
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	l, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(l).String(), nil
+}
+
+// FetchInstanceSchemas fetches the schema that an XML instance
+// document refers to in its xsi:schemaLocation and
+// xsi:noNamespaceSchemaLocation attributes, along with the schema
+// that they import, and parses them. Relative locations in doc are
+// resolved against baseURL, the location of the document, if it is
+// not empty. The result may be passed to GenFromSchema.
+func (cfg *Config) FetchInstanceSchemas(doc []byte, baseURL string) ([]*xsd.Schema, error) {
+	refs, err := xsd.SchemaLocations(doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, errors.New("document has no schema location hints")
+	}
+	var data [][]byte
+	have := make(xsdSet)
+	for _, ref := range refs {
+		if ref.Location, err = resolveLocation(baseURL, ref.Location); err != nil {
+			return nil, err
+		}
+		d, err := cfg.resolveDependencies1(ref, have, 1)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d...)
+	}
+	deps, err := xsd.Parse(data...)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]*xsd.Schema, 0, len(deps))
+	for i := range deps {
+		schemas = append(schemas, &deps[i])
+	}
+	return schemas, nil
+}
+
 func (cfg *Config) genAST(schema xsd.Schema, extra ...xsd.Schema) (*ast.File, error) {
 	var errList errorList
 	decls := make(map[string]spec)
 
 	cfg.addStandardHelpers()
+	cfg.collectNotations(append([]xsd.Schema{schema}, extra...)...)
 	collect := make(map[xml.Name]xsd.Type)
 	for k, v := range schema.Types {
 		collect[k] = v
@@ -153,6 +253,15 @@ func (cfg *Config) genAST(schema xsd.Schema, extra ...xsd.Schema) (*ast.File, er
 			collect[k] = v
 		}
 	}
+	elements := make(map[xml.Name]xsd.Element)
+	for k, v := range schema.Elements {
+		elements[k] = v
+	}
+	for _, schema := range extra {
+		for k, v := range schema.Elements {
+			elements[k] = v
+		}
+	}
 	prev := schema.Types
 	schema.Types = collect
 	if cfg.preprocessType != nil {
@@ -167,8 +276,36 @@ func (cfg *Config) genAST(schema xsd.Schema, extra ...xsd.Schema) (*ast.File, er
 
 	cfg.debugf("generating Go source for schema %q", schema.TargetNS)
 	typeList := cfg.flatten(schema.Types)
+	cfg.extendedTypes = make(map[*xsd.ComplexType]bool)
+	cfg.splitStructs = make(map[string]*ast.StructType)
+	for _, t := range typeList {
+		if c, ok := t.(*xsd.ComplexType); ok && c.Extends {
+			if base, ok := c.Base.(*xsd.ComplexType); ok {
+				cfg.extendedTypes[base] = true
+			}
+		}
+	}
 
 	for _, t := range typeList {
+		cfg.logAppInfo(t)
+		if path, _ := cfg.foreignPackage(xsd.XMLName(t).Space); path != "" {
+			cfg.debugf("type %s is declared in package %s", xsd.XMLName(t).Local, path)
+			continue
+		}
+		if cfg.skippedNamespaces[xsd.XMLName(t).Space] {
+			cfg.debugf("type %s is in skipped namespace %s", xsd.XMLName(t).Local, xsd.XMLName(t).Space)
+			continue
+		}
+		if t, ok := t.(*xsd.SimpleType); ok && goType(t) != "" {
+			cfg.debugf("simpleType %s is replaced by %s in its appinfo", t.Name.Local, goType(t))
+			if name, importPath := appInfoImport(t); importPath != "" {
+				if cfg.appInfoImports == nil {
+					cfg.appInfoImports = make(map[string]string)
+				}
+				cfg.appInfoImports[name] = importPath
+			}
+			continue
+		}
 		specs, err := cfg.genTypeSpec(t)
 		if err != nil {
 			errList = append(errList, fmt.Errorf("generate type %q: %v", xsd.XMLName(t).Local, err))
@@ -184,6 +321,79 @@ func (cfg *Config) genAST(schema xsd.Schema, extra ...xsd.Schema) (*ast.File, er
 			decls[name] = cfg.postprocessType(s)
 		}
 	}
+	if cfg.typedWildcards {
+		if err := cfg.addTypedWildcards(decls, elements); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitValidators {
+		if err := cfg.addValidators(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	// The lenient decoders are wrapped by the validating ones, so
+	// that those read the document itself, and know where each
+	// element is.
+	if cfg.lenientNamespaces {
+		if err := cfg.addLenientDecoders(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.validateOnDecode {
+		if err := cfg.addDecodeValidators(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitJSON {
+		if err := cfg.addJSONMarshalers(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.omitEmptyWrappers || cfg.attributeOrder || len(cfg.splitStructs) > 0 {
+		if err := cfg.addStructMarshalers(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitFieldAccessors {
+		if err := cfg.addFieldAccessors(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitFieldMarshalers {
+		if err := cfg.addFieldMarshalers(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitSize {
+		if err := cfg.addSizeEstimators(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.nonNilSlices {
+		if err := cfg.addConstructors(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitBuilders {
+		if err := cfg.addBuilders(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.emitVisitor {
+		if err := cfg.addVisitors(decls); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if cfg.roundTripTest || cfg.unmarshalHelper {
+		cfg.addRootTypes(schema.TargetNS, elements, decls)
+	}
+	if cfg.elementNames {
+		for name := range schema.Elements {
+			if name.Space == schema.TargetNS {
+				cfg.globalElements = append(cfg.globalElements, name)
+			}
+		}
+	}
 
 	if len(errList) > 0 {
 		return nil, errList
@@ -209,42 +419,99 @@ func (cfg *Config) genAST(schema xsd.Schema, extra ...xsd.Schema) (*ast.File, er
 		for _, f := range info.methods {
 			result = append(result, f)
 		}
+		for _, d := range info.decls {
+			if d = cfg.dropConflicts(d, decls); d != nil {
+				result = append(result, d)
+			}
+		}
 	}
-	if cfg.pkgname == "" {
-		cfg.pkgname = "ws"
+	pkgname := cfg.pkgname
+	if pkg, ok := cfg.packages[schema.TargetNS]; ok {
+		pkgname = path.Base(pkg)
+	}
+	if pkgname == "" {
+		pkgname = "ws"
 	}
 	file := &ast.File{
 		Decls: result,
-		Name:  ast.NewIdent(cfg.pkgname),
+		Name:  ast.NewIdent(pkgname),
 		Doc:   nil,
 	}
 	return file, nil
 }
 
+// dropConflicts removes the names declared by a const or var
+// declaration that are also the names of generated types.
+func (cfg *Config) dropConflicts(d ast.Decl, types map[string]spec) ast.Decl {
+	decl, ok := d.(*ast.GenDecl)
+	if !ok || (decl.Tok != token.CONST && decl.Tok != token.VAR) {
+		return d
+	}
+	var specs []ast.Spec
+	for _, s := range decl.Specs {
+		v := s.(*ast.ValueSpec)
+		if _, ok := types[v.Names[0].Name]; ok {
+			cfg.logf("%s %s conflicts with a type of the same name; omitting it",
+				decl.Tok, v.Names[0].Name)
+			continue
+		}
+		specs = append(specs, s)
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	decl.Specs = specs
+	if len(specs) == 1 {
+		decl.Lparen = 0
+	}
+	return decl
+}
+
 type spec struct {
 	name    string
 	expr    ast.Expr
 	private bool
 	methods []*ast.FuncDecl
+	// Other declarations, such as constants, that belong
+	// with the type.
+	decls   []ast.Decl
 	xsdType xsd.Type
 }
 
 // Flatten out our tree of dependent types. If a type is marked as
 // private by a user filter and not used as a struct field or embedded
-// struct, it is ommitted from the output.
+// struct, it is ommitted from the output. The fields of complex types
+// are flattened from a worklist, rather than by recursing into the
+// types of the fields, so that types that contain themselves, or are
+// nested very deeply, do not overflow the stack.
 func (cfg *Config) flatten(types map[xml.Name]xsd.Type) []xsd.Type {
-	var result []xsd.Type
+	var (
+		result  []xsd.Type
+		queue   []*xsd.ComplexType
+		visited = make(map[*xsd.ComplexType]bool)
+	)
 	push := func(t xsd.Type) {
 		result = append(result, t)
 	}
+	visit := func(t xsd.Type) xsd.Type {
+		t = cfg.flatten1(t, push)
+		if c, ok := t.(*xsd.ComplexType); ok && !visited[c] {
+			visited[c] = true
+			queue = append(queue, c)
+		}
+		return t
+	}
 	for _, t := range types {
 		if cfg.filterTypes != nil && cfg.filterTypes(t) {
 			continue
 		}
-		if t := cfg.flatten1(t, push); t != nil {
+		if t := visit(t); t != nil {
 			result = append(result, t)
 		}
 	}
+	for i := 0; i < len(queue); i++ {
+		cfg.flattenFields(queue[i], visit, push)
+	}
 	// Remove duplicates
 	seen := make(map[xml.Name]bool)
 	var a []xsd.Type
@@ -265,6 +532,10 @@ func (cfg *Config) flatten(types map[xml.Name]xsd.Type) []xsd.Type {
 func (cfg *Config) flatten1(t xsd.Type, push func(xsd.Type)) xsd.Type {
 	switch t := t.(type) {
 	case *xsd.SimpleType:
+		if goType(t) != "" {
+			// Replaced by a Go type; nothing is generated for it
+			return t
+		}
 		var (
 			chain         []xsd.Type
 			base, builtin xsd.Type
@@ -284,8 +555,22 @@ func (cfg *Config) flatten1(t xsd.Type, push func(xsd.Type)) xsd.Type {
 			}
 		}
 		t.Base = builtin
+		if builtin != nil {
+			// the built-in may need a declaration of its own,
+			// such as xsdDate.
+			cfg.flatten1(builtin, push)
+		}
 		return t
 	case *xsd.ComplexType:
+		// A base type in a skipped namespace is not declared, so
+		// its content cannot be kept.
+		if t.Base != nil {
+			if base := cfg.skipType(t.Base); base != t.Base {
+				cfg.logf("complexType %s: base type %s is in skipped namespace %s; deriving it from %s instead",
+					t.Name.Local, xsd.XMLName(t.Base).Local, xsd.XMLName(t.Base).Space, xsd.XMLName(base).Local)
+				t.Base = base
+			}
+		}
 		// We can "unpack" a struct if it is extending a simple
 		// or built-in type and we are ignoring all of its attributes.
 		switch t.Base.(type) {
@@ -305,26 +590,6 @@ func (cfg *Config) flatten1(t xsd.Type, push func(xsd.Type)) xsd.Type {
 				}
 			}
 		}
-		// We can flatten a struct field if its type does not
-		// need additional methods for unmarshalling.
-		for i, el := range t.Elements {
-			el.Type = cfg.flatten1(el.Type, push)
-			if b, ok := el.Type.(*xsd.SimpleType); ok {
-				if !b.List && len(b.Union) == 0 {
-					el.Type = xsd.Base(el.Type)
-				}
-			}
-			t.Elements[i] = el
-		}
-		for i, attr := range t.Attributes {
-			attr.Type = cfg.flatten1(attr.Type, push)
-			if b, ok := attr.Type.(*xsd.SimpleType); ok {
-				if !b.List && len(b.Union) == 0 {
-					attr.Type = xsd.Base(attr.Type)
-				}
-			}
-			t.Attributes[i] = attr
-		}
 		return t
 	case xsd.Builtin:
 		// There are a few built-ins that do not map directly to Go types.
@@ -338,12 +603,68 @@ func (cfg *Config) flatten1(t xsd.Type, push func(xsd.Type)) xsd.Type {
 			push(t)
 		case xsd.GDay, xsd.GMonth, xsd.GMonthDay, xsd.GYear, xsd.GYearMonth:
 			push(t)
+		case xsd.Duration:
+			if cfg.durationType {
+				push(t)
+			}
+		default:
+			if cfg.overflowType(t) != "" || cfg.notationType(t) != "" {
+				push(t)
+			}
 		}
 		return t
 	}
 	panic(fmt.Sprintf("unexpected %T", t))
 }
 
+// flattenFields flattens the types of the elements and attributes of
+// a complex type with visit.
+func (cfg *Config) flattenFields(t *xsd.ComplexType, visit func(xsd.Type) xsd.Type, push func(xsd.Type)) {
+	// We can flatten a struct field if its type does not
+	// need additional methods for unmarshalling.
+	for i, el := range t.Elements {
+		el.Type = visit(cfg.skipType(el.Type))
+		if b, ok := el.Type.(xsd.Builtin); ok && b == xsd.AnyType && !el.Wildcard {
+			// Elements of anyType keep their content
+			// in an xsdAnyType.
+			push(b)
+		}
+		if b, ok := el.Type.(*xsd.SimpleType); ok {
+			if !b.List && len(b.Union) == 0 && !cfg.hasMethods(b) && goType(b) == "" {
+				el.Type = xsd.Base(el.Type)
+			}
+		}
+		t.Elements[i] = el
+	}
+	for i, attr := range t.Attributes {
+		attr.Type = visit(cfg.skipType(attr.Type))
+		if b, ok := attr.Type.(*xsd.SimpleType); ok {
+			if !b.List && len(b.Union) == 0 && !cfg.hasMethods(b) && goType(b) == "" {
+				attr.Type = xsd.Base(attr.Type)
+			}
+		}
+		t.Attributes[i] = attr
+	}
+}
+
+// skipType returns the type to use in place of a type of a namespace
+// set with the SkipNamespace option: anyType for a complex type, or
+// the built-in type that a simple type is derived from.
+func (cfg *Config) skipType(t xsd.Type) xsd.Type {
+	if _, ok := t.(xsd.Builtin); ok || !cfg.skippedNamespaces[xsd.XMLName(t).Space] {
+		return t
+	}
+	if _, ok := t.(*xsd.ComplexType); ok {
+		return xsd.AnyType
+	}
+	for base := xsd.Base(t); base != nil; base = xsd.Base(base) {
+		if b, ok := base.(xsd.Builtin); ok {
+			return b
+		}
+	}
+	return xsd.AnyType
+}
+
 func (cfg *Config) genTypeSpec(t xsd.Type) (result []spec, err error) {
 	var s []spec
 	cfg.debugf("generating type spec for %q", xsd.XMLName(t).Local)
@@ -363,6 +684,18 @@ func (cfg *Config) genTypeSpec(t xsd.Type) (result []spec, err error) {
 			s, err = cfg.genBinarySpec(t)
 		case xsd.ENTITIES, xsd.IDREFS, xsd.NMTOKENS:
 			s, err = cfg.genTokenListSpec(t)
+		case xsd.AnyType:
+			s, err = cfg.genAnyTypeSpec(t)
+		case xsd.Duration:
+			if cfg.durationType {
+				s, err = cfg.genDurationSpec(t)
+			}
+		default:
+			if cfg.overflowType(t) != "" {
+				s, err = cfg.genOverflowSpec(t)
+			} else if cfg.notationType(t) != "" {
+				s, err = cfg.genNotationSpec(t)
+			}
 		}
 	default:
 		cfg.logf("unexpected %T %s", t, xsd.XMLName(t).Local)
@@ -373,6 +706,44 @@ func (cfg *Config) genTypeSpec(t xsd.Type) (result []spec, err error) {
 	return append(result, s...), nil
 }
 
+// simpleContent returns the type of the character data of a complexType
+// with simple content, or nil if the type has complex content.
+func simpleContent(t *xsd.ComplexType) xsd.Type {
+	for t != nil {
+		switch b := t.Base.(type) {
+		case *xsd.SimpleType:
+			return b
+		case xsd.Builtin:
+			if b == xsd.AnyType {
+				return nil
+			}
+			return b
+		case *xsd.ComplexType:
+			t = b
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// chardataName returns the name of the struct field that holds the
+// character data of a complexType with simple content, whose Go type
+// is base. Content of an enumerated simpleType is named Value, so that
+// it does not repeat the name of its type; any other content is named
+// after its type.
+func chardataName(content xsd.Type, base ast.Expr) ast.Expr {
+	switch t := content.(type) {
+	case xsd.Builtin:
+		return ast.NewIdent(t.String())
+	case *xsd.SimpleType:
+		if len(t.Restriction.Enum) > 0 {
+			return ast.NewIdent("Value")
+		}
+	}
+	return base
+}
+
 func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 	var result []spec
 	var fields []ast.Expr
@@ -385,9 +756,10 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 		}
 		switch b := t.Base.(type) {
 		case *xsd.SimpleType:
-			cfg.debugf("complexType %[1]s extends simpleType %[2]s. Naming"+
-				" the chardata struct field after %[2]s", t.Name.Local, b.Name.Local)
-			fields = append(fields, base, base, gen.String(`xml:",chardata"`))
+			cfg.debugf("complexType %s extends simpleType %s. Naming"+
+				" the chardata struct field %s", t.Name.Local, b.Name.Local,
+				gen.ExprString(chardataName(b, base)))
+			fields = append(fields, chardataName(b, base), base, gen.String(`xml:",chardata"`))
 		case xsd.Builtin:
 			if b == xsd.AnyType {
 				// extending anyType doesn't really make sense, but
@@ -399,7 +771,7 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 			// Name the field after the xsd type name.
 			cfg.debugf("complexType %[1]s extends %[2]s, naming chardata struct field %[2]s",
 				t.Name.Local, b)
-			fields = append(fields, ast.NewIdent(b.String()), base, gen.String(`xml:",chardata"`))
+			fields = append(fields, chardataName(b, base), base, gen.String(`xml:",chardata"`))
 		case *xsd.ComplexType:
 			// Use struct embedding when extending a complex type
 			cfg.debugf("complexType %s extends %s, embedding struct",
@@ -413,6 +785,18 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 		switch b := t.Base.(type) {
 		case *xsd.ComplexType:
 			t.Attributes = mergeAttributes(t, b)
+			// Restricting a type with simple content keeps
+			// its character data.
+			if content := simpleContent(b); content != nil {
+				base, err := cfg.expr(content)
+				if err != nil {
+					return nil, fmt.Errorf("%s base type %s: %v",
+						t.Name.Local, xsd.XMLName(content).Local, err)
+				}
+				cfg.debugf("complexType %s restricts %s, keeping its chardata",
+					t.Name.Local, b.Name.Local)
+				fields = append(fields, chardataName(content, base), base, gen.String(`xml:",chardata"`))
+			}
 			hasWildcard := false
 			for _, el := range t.Elements {
 				if el.Wildcard {
@@ -432,27 +816,57 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 		}
 	}
 
+	groups, unmarshalGroups, err := cfg.genGroups(t)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, groups...)
+
 	attributes, elements := cfg.filterFields(t)
 	cfg.debugf("complexType %s: generating struct fields for %d elements and %d attributes",
 		xsd.XMLName(t).Local, len(elements), len(attributes))
-	hasDefault := false
 	for _, attr := range attributes {
-		hasDefault = hasDefault || (attr.Default != "")
 		tag := fmt.Sprintf(`xml:"%s,attr"`, attr.Name.Local)
 		base, err := cfg.expr(attr.Type)
 		if err != nil {
 			return nil, fmt.Errorf("%s attribute %s: %v", t.Name.Local, attr.Name.Local, err)
 		}
-		fields = append(fields, ast.NewIdent(cfg.public(attr.Name)), base, gen.String(tag))
+		if attr.Prohibited {
+			// Kept only so that ValidateAll can report it.
+			tag = fmt.Sprintf(`xml:"%s,attr,omitempty"`, attr.Name.Local)
+			base = ast.NewIdent("string")
+		}
+		name := cfg.fieldName(t, attr.Name)
+		fields = append(fields, ast.NewIdent(name), base, cfg.fieldTag(tag, FieldInfo{
+			Parent:    t,
+			Name:      attr.Name,
+			FieldName: name,
+			Type:      attr.Type,
+			Attribute: true,
+		}, attr.AppInfo))
 	}
 	for _, el := range elements {
-		hasDefault = hasDefault || (el.Default != "")
 		tag := fmt.Sprintf(`xml:"%s %s"`, el.Name.Space, el.Name.Local)
 		base, err := cfg.expr(el.Type)
 		if err != nil {
 			return nil, fmt.Errorf("%s element %s: %v", t.Name.Local, el.Name.Local, err)
 		}
-		name := ast.NewIdent(cfg.public(el.Name))
+		name := ast.NewIdent(cfg.fieldName(t, el.Name))
+		if b, ok := el.Type.(xsd.Builtin); ok && b == xsd.AnyType && !el.Wildcard {
+			base = ast.NewIdent("xsdAnyType")
+		}
+		if el.MinOccurs == 0 && !el.Plural && !el.Wildcard && cfg.isEmptyType(el.Type) {
+			if cfg.emptyElementsAsBool {
+				flag, err := cfg.genFlagSpec()
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, flag)
+				base = ast.NewIdent(flag.name)
+			} else {
+				base = ast.NewIdent("*struct{}")
+			}
+		}
 		if el.Wildcard {
 			tag = `xml:",any"`
 			if el.Plural {
@@ -465,10 +879,19 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 				base = builtinExpr(xsd.String)
 			}
 		}
+		if g, ok := el.Type.(*xsd.ComplexType); ok && cfg.groupTypes[g] {
+			tag = `xml:",any"`
+		}
 		if el.Plural {
 			base = &ast.ArrayType{Elt: base}
 		}
-		fields = append(fields, name, base, gen.String(tag))
+		fields = append(fields, name, base, cfg.fieldTag(tag, FieldInfo{
+			Parent:    t,
+			Name:      el.Name,
+			FieldName: name.Name,
+			Type:      el.Type,
+			Plural:    el.Plural,
+		}, el.AppInfo))
 	}
 	expr := gen.Struct(fields...)
 	s := spec{
@@ -476,27 +899,126 @@ func (cfg *Config) genComplexType(t *xsd.ComplexType) ([]spec, error) {
 		expr:    expr,
 		xsdType: t,
 	}
-	if hasDefault {
-		unmarshal, marshal, err := cfg.genMarshalComplexType(t)
+	unmarshal, err := cfg.genUnmarshalDefaults(s, t)
+	if err != nil {
+		return nil, err
+	}
+	if unmarshal != nil {
+		s.methods = append(s.methods, unmarshal)
+	}
+	if unmarshalGroups != nil {
+		s.methods = append(s.methods, unmarshalGroups)
+	}
+	if cfg.coreTypes[t.Name] {
+		specs, err := cfg.splitCoreType(s, t, attributes, elements)
 		if err != nil {
-			//NOTE(droyo) may want to log this instead of stopping the generator
-			return result, err
-		} else {
-			if unmarshal != nil {
-				s.methods = append(s.methods, unmarshal)
-			}
-			if marshal != nil {
-				s.methods = append(s.methods, marshal)
-			}
+			return nil, err
 		}
+		return append(result, specs...), nil
 	}
 	result = append(result, s)
 	return result, nil
 }
 
-func (cfg *Config) genMarshalComplexType(t *xsd.ComplexType) (marshal, unmarshal *ast.FuncDecl, err error) {
-	// TODO(droyo): this one is a lot of work
-	return nil, nil, nil
+// defaultAttributes returns the attributes of a complexType that
+// have a default value, including those of the complexTypes it
+// extends, whose struct types are embedded in its own.
+func (cfg *Config) defaultAttributes(t *xsd.ComplexType) []xsd.Attribute {
+	var result []xsd.Attribute
+	if base, ok := t.Base.(*xsd.ComplexType); ok && t.Extends {
+		result = cfg.defaultAttributes(base)
+	}
+	attributes, _ := cfg.filterFields(t)
+	for _, attr := range attributes {
+		if attr.Default != "" && !attr.Prohibited {
+			result = append(result, attr)
+		}
+	}
+	return result
+}
+
+// The default value of an attribute applies when its element is
+// present but the attribute is not. The generated UnmarshalXML method
+// adds the missing attributes to the start element before decoding it,
+// so the default is decoded as if it appeared in the document. An
+// element that is absent is never decoded, so it gets no defaults.
+func (cfg *Config) genUnmarshalDefaults(s spec, t *xsd.ComplexType) (*ast.FuncDecl, error) {
+	attributes := cfg.defaultAttributes(t)
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	var defaults bytes.Buffer
+	for _, attr := range attributes {
+		fmt.Fprintf(&defaults, "{Name: xml.Name{Local: %q}, Value: %q},\n",
+			attr.Name.Local, attr.Default)
+	}
+	fn, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML decodes the element start, setting the attributes\n"+
+			"// that are missing from it to their default values.").
+		Receiver("t *"+s.name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			defaults := []xml.Attr{
+				%s
+			}
+		Outer:
+			for _, def := range defaults {
+				for _, attr := range start.Attr {
+					if attr.Name.Local == def.Name.Local {
+						continue Outer
+					}
+				}
+				start.Attr = append(start.Attr, def)
+			}
+			%s
+			return d.DecodeElement(&overlay, &start)
+		`, defaults.String(), decodeOverlay(s.name, "")).
+		Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", s.name, err)
+	}
+	return fn, nil
+}
+
+// decodeOverlay returns the statements of an UnmarshalXML method of
+// the struct type name that declare overlay, a value that decodes
+// into t as if neither t nor the struct types it embeds had an
+// UnmarshalXML method. The fields in extra are added to the overlay,
+// and take precedence over those of t.
+func decodeOverlay(name, extra string) string {
+	// The UnmarshalXML field hides the method of any embedded
+	// struct type, which would otherwise decode the element in
+	// place of t.
+	return fmt.Sprintf(`
+		type Plain %s
+		var overlay struct {
+			%s
+			*Plain
+			UnmarshalXML struct{} `+"`xml:\"-\"`"+`
+		}
+		overlay.Plain = (*Plain)(t)
+	`, name, extra)
+}
+
+// wrapUnmarshal returns the statements of a new UnmarshalXML method
+// of s that decode the element start, read from d, into t, and set
+// err. If s already has an UnmarshalXML method, such as one that sets
+// attribute defaults, it is renamed to inner and called, so that the
+// new method adds to what the old one does.
+func wrapUnmarshal(s *spec, inner string) string {
+	for _, fn := range s.methods {
+		if fn.Name.Name != "UnmarshalXML" {
+			continue
+		}
+		fn.Name = ast.NewIdent(inner)
+		if fn.Doc != nil && len(fn.Doc.List) > 0 {
+			first := fn.Doc.List[0]
+			first.Text = strings.Replace(first.Text, "UnmarshalXML", inner, 1)
+		}
+		return fmt.Sprintf("err := t.%s(d, start)", inner)
+	}
+	return decodeOverlay(s.name, "") + "err := d.DecodeElement(&overlay, &start)"
 }
 
 func (cfg *Config) genSimpleType(t *xsd.SimpleType) ([]spec, error) {
@@ -520,14 +1042,63 @@ func (cfg *Config) genSimpleType(t *xsd.SimpleType) ([]spec, error) {
 		return nil, fmt.Errorf("simpleType %s: base type %s: %v",
 			t.Name.Local, xsd.XMLName(t.Base).Local, err)
 	}
-	result = append(result, spec{
+	if t.Base == xsd.NOTATION {
+		// The notations a restriction of NOTATION allows are
+		// those it enumerates.
+		base = builtinExpr(xsd.NOTATION)
+	}
+	s := spec{
 		name:    cfg.typeName(t.Name),
 		expr:    base,
 		xsdType: t,
-	})
+	}
+	if cfg.enumConstants {
+		if decl := cfg.genEnumConstants(s, t); decl != nil {
+			s.decls = append(s.decls, decl)
+		}
+	}
+	if cfg.emitValidators {
+		fn, decls, err := cfg.genSimpleValidator(s, t)
+		if err != nil {
+			return nil, err
+		}
+		if fn != nil {
+			s.methods = append(s.methods, fn)
+			s.decls = append(s.decls, decls...)
+		}
+	}
+	if cfg.textMarshalers {
+		fns, err := cfg.genTextMarshalers(s, t)
+		if err != nil {
+			return nil, err
+		}
+		s.methods = append(s.methods, fns...)
+	}
+	result = append(result, s)
 	return result, nil
 }
 
+// hasMethods reports whether a simpleType is declared with constants
+// or methods, in which case struct fields should use the simpleType
+// rather than its underlying built-in type.
+func (cfg *Config) hasMethods(t *xsd.SimpleType) bool {
+	if cfg.enumConstants && len(enumValues(t)) > 0 {
+		return true
+	}
+	if cfg.textMarshalers {
+		if ex, err := cfg.expr(t.Base); err == nil {
+			if ident, ok := ex.(*ast.Ident); ok && textMarshalable(ident) {
+				return true
+			}
+		}
+	}
+	r := t.Restriction
+	if cfg.emitValidators && (len(r.Enum) > 0 || r.Pattern != nil || r.MinLength > 0 || r.MaxLength > 0) {
+		return true
+	}
+	return false
+}
+
 // Generate a type declaration for the built-in time values, along with
 // marshal/unmarshal methods for them.
 func (cfg *Config) genTimeSpec(t xsd.Builtin) ([]spec, error) {
@@ -635,6 +1206,132 @@ func (cfg *Config) genBinarySpec(t xsd.Builtin) ([]spec, error) {
 	return []spec{s}, nil
 }
 
+// An element of type anyType may have any attributes and content.
+// The xsdAnyType generated for it keeps them as they were in the
+// document, so that they are written again when it is marshalled.
+func (cfg *Config) genAnyTypeSpec(t xsd.Builtin) ([]spec, error) {
+	cfg.debugf("generating Go source for %q", xsd.XMLName(t).Local)
+	s := spec{
+		name: "xsdAnyType",
+		expr: gen.Struct(
+			ast.NewIdent("Attr"), &ast.ArrayType{Elt: ast.NewIdent("xml.Attr")}, nil,
+			ast.NewIdent("InnerXML"), &ast.ArrayType{Elt: ast.NewIdent("byte")}, nil),
+		xsdType: t,
+	}
+	unmarshal, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML keeps the attributes of the element, including its\n"+
+			"// namespace declarations, and its content.").
+		Receiver("t *"+s.name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			var content struct {
+				InnerXML []byte ` + "`xml:\",innerxml\"`" + `
+			}
+			if err := d.DecodeElement(&content, &start); err != nil {
+				return err
+			}
+			t.Attr, t.InnerXML = start.Attr, content.InnerXML
+			return nil
+		`).Decl()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalXML %s: %v", s.name, err)
+	}
+	marshal, err := gen.Func("MarshalXML").
+		Comment("// MarshalXML writes the attributes and content of the element.\n"+
+			"// The namespace declarations of the element are written with the\n"+
+			"// same prefixes, so that the prefixes in its content still resolve.\n"+
+			"// The namespace of the element itself is declared by e.").
+		Receiver("t "+s.name).
+		Args("e *xml.Encoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			prefix := make(map[string]string)
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					prefix[attr.Value] = attr.Name.Local
+				}
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					attr.Name = xml.Name{Local: "xmlns:" + attr.Name.Local}
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					if start.Name.Space != "" {
+						continue
+					}
+				case prefix[attr.Name.Space] != "":
+					attr.Name = xml.Name{Local: prefix[attr.Name.Space] + ":" + attr.Name.Local}
+				}
+				start.Attr = append(start.Attr, attr)
+			}
+			return e.EncodeElement(struct {
+				InnerXML []byte ` + "`xml:\",innerxml\"`" + `
+			}{t.InnerXML}, start)
+		`).Decl()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalXML %s: %v", s.name, err)
+	}
+	s.methods = append(s.methods, unmarshal, marshal)
+	return []spec{s}, nil
+}
+
+// An element whose type has no content, such as <deleted/>, carries
+// no information but its presence. An optional one is given a field
+// of type *struct{}, which is nil if the element is absent, or, with
+// the EmptyElementsAsBool option, an xsdFlag, which is true if the
+// element is present.
+func (cfg *Config) isEmptyType(t xsd.Type) bool {
+	c, ok := t.(*xsd.ComplexType)
+	if !ok {
+		return false
+	}
+	if b, ok := c.Base.(xsd.Builtin); c.Base != nil && (!ok || b != xsd.AnyType) {
+		return false
+	}
+	attributes, elements := cfg.filterFields(c)
+	return len(attributes) == 0 && len(elements) == 0
+}
+
+func (cfg *Config) genFlagSpec() (spec, error) {
+	s := spec{
+		name: "xsdFlag",
+		expr: ast.NewIdent("bool"),
+	}
+	unmarshal, err := gen.Func("UnmarshalXML").
+		Comment("// UnmarshalXML sets f, as the element is present.").
+		Receiver("f *"+s.name).
+		Args("d *xml.Decoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			*f = true
+			return d.Skip()
+		`).Decl()
+	if err != nil {
+		return s, fmt.Errorf("UnmarshalXML %s: %v", s.name, err)
+	}
+	marshal, err := gen.Func("MarshalXML").
+		Comment("// MarshalXML writes an empty element if f is true, and nothing\n"+
+			"// otherwise.").
+		Receiver("f "+s.name).
+		Args("e *xml.Encoder", "start xml.StartElement").
+		Returns("error").
+		Body(`
+			if !f {
+				return nil
+			}
+			if err := e.EncodeToken(start); err != nil {
+				return err
+			}
+			return e.EncodeToken(start.End())
+		`).Decl()
+	if err != nil {
+		return s, fmt.Errorf("MarshalXML %s: %v", s.name, err)
+	}
+	s.methods = append(s.methods, unmarshal, marshal)
+	return s, nil
+}
+
 // Generate a type declaration for the bult-in list values, along with
 // marshal/unmarshal methods
 func (cfg *Config) genTokenListSpec(t xsd.Builtin) ([]spec, error) {
@@ -713,7 +1410,9 @@ func (cfg *Config) genSimpleListSpec(t *xsd.SimpleType) ([]spec, error) {
 }
 
 // O(n²) is OK since you'll never see more than ~40 attributes...
-// right?
+// right? Attributes that the restriction marks as prohibited are kept,
+// so that types further down the derivation chain do not inherit them
+// again; filterFields leaves them out of the Go type.
 func mergeAttributes(src, base *xsd.ComplexType) []xsd.Attribute {
 Loop:
 	for _, baseattr := range base.Attributes {
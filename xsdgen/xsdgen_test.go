@@ -1,10 +1,27 @@
 package xsdgen
 
 import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unicode"
+
+	"github.com/lajonat/go-xml/internal/gen"
+	"github.com/lajonat/go-xml/xmltree"
+	"github.com/lajonat/go-xml/xsd"
+	"golang.org/x/tools/imports"
 )
 
 func glob(dir ...string) []string {
@@ -21,6 +38,116 @@ func (t *testLogger) Printf(format string, v ...interface{}) {
 	t.Logf(format, v...)
 }
 
+const testSchema = `
+	<schema xmlns="http://www.w3.org/2001/XMLSchema"
+	        xmlns:tns="http://www.example.com/"
+	        xmlns:xs="http://www.w3.org/2001/XMLSchema"
+	        targetNamespace="http://www.example.com/">
+	  %s
+	</schema>`
+
+// testSource generates Go source for the schema fragment s, which is
+// wrapped in a <schema> element with the target namespace
+// http://www.example.com/, and any schema files that it imports.
+func testSource(t *testing.T, cfg *Config, s string, deps ...string) []byte {
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "schema.xsd")
+	if err := ioutil.WriteFile(filename, []byte(fmt.Sprintf(testSchema, s)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	src, err := cfg.GenSource(append([]string{filename}, deps...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// structFields returns the fields of the struct type declared as name
+// in the Go source src. The map is keyed by field name (or type name,
+// for embedded fields) and each value holds the field's type and tag.
+func structFields(t *testing.T, src []byte, name string) map[string]string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]string)
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok || spec.Name.Name != name {
+			return true
+		}
+		str, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			t.Fatalf("%s is a %s, not a struct", name, gen.ExprString(spec.Type))
+		}
+		found = true
+		for _, f := range str.Fields.List {
+			val := gen.ExprString(f.Type)
+			if f.Tag != nil {
+				val += " " + f.Tag.Value
+			}
+			if len(f.Names) == 0 {
+				fields[gen.ExprString(f.Type)] = val
+			}
+			for _, id := range f.Names {
+				fields[id.Name] = val
+			}
+		}
+		return false
+	})
+	if !found {
+		t.Fatalf("type %s not found in\n%s", name, src)
+	}
+	return fields
+}
+
+// testRun generates Go source for the schema fragment s into the main
+// package of a new program, and runs it with the given body for
+// its main function. The program's output is returned.
+func testRun(t *testing.T, cfg *Config, s, main string, deps ...string) string {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available: ", err)
+	}
+	cfg.Option(PackageName("main"), LogOutput((*testLogger)(t)))
+	src := testSource(t, cfg, s, deps...)
+
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mainSrc, err := imports.Process("main.go", []byte("package main\n\nfunc main() {\n"+main+"\n}\n"), nil)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, main)
+	}
+	files := map[string][]byte{
+		"go.mod":           []byte("module xsdgentest\n"),
+		"xsdgen_output.go": src,
+		"main.go":          mainSrc,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s\n%s", err, out, src)
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func TestLibrarySchema(t *testing.T) {
 	testGen(t, "http://dyomedea.com/ns/library", "testdata/library.xsd")
 }
@@ -52,3 +179,3457 @@ func testGen(t *testing.T, ns string, files ...string) {
 		t.Logf("\n%s\n", data)
 	}
 }
+
+func BenchmarkUSTreasureSDN(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(Namespaces("http://tempuri.org/sdnList.xsd"))
+		if _, err := cfg.GenSource("testdata/sdn.xsd"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProhibitedAttribute(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `
+	  <complexType name="Base">
+	    <sequence>
+	      <element name="value" type="xs:string" />
+	    </sequence>
+	    <attribute name="lang" type="xs:string" />
+	    <attribute name="internal" type="xs:string" />
+	  </complexType>
+	  <complexType name="Public">
+	    <complexContent>
+	      <restriction base="tns:Base">
+	        <sequence>
+	          <element name="value" type="xs:string" />
+	        </sequence>
+	        <attribute name="internal" use="prohibited" />
+	      </restriction>
+	    </complexContent>
+	  </complexType>`)
+
+	if _, ok := structFields(t, src, "Base")["Internal"]; !ok {
+		t.Errorf("base type is missing attribute field Internal\n%s", src)
+	}
+	fields := structFields(t, src, "Public")
+	if f, ok := fields["Internal"]; ok {
+		t.Errorf("derived type has prohibited attribute field Internal %s", f)
+	}
+	if _, ok := fields["Lang"]; !ok {
+		t.Errorf("derived type did not inherit attribute field Lang\n%s", src)
+	}
+}
+
+func TestProhibitedAttributeValidate(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(ValidateOnDecode())
+	out := testRun(t, &cfg, `
+	  <complexType name="Base">
+	    <attribute name="lang" type="xs:string" />
+	    <attribute name="internal" type="xs:string" />
+	  </complexType>
+	  <complexType name="Public">
+	    <complexContent>
+	      <restriction base="tns:Base">
+	        <attribute name="internal" use="prohibited" />
+	      </restriction>
+	    </complexContent>
+	  </complexType>`, `
+		fmt.Println(Public{Lang: "en"}.Validate())
+		fmt.Println(Public{Internal: "x"}.Validate())
+		var p Public
+		err := xml.Unmarshal([]byte(`+"`"+`<Public lang="en" internal="yes"/>`+"`"+`), &p)
+		fmt.Println(err)
+		out, err := xml.Marshal(Public{Lang: "en"})
+		fmt.Println(string(out), err)
+	`)
+	want := "<nil>\n" +
+		"Internal: prohibited attribute internal is present\n" +
+		"element Public at line 1, column 35: Internal: prohibited attribute internal is present\n" +
+		`<Public lang="en"></Public> <nil>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestBuilders(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitBuilders())
+	out := testRun(t, &cfg, `
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="street" type="xs:string" />
+	      <element name="city" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Customer">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <element name="address" type="tns:Address" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`, `
+		c := NewCustomerBuilder().
+			WithName("Alice").
+			AddAddress(NewAddressBuilder().WithStreet("1 Main St").WithCity("Springfield").Build()).
+			AddAddress(NewAddressBuilder().WithStreet("2 Elm St").WithCity("Shelbyville").Build()).
+			Build()
+		out, err := xml.Marshal(c)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s", out)
+	`)
+	want := `<Customer>` +
+		`<name xmlns="http://www.example.com/">Alice</name>` +
+		`<address xmlns="http://www.example.com/">` +
+		`<street xmlns="http://www.example.com/">1 Main St</street>` +
+		`<city xmlns="http://www.example.com/">Springfield</city>` +
+		`</address>` +
+		`<address xmlns="http://www.example.com/">` +
+		`<street xmlns="http://www.example.com/">2 Elm St</street>` +
+		`<city xmlns="http://www.example.com/">Shelbyville</city>` +
+		`</address>` +
+		`</Customer>`
+	if out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+func TestBuildersValidate(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitBuilders(), EmitValidators())
+	out := testRun(t, &cfg, `
+	  <complexType name="Customer">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <element name="email" type="xs:string" minOccurs="0" />
+	    </sequence>
+	  </complexType>`, `
+		if _, err := NewCustomerBuilder().WithEmail("a@example.com").Build(); err != nil {
+			fmt.Println(err)
+		}
+		c, err := NewCustomerBuilder().WithName("Alice").Build()
+		fmt.Println(c.Name, err)
+	`)
+	if want := "Name: required element name is missing\nAlice <nil>"; out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+// Build accepts values whose optional elements are left unset.
+func TestBuildersOptional(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitBuilders(), EmitValidators())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Zip">
+	    <restriction base="xs:string">
+	      <pattern value="\d{5}" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="street" type="xs:string" />
+	      <element name="zip" type="tns:Zip" minOccurs="0" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Customer">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <element name="address" type="tns:Address" minOccurs="0" />
+	    </sequence>
+	  </complexType>`, `
+		c, err := NewCustomerBuilder().WithName("Alice").Build()
+		fmt.Println(c.Name, err)
+		a, err := NewAddressBuilder().WithStreet("1 Main St").Build()
+		if err != nil {
+			panic(err)
+		}
+		_, err = NewCustomerBuilder().WithName("Bob").WithAddress(a).Build()
+		fmt.Println(err)
+	`)
+	if want := "Alice <nil>\n<nil>"; out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+func TestNamespacePrefix(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(NamespacePrefix("http://www.example.com/", "po"))
+	out := testRun(t, &cfg, `
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="sku" type="xs:string" />
+	    </sequence>
+	    <attribute name="quantity" type="xs:int" />
+	  </complexType>
+	  <complexType name="PurchaseOrder">
+	    <sequence>
+	      <element name="item" type="tns:Item" />
+	      <element name="comment" type="xs:string" />
+	    </sequence>
+	  </complexType>`, `
+		var po PurchaseOrder
+		po.Item.Sku = "872-AA"
+		po.Item.Quantity = 2
+		po.Comment = "rush"
+		out, err := MarshalDocument(po)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s", out)
+	`)
+	want := `<PurchaseOrder xmlns:po="http://www.example.com/">` +
+		`<po:item quantity="2"><po:sku>872-AA</po:sku></po:item>` +
+		`<po:comment>rush</po:comment>` +
+		`</PurchaseOrder>`
+	if out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+// A conflict between pinned prefixes is found when the prefix is
+// pinned, and returned before any schema is read.
+func TestNamespacePrefixConflict(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(NamespacePrefix("http://www.example.com/", "ex"))
+	undo := cfg.Option(NamespacePrefix("http://www.example.net/", "ex"))
+
+	// The file does not exist, so the error must come from the
+	// options rather than from reading the schema.
+	_, err := cfg.GenAST("testdata/missing.xsd")
+	if want := `namespace prefix "ex" pinned to both http://www.example.com/ and http://www.example.net/`; err == nil || err.Error() != want {
+		t.Errorf("GenAST returned error %v, want %s", err, want)
+	}
+	if _, err := cfg.GenFromSchema(); err == nil || !strings.Contains(err.Error(), "pinned to both") {
+		t.Errorf("GenFromSchema returned error %v, want the prefix conflict", err)
+	}
+
+	// Undoing the option removes the conflict.
+	cfg.Option(undo)
+	if _, err := cfg.GenAST("testdata/missing.xsd"); err == nil || strings.Contains(err.Error(), "pinned") {
+		t.Errorf("after undoing the conflict, GenAST returned error %v, want a missing file", err)
+	}
+}
+
+func TestDurationType(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(UseDurationType())
+	out := testRun(t, &cfg, `
+	  <complexType name="Lease">
+	    <sequence>
+	      <element name="term" type="xs:duration" />
+	    </sequence>
+	  </complexType>`, `
+		var lease Lease
+		doc := `+"`"+`<Lease><term xmlns="http://www.example.com/">P1M2DT3H</term></Lease>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &lease); err != nil {
+			panic(err)
+		}
+		start := time.Date(2016, time.January, 30, 12, 0, 0, 0, time.UTC)
+		fmt.Println(lease.Term.AddTo(start).Format(time.RFC3339))
+		fmt.Println(lease.Term.ApproxDuration())
+		text, err := lease.Term.MarshalText()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", text)
+
+		var back xsdDuration
+		if err := back.UnmarshalText([]byte("-P1Y")); err != nil {
+			panic(err)
+		}
+		fmt.Println(back.AddTo(start).Format(time.RFC3339))
+	`)
+	// January 30th plus one month is February 30th, which
+	// does not exist. It is normalized to March 1st, 2016.
+	want := "2016-03-03T15:00:00Z\n" +
+		"771h0m0s\n" +
+		"P1M2DT3H\n" +
+		"2015-01-30T12:00:00Z"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestEnumConstants(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitEnumConstants(), EmitValidators())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Grade">
+	    <restriction base="xs:string">
+	      <pattern value="(A|B|C)" />
+	    </restriction>
+	  </simpleType>
+	  <simpleType name="Sku">
+	    <restriction base="xs:string">
+	      <pattern value="\d{3}-[A-Z]{2}" />
+	    </restriction>
+	  </simpleType>
+	  <simpleType name="Availability">
+	    <restriction base="xs:string">
+	      <enumeration value="in-stock" />
+	      <enumeration value="back-order" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="grade" type="tns:Grade" />
+	      <element name="sku" type="tns:Sku" />
+	    </sequence>
+	    <attribute name="availability" type="tns:Availability" />
+	  </complexType>`, `
+		item := Item{Grade: GradeB, Sku: "123-AB", Availability: AvailabilityBackOrder}
+		fmt.Println(item.Grade, item.Grade.Validate() == nil)
+		fmt.Println(item.Sku, item.Sku.Validate() == nil)
+		fmt.Println(item.Availability, item.Availability.Validate() == nil)
+		fmt.Println(Grade("D").Validate())
+		fmt.Println(Sku("12-ABC").Validate())
+		fmt.Println(Availability("gone").Validate())
+		_ = []Grade{GradeA, GradeC}
+		_ = AvailabilityInStock
+	`)
+	want := "B true\n" +
+		"123-AB true\n" +
+		"back-order true\n" +
+		`Grade: "D" does not match the pattern (A|B|C)` + "\n" +
+		`Sku: "12-ABC" does not match the pattern \p{Nd}{3}-[A-Z]{2}` + "\n" +
+		`Availability: "gone" is not one of the allowed values`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+	src := testSource(t, &cfg, `
+	  <simpleType name="Sku">
+	    <restriction base="xs:string">
+	      <pattern value="\d{3}-[A-Z]{2}" />
+	    </restriction>
+	  </simpleType>`)
+	if strings.Contains(string(src), "\nconst") {
+		t.Errorf("constants declared for a pattern that is not a list of literals:\n%s", src)
+	}
+}
+
+func TestStreamDecoder(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitStreamDecoder())
+	out := testRun(t, &cfg, `
+	  <complexType name="Feed">
+	    <sequence>
+	      <element name="title" type="xs:string" />
+	      <element name="entry" type="tns:Entry" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Entry">
+	    <sequence>
+	      <element name="title" type="xs:string" />
+	      <element name="count" type="xs:int" />
+	    </sequence>
+	    <attribute name="seq" type="xs:int" />
+	  </complexType>`, `
+		const n = 50000
+		r, w := io.Pipe()
+		go func() {
+			fmt.Fprint(w, `+"`"+`<ex:Feed xmlns:ex="http://www.example.com/"><ex:title>feed</ex:title>`+"`"+`)
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(w, `+"`"+`<ex:entry seq="%d"><ex:title>entry %d</ex:title><ex:count>%d</ex:count></ex:entry>`+"`"+`, i, i, i*2)
+			}
+			fmt.Fprint(w, `+"`"+`</ex:Feed>`+"`"+`)
+			w.Close()
+		}()
+		var (
+			count   int
+			maxHeap uint64
+			stats   runtime.MemStats
+		)
+		err := DecodeStream(r, "http://www.example.com/ entry", func(v interface{}) error {
+			e := v.(*Entry)
+			if e.Seq != count || e.Title != fmt.Sprintf("entry %d", count) || e.Count != count*2 {
+				return fmt.Errorf("record %d decoded as %+v", count, *e)
+			}
+			count++
+			if count%5000 == 0 {
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > maxHeap {
+					maxHeap = stats.HeapAlloc
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(count)
+		fmt.Println(maxHeap < 8<<20)
+		fmt.Println(DecodeStream(strings.NewReader("<Feed/>"), "Feed", nil))
+	`)
+	want := "50000\ntrue\n" + `no generated type for element "Feed"`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestTextMarshalers(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitTextMarshalers(), EmitEnumConstants())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Color">
+	    <restriction base="xs:string">
+	      <enumeration value="red" />
+	      <enumeration value="green" />
+	    </restriction>
+	  </simpleType>
+	  <simpleType name="ReleaseDate">
+	    <restriction base="xs:date" />
+	  </simpleType>
+	  <simpleType name="Weight">
+	    <restriction base="xs:double" />
+	  </simpleType>
+	  <complexType name="Paint">
+	    <sequence>
+	      <element name="color" type="tns:Color" />
+	      <element name="released" type="tns:ReleaseDate" />
+	      <element name="weight" type="tns:Weight" />
+	    </sequence>
+	  </complexType>`, `
+		var p Paint
+		doc := `+"`"+`<Paint xmlns="http://www.example.com/">
+		  <color>green</color>
+		  <released>2016-03-01</released>
+		  <weight>INF</weight>
+		</Paint>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &p); err != nil {
+			panic(err)
+		}
+		counts := map[Color]int{p.Color: 2, ColorRed: 1}
+		dates := map[ReleaseDate]Weight{p.Released: p.Weight}
+		for _, v := range []interface{}{counts, dates} {
+			data, err := json.Marshal(v)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", data)
+		}
+		var back map[ReleaseDate]Weight
+		if err := json.Unmarshal([]byte(`+"`"+`{"2016-03-01": "-INF"}`+"`"+`), &back); err != nil {
+			panic(err)
+		}
+		for k, v := range back {
+			fmt.Println(time.Time(k).Format("Jan 2 2006"), float64(v))
+		}
+	`)
+	want := `{"green":2,"red":1}` + "\n" +
+		`{"2016-03-01":"INF"}` + "\n" +
+		"Mar 1 2016 -Inf"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestAdditive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemaFile := filepath.Join(dir, "schema.xsd")
+	output := filepath.Join(dir, "books.go")
+	gen := func(fields string) error {
+		schema := fmt.Sprintf(testSchema, `
+		  <complexType name="Book">
+		    <sequence>`+fields+`</sequence>
+		  </complexType>`)
+		if err := ioutil.WriteFile(schemaFile, []byte(schema), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(LogOutput((*testLogger)(t)))
+		return cfg.GenCLI("-a", "-pkg", "books", "-o", output, schemaFile)
+	}
+	readOutput := func() string {
+		data, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if err := gen(`<element name="title" type="xs:string" />`); err != nil {
+		t.Fatal(err)
+	}
+	user := `
+// Describe is written by hand.
+func Describe(b Book) string {
+	return strings.ToUpper(b.Title)
+}
+`
+	src := readOutput() + user
+	if err := ioutil.WriteFile(output, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen(`<element name="title" type="xs:string" />
+	    <element name="published" type="xs:date" />`); err != nil {
+		t.Fatal(err)
+	}
+	src = readOutput()
+	if !strings.Contains(src, strings.TrimSpace(user)) {
+		t.Errorf("hand-written function was not preserved:\n%s", src)
+	}
+	if !strings.Contains(src, "Published") {
+		t.Errorf("generated code was not updated:\n%s", src)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), output, src, 0); err != nil {
+		t.Errorf("%v in\n%s", err, src)
+	}
+
+	src += "\ntype Book struct{}\n"
+	if err := ioutil.WriteFile(output, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen(`<element name="title" type="xs:string" />`); err == nil {
+		t.Error("expected an error for a hand-written type named Book")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestEnumSimpleContent(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitEnumConstants(), EmitValidators())
+	out := testRun(t, &cfg, `
+	  <simpleType name="StatusCode">
+	    <restriction base="xs:string">
+	      <enumeration value="active" />
+	      <enumeration value="suspended" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Status">
+	    <simpleContent>
+	      <extension base="tns:StatusCode">
+	        <attribute name="since" type="xs:date" />
+	      </extension>
+	    </simpleContent>
+	  </complexType>
+	  <complexType name="CurrentStatus">
+	    <simpleContent>
+	      <restriction base="tns:Status">
+	        <attribute name="since" type="xs:date" use="required" />
+	      </restriction>
+	    </simpleContent>
+	  </complexType>
+	  <complexType name="Account">
+	    <sequence>
+	      <element name="status" type="tns:CurrentStatus" />
+	      <element name="previous" type="tns:Status" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`, `
+		doc := `+"`"+`<Account xmlns="http://www.example.com/">`+
+		`<status since="2016-02-01">suspended</status>`+
+		`<previous since="2015-01-01">active</previous>`+
+		`<previous since="2014-01-01">closed</previous>`+
+		`</Account>`+"`"+`
+		var acct Account
+		if err := xml.Unmarshal([]byte(doc), &acct); err != nil {
+			panic(err)
+		}
+		fmt.Println(acct.Status.Value == StatusCodeSuspended, time.Time(acct.Status.Since).Year())
+		for _, p := range acct.Previous {
+			fmt.Println(p.Value, p.Value.Validate())
+		}
+		out, err := xml.Marshal(&acct)
+		if err != nil {
+			panic(err)
+		}
+		var back Account
+		if err := xml.Unmarshal(out, &back); err != nil {
+			panic(err)
+		}
+		fmt.Println(reflect.DeepEqual(acct, back))
+	`)
+	want := "true 2016\n" +
+		"active <nil>\n" +
+		`closed StatusCode: "closed" is not one of the allowed values` + "\n" +
+		"true"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestSimpleContentFieldName(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `
+	  <simpleType name="Currency">
+	    <restriction base="xs:string">
+	      <length value="3" />
+	    </restriction>
+	  </simpleType>
+	  <simpleType name="Unit">
+	    <restriction base="xs:string">
+	      <enumeration value="kg" />
+	      <enumeration value="lb" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Price">
+	    <simpleContent>
+	      <extension base="tns:Currency">
+	        <attribute name="amount" type="xs:decimal" />
+	      </extension>
+	    </simpleContent>
+	  </complexType>
+	  <complexType name="Weight">
+	    <simpleContent>
+	      <extension base="tns:Unit">
+	        <attribute name="amount" type="xs:decimal" />
+	      </extension>
+	    </simpleContent>
+	  </complexType>`)
+	for name, want := range map[string]string{
+		"Price":  "Currency",
+		"Weight": "Value",
+	} {
+		fields := structFields(t, src, name)
+		if _, ok := fields[want]; !ok {
+			t.Errorf("%s: no chardata field %s in %v", name, want, fields)
+		}
+	}
+}
+
+func TestUnmarshalHelper(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitUnmarshal())
+	out := testRun(t, &cfg, `
+	  <complexType name="Note">
+	    <sequence>
+	      <element name="body" type="xs:string" />
+	    </sequence>
+	  </complexType>`, `
+		docs := []string{
+			"\ufeff<Note xmlns=\"http://www.example.com/\"><body>bom</body></Note>",
+			"\ufeff\r\n  <?xml version=\"1.0\"?>\n<?xml-stylesheet href=\"note.css\"?>\n" +
+				"<Note xmlns=\"http://www.example.com/\"><body>pi</body></Note>",
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>" +
+				"<Note xmlns=\"http://www.example.com/\"><body>caf\xe9</body></Note>",
+		}
+		for _, doc := range docs {
+			var n Note
+			if err := Unmarshal([]byte(doc), &n); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(n.Body)
+		}
+	`)
+	want := "bom\npi\ncafé"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPackageForNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemas := map[string]string{
+		"orders.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        xmlns:tns="http://www.example.com/"
+			        xmlns:common="http://www.example.net/common"
+			        targetNamespace="http://www.example.com/">
+			  <import namespace="http://www.example.net/common" />
+			  <complexType name="Order">
+			    <sequence>
+			      <element name="shipTo" type="common:Address" />
+			      <element name="billTo" type="common:Address" minOccurs="0" maxOccurs="unbounded" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+		"common.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        targetNamespace="http://www.example.net/common">
+			  <complexType name="Address">
+			    <sequence>
+			      <element name="street" type="string" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+	}
+	var files []string
+	for name, data := range schemas {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filename)
+	}
+
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(
+		LogOutput((*testLogger)(t)),
+		Namespaces("http://www.example.com/"),
+		PackageForNamespace("http://www.example.com/", "example.org/schema/orders"),
+		PackageForNamespace("http://www.example.net/common", "example.org/schema/common"))
+	src, err := cfg.GenSource(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name.Name != "orders" {
+		t.Errorf("package clause is %s, want orders", file.Name.Name)
+	}
+	var paths []string
+	for _, imp := range file.Imports {
+		paths = append(paths, imp.Path.Value)
+	}
+	if len(paths) != 1 || paths[0] != `"example.org/schema/common"` {
+		t.Errorf("got imports %v, want example.org/schema/common", paths)
+	}
+	fields := structFields(t, src, "Order")
+	if want := "common.Address `xml:\"http://www.example.com/ shipTo\"`"; fields["ShipTo"] != want {
+		t.Errorf("ShipTo field is %s, want %s", fields["ShipTo"], want)
+	}
+	if !strings.HasPrefix(fields["BillTo"], "[]common.Address ") {
+		t.Errorf("BillTo field is %s, want []common.Address", fields["BillTo"])
+	}
+	if strings.Contains(string(src), "type Address") {
+		t.Errorf("Address declared in package orders:\n%s", src)
+	}
+}
+
+func TestExtraTag(t *testing.T) {
+	snake := func(s string) string {
+		var buf bytes.Buffer
+		for i, r := range s {
+			if unicode.IsUpper(r) {
+				if i > 0 {
+					buf.WriteByte('_')
+				}
+				r = unicode.ToLower(r)
+			}
+			buf.WriteRune(r)
+		}
+		return buf.String()
+	}
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(
+		ExtraTag("db", func(f FieldInfo) string {
+			return snake(f.Name.Local)
+		}),
+		ExtraTag("bson", func(f FieldInfo) string {
+			if f.Attribute {
+				return ""
+			}
+			return strings.ToLower(f.FieldName) + ",omitempty"
+		}))
+	src := testSource(t, &cfg, `
+	  <complexType name="Person">
+	    <sequence>
+	      <element name="firstName" type="xs:string" />
+	      <element name="phoneNumber" type="xs:string" maxOccurs="unbounded" />
+	    </sequence>
+	    <attribute name="birthYear" type="xs:int" />
+	  </complexType>`)
+	fields := structFields(t, src, "Person")
+	want := map[string]string{
+		"FirstName":   "string `xml:\"http://www.example.com/ firstName\" db:\"first_name\" bson:\"firstname,omitempty\"`",
+		"PhoneNumber": "[]string `xml:\"http://www.example.com/ phoneNumber\" db:\"phone_number\" bson:\"phonenumber,omitempty\"`",
+		"BirthYear":   "int `xml:\"birthYear,attr\" db:\"birth_year\"`",
+	}
+	for name, v := range want {
+		if fields[name] != v {
+			t.Errorf("field %s is %s, want %s", name, fields[name], v)
+		}
+	}
+}
+
+func TestExtensionChain(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	schema := `
+	  <complexType name="Animal">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	    </sequence>
+	    <attribute name="tag" type="xs:string" />
+	  </complexType>
+	  <complexType name="Mammal">
+	    <complexContent>
+	      <extension base="tns:Animal">
+	        <sequence>
+	          <element name="fur" type="xs:string" />
+	        </sequence>
+	        <attribute name="warm" type="xs:boolean" />
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Dog">
+	    <complexContent>
+	      <extension base="tns:Mammal">
+	        <sequence>
+	          <element name="breed" type="xs:string" />
+	          <element name="owner">
+	            <complexType>
+	              <sequence>
+	                <element name="first" type="xs:string" />
+	              </sequence>
+	              <attribute name="since" type="xs:int" />
+	            </complexType>
+	          </element>
+	        </sequence>
+	        <attribute name="licensed" type="xs:boolean" />
+	      </extension>
+	    </complexContent>
+	  </complexType>`
+
+	// The fields of each base type come first, so that encoding/xml
+	// writes the base type's elements before the derived type's.
+	src := testSource(t, &cfg, schema)
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for typ, want := range map[string][]string{
+		"Mammal": {"Animal", "Warm", "Fur"},
+		"Dog":    {"Mammal", "Licensed", "Breed", "Owner"},
+	} {
+		var got []string
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == typ {
+				for _, f := range spec.Type.(*ast.StructType).Fields.List {
+					if len(f.Names) == 0 {
+						got = append(got, gen.ExprString(f.Type))
+					} else {
+						got = append(got, f.Names[0].Name)
+					}
+				}
+			}
+			return true
+		})
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("fields of %s are %v, want %v", typ, got, want)
+		}
+	}
+
+	out := testRun(t, &cfg, schema, `
+		var dog Dog
+		dog.Tag = "A-1"
+		dog.Name = "Rex"
+		dog.Warm = true
+		dog.Fur = "short"
+		dog.Licensed = true
+		dog.Breed = "beagle"
+		dog.Owner.First = "Ann"
+		dog.Owner.Since = 2012
+		data, err := xml.Marshal(dog)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", data)
+		var back Dog
+		if err := xml.Unmarshal(data, &back); err != nil {
+			panic(err)
+		}
+		fmt.Println(reflect.DeepEqual(dog, back))
+	`)
+	want := `<Dog tag="A-1" warm="true" licensed="true">` +
+		`<name xmlns="http://www.example.com/">Rex</name>` +
+		`<fur xmlns="http://www.example.com/">short</fur>` +
+		`<breed xmlns="http://www.example.com/">beagle</breed>` +
+		`<owner xmlns="http://www.example.com/" since="2012">` +
+		`<first xmlns="http://www.example.com/">Ann</first></owner>` +
+		`</Dog>` + "\ntrue"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitValidators())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Sku">
+	    <restriction base="xs:string">
+	      <pattern value="\d{3}-[A-Z]{2}" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="sku" type="tns:Sku" />
+	      <element name="note" type="xs:string" minOccurs="0" />
+	    </sequence>
+	    <attribute name="code" type="xs:string" use="required" />
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="item" type="tns:Item" maxOccurs="3" />
+	    </sequence>
+	  </complexType>`, `
+		order := Order{Item: []Item{
+			{Code: "a", Sku: "123-AB"},
+			{Code: "b", Sku: "12-ABC"},
+			{Sku: "456-CD"},
+			{Code: "d", Sku: "789-EF"},
+		}}
+		for _, err := range order.ValidateAll() {
+			fmt.Println(err)
+		}
+		fmt.Println(order.Validate())
+		order = Order{Customer: "Gopher", Item: []Item{{Code: "a", Sku: "123-AB"}}}
+		fmt.Println(len(order.ValidateAll()), order.Validate())
+	`)
+	want := "Customer: required element customer is missing\n" +
+		"Item: element item appears 4 times, but may appear at most 3 times\n" +
+		`Item[1].Sku: "12-ABC" does not match the pattern \p{Nd}{3}-[A-Z]{2}` + "\n" +
+		"Item[2].Code: required attribute code is missing\n" +
+		"Customer: required element customer is missing\n" +
+		"0 <nil>"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+// Optional elements and attributes that are absent are not checked,
+// but are when they hold a value.
+func TestValidateAllOptional(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitValidators())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Color">
+	    <restriction base="xs:string">
+	      <enumeration value="red" />
+	      <enumeration value="blue" />
+	    </restriction>
+	  </simpleType>
+	  <simpleType name="Code">
+	    <restriction base="xs:string">
+	      <pattern value="[A-C]" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="street" type="xs:string" />
+	      <element name="code" type="tns:Code" minOccurs="0" />
+	    </sequence>
+	    <attribute name="kind" type="tns:Color" />
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="ship" type="tns:Address" minOccurs="0" />
+	      <element name="code" type="tns:Code" minOccurs="0" />
+	    </sequence>
+	  </complexType>`, `
+		fmt.Println(Order{}.ValidateAll())
+		fmt.Println(Order{Ship: Address{Street: "Main"}}.ValidateAll())
+		for _, err := range (Order{Ship: Address{Kind: "green"}, Code: "D"}).ValidateAll() {
+			fmt.Println(err)
+		}
+	`)
+	want := "[]\n[]\n" +
+		`Ship.Kind: Color: "green" is not one of the allowed values` + "\n" +
+		"Ship.Street: required element street is missing\n" +
+		`Code: "D" does not match the pattern [A-C]`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestEmitJSON(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitJSON())
+	out := testRun(t, &cfg, `
+	  <complexType name="Car">
+	    <sequence>
+	      <element name="make" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Bike">
+	    <sequence>
+	      <element name="gears" type="xs:int" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Vehicle">
+	    <choice>
+	      <element name="car" type="tns:Car" />
+	      <element name="bike" type="tns:Bike" />
+	    </choice>
+	  </complexType>
+	  <simpleType name="Size">
+	    <union memberTypes="xs:int xs:boolean xs:string" />
+	  </simpleType>`, `
+		b, err := json.Marshal(Vehicle{Bike: Bike{Gears: 21}})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(b))
+		var v Vehicle
+		if err := json.Unmarshal(b, &v); err != nil {
+			panic(err)
+		}
+		fmt.Printf("%+v\n", v)
+		fmt.Println(json.Unmarshal([]byte(`+"`"+`{"type":"boat"}`+"`"+`), &v))
+
+		// A choice with no alternative is null, and decodes as
+		// the zero value.
+		if b, err = json.Marshal(Vehicle{}); err != nil {
+			panic(err)
+		}
+		v = Vehicle{Car: Car{Make: "old"}}
+		fmt.Println(string(b), json.Unmarshal(b, &v), v == Vehicle{})
+
+		b, err = json.Marshal([]Size{"12", "true", "large"})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(b))
+		var sizes []Size
+		if err := json.Unmarshal(b, &sizes); err != nil {
+			panic(err)
+		}
+		fmt.Printf("%q\n", sizes)
+	`)
+	want := `{"type":"bike","bike":{"Gears":21}}` + "\n" +
+		`{Car:{Make:} Bike:{Gears:21}}` + "\n" +
+		`Vehicle: unknown type "boat"` + "\n" +
+		"null <nil> true\n" +
+		`[12,true,"large"]` + "\n" +
+		`["12" "true" "large"]`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestAppInfo(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `
+	  <simpleType name="Timestamp">
+	    <annotation>
+	      <appinfo>
+	        <goType>time.Time</goType>
+	        <unknownDirective>ignored</unknownDirective>
+	      </appinfo>
+	    </annotation>
+	    <restriction base="xs:dateTime" />
+	  </simpleType>
+	  <simpleType name="Money">
+	    <annotation>
+	      <documentation>An amount of money.</documentation>
+	      <appinfo>
+	        <goType>money.Amount</goType>
+	        <goImport>example.com/lib/money</goImport>
+	      </appinfo>
+	    </annotation>
+	    <restriction base="xs:decimal" />
+	  </simpleType>
+	  <complexType name="Event">
+	    <sequence>
+	      <element name="at" type="tns:Timestamp">
+	        <annotation>
+	          <appinfo><jsonTag>at,omitempty</jsonTag></appinfo>
+	        </annotation>
+	      </element>
+	      <element name="price" type="tns:Money" />
+	    </sequence>
+	  </complexType>`)
+	fields := structFields(t, src, "Event")
+	want := map[string]string{
+		"At":    "time.Time `xml:\"http://www.example.com/ at\" json:\"at,omitempty\"`",
+		"Price": "money.Amount `xml:\"http://www.example.com/ price\"`",
+	}
+	for name, v := range want {
+		if fields[name] != v {
+			t.Errorf("field %s is %q, want %q", name, fields[name], v)
+		}
+	}
+	for _, s := range []string{`"time"`, `"example.com/lib/money"`} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Errorf("generated source does not import %s:\n%s", s, src)
+		}
+	}
+	for _, s := range []string{"type Timestamp", "type Money"} {
+		if bytes.Contains(src, []byte(s)) {
+			t.Errorf("generated source declares %s, which is replaced by its appinfo:\n%s", s, src)
+		}
+	}
+
+	// The imports of one run are not added to the next.
+	src = testSource(t, &cfg, `
+	  <simpleType name="Money">
+	    <annotation>
+	      <appinfo><goType>money.Amount</goType></appinfo>
+	    </annotation>
+	    <restriction base="xs:decimal" />
+	  </simpleType>
+	  <complexType name="Refund">
+	    <sequence>
+	      <element name="price" type="tns:Money" />
+	    </sequence>
+	  </complexType>`)
+	if bytes.Contains(src, []byte(`"example.com/lib/money"`)) {
+		t.Errorf("second run imports the goImport of the first:\n%s", src)
+	}
+}
+
+func TestNestedParticles(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `
+	  <group name="Contact">
+	    <sequence>
+	      <element name="phone" type="xs:string" />
+	      <element name="email" type="xs:string" maxOccurs="unbounded" />
+	    </sequence>
+	  </group>
+	  <complexType name="Person">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <choice minOccurs="0">
+	        <group ref="tns:Contact" />
+	        <element name="address" type="xs:string" />
+	      </choice>
+	      <sequence maxOccurs="unbounded">
+	        <choice>
+	          <element name="note" type="xs:string" />
+	          <element name="flag" type="xs:string" />
+	        </choice>
+	      </sequence>
+	    </sequence>
+	  </complexType>`)
+	fields := structFields(t, src, "Person")
+	want := map[string]string{
+		"Name":    "string",
+		"Phone":   "string",
+		"Email":   "[]string",
+		"Address": "string",
+		"Note":    "[]string",
+		"Flag":    "[]string",
+	}
+	for name, typ := range want {
+		if got := strings.Fields(fields[name]); len(got) == 0 || got[0] != typ {
+			t.Errorf("field %s is %q, want type %s", name, fields[name], typ)
+		}
+	}
+}
+
+func TestGenFromSchema(t *testing.T) {
+	schemas, err := xsd.Parse([]byte(fmt.Sprintf(testSchema, `
+	  <complexType name="Widget">
+	    <sequence>
+	      <element name="size" type="xs:int" />
+	    </sequence>
+	  </complexType>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []*xsd.Schema
+	for i := range schemas {
+		list = append(list, &schemas[i])
+		name := xml.Name{Space: "http://www.example.com/", Local: "Widget"}
+		if widget, ok := schemas[i].Types[name].(*xsd.ComplexType); ok {
+			widget.Name.Local = "Gadget"
+		}
+	}
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	file, err := cfg.GenFromSchema(list...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), file); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.Bytes()
+	if fields := structFields(t, src, "Gadget"); fields["Size"] == "" {
+		t.Errorf("Gadget has no Size field:\n%s", src)
+	}
+	if bytes.Contains(src, []byte("Widget")) {
+		t.Errorf("generated source uses the old name of the type:\n%s", src)
+	}
+}
+
+func TestXMLDeclaration(t *testing.T) {
+	const schema = `
+	  <complexType name="Note">
+	    <sequence>
+	      <element name="body" type="xs:string" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		data, err := MarshalDocument(Note{Body: "hello"})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s", data)
+	`
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(XMLDeclaration("", "UTF-8", "yes"))
+	out := testRun(t, &cfg, schema, main)
+	want := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Note><body xmlns="http://www.example.com/">hello</body></Note>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	cfg.Option(XMLDeclaration("", "", ""), NamespacePrefix("http://www.example.com/", "ex"))
+	out = testRun(t, &cfg, schema, main)
+	if strings.HasPrefix(out, "<?xml") {
+		t.Errorf("declaration written after it was disabled:\n%s", out)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitUnmarshal())
+	out := testRun(t, &cfg, `
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="quantity" type="xs:int" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="item" type="tns:Item" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>
+	  <element name="order" type="tns:Order" />`, `
+		var order Order
+		for _, doc := range []string{
+			"<order xmlns='http://www.example.com/'><customer>Gopher</customer>" +
+				"<item><quantity>1</quantity></item><item><quantity>two</quantity></item></order>",
+			"<order xmlns='http://www.example.com/'><item><quantity>1</quantity></order>",
+			"<invoice xmlns='http://www.example.com/'><customer>Gopher</customer></invoice>",
+		} {
+			err := Unmarshal([]byte(doc), &order)
+			if e, ok := err.(*DecodeError); ok {
+				fmt.Printf("%s %q %v %v\n", e.Path, e.Value, e.Expected, e.Got)
+			}
+			fmt.Println(err)
+		}
+	`)
+	want := `/order/item[2]/quantity "two" [] { }` + "\n" +
+		`/order/item[2]/quantity: value "two": strconv.ParseInt: parsing "two": invalid syntax` + "\n" +
+		`/order/item "" [] { }` + "\n" +
+		"/order/item: XML syntax error on line 1: element <item> closed by </order>\n" +
+		`/invoice "" [{http://www.example.com/ order}] {http://www.example.com/ invoice}` + "\n" +
+		`/invoice: unexpected element <invoice xmlns="http://www.example.com/">, expected <order xmlns="http://www.example.com/">`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestMultipleNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemas := map[string]string{
+		"orders.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        xmlns:common="http://www.example.net/common"
+			        targetNamespace="http://www.example.com/orders">
+			  <import namespace="http://www.example.net/common" />
+			  <complexType name="Order">
+			    <sequence>
+			      <element name="placed" type="date" />
+			      <element name="shipTo" type="common:Address" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+		"invoices.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        xmlns:common="http://www.example.net/common"
+			        targetNamespace="http://www.example.com/invoices">
+			  <import namespace="http://www.example.net/common" />
+			  <complexType name="Invoice">
+			    <sequence>
+			      <element name="due" type="date" />
+			      <element name="billTo" type="common:Address" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+		"common.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        targetNamespace="http://www.example.net/common">
+			  <complexType name="Address">
+			    <sequence>
+			      <element name="street" type="string" />
+			      <element name="city" type="string" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+	}
+	args := []string{
+		"-o", filepath.Join(dir, "out.go"),
+		"-ns", "http://www.example.com/orders",
+		"-ns", "http://www.example.com/invoices,http://www.example.net/common",
+	}
+	for name, data := range schemas {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, filename)
+	}
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	if err := cfg.GenCLI(args...); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "out.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "out.go", src, 0)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	count := make(map[string]int)
+	for _, decl := range file.Decls {
+		for _, name := range declNames(decl) {
+			count[name]++
+		}
+	}
+	for _, name := range []string{"Order", "Invoice", "Address", "xsdDate", "xsdDate.MarshalText"} {
+		if count[name] != 1 {
+			t.Errorf("%s declared %d times, want 1:\n%s", name, count[name], src)
+		}
+	}
+}
+
+type bufLogger struct{ bytes.Buffer }
+
+func (l *bufLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(&l.Buffer, format+"\n", v...)
+}
+
+func TestFieldRenameDrop(t *testing.T) {
+	var (
+		cfg    Config
+		logger bufLogger
+	)
+	vehicle := xml.Name{Space: "http://www.example.com/", Local: "Vehicle"}
+	cfg.Option(DefaultOptions...)
+	cfg.Option(LogOutput(&logger), LogLevel(1),
+		EmitValidators(),
+		FieldRename(vehicle, "validate", "ValidateFlag"),
+		FieldRename(vehicle, "class", "Category"),
+		FieldDrop(vehicle, "legacyCode"),
+		FieldDrop(vehicle, "comment"))
+	src := testSource(t, &cfg, `
+	  <complexType name="Vehicle">
+	    <sequence>
+	      <element name="validate" type="xs:boolean" />
+	      <element name="legacyCode" type="xs:string" />
+	      <element name="comment" type="xs:string" minOccurs="0" />
+	    </sequence>
+	    <attribute name="class" type="xs:string" />
+	  </complexType>`)
+	fields := structFields(t, src, "Vehicle")
+	want := map[string]string{
+		"ValidateFlag": "bool `xml:\"http://www.example.com/ validate\"`",
+		"Category":     "string `xml:\"class,attr\"`",
+	}
+	if len(fields) != len(want) {
+		t.Errorf("got fields %v, want %v", fields, want)
+	}
+	for name, v := range want {
+		if fields[name] != v {
+			t.Errorf("field %s is %q, want %q", name, fields[name], v)
+		}
+	}
+	log := logger.String()
+	if !strings.Contains(log, "dropping required element legacyCode") {
+		t.Errorf("no warning for dropping required element legacyCode:\n%s", log)
+	}
+	if strings.Contains(log, "comment") {
+		t.Errorf("warning for dropping optional element comment:\n%s", log)
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	billing := []byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        xmlns:b="http://www.example.com/billing"
+		        xmlns:s="http://www.example.com/shipping/v2"
+		        targetNamespace="http://www.example.com/billing">
+		  <import namespace="http://www.example.com/shipping/v2" />
+		  <complexType name="Address">
+		    <sequence>
+		      <element name="name" type="string" />
+		      <element name="account" type="string" />
+		    </sequence>
+		  </complexType>
+		  <complexType name="Invoice">
+		    <sequence>
+		      <element name="billTo" type="b:Address" />
+		      <element name="shipTo" type="s:Address" />
+		    </sequence>
+		  </complexType>
+		</schema>`)
+	shipping := []byte(`
+		<schema xmlns="http://www.w3.org/2001/XMLSchema"
+		        targetNamespace="http://www.example.com/shipping/v2">
+		  <complexType name="Address">
+		    <sequence>
+		      <element name="street" type="string" />
+		      <element name="city" type="string" />
+		    </sequence>
+		  </complexType>
+		</schema>`)
+	generate := func(opts ...Option) []byte {
+		schemas, err := xsd.Parse(billing, shipping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []*xsd.Schema
+		for i := range schemas {
+			list = append(list, &schemas[i])
+		}
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(LogOutput((*testLogger)(t)), LogLevel(1))
+		cfg.Option(opts...)
+		file, err := cfg.GenFromSchema(list...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := format.Node(&buf, token.NewFileSet(), file); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	check := func(src []byte, typ, field, want string) {
+		if got := structFields(t, src, typ)[field]; got != want {
+			t.Errorf("%s.%s is %q, want %q\n%s", typ, field, got, want, src)
+		}
+	}
+
+	src := generate()
+	check(src, "BillingAddress", "Account", "string `xml:\"http://www.example.com/billing account\"`")
+	check(src, "ShippingAddress", "City", "string `xml:\"http://www.example.com/shipping/v2 city\"`")
+	check(src, "Invoice", "BillTo", "BillingAddress `xml:\"http://www.example.com/billing billTo\"`")
+	check(src, "Invoice", "ShipTo", "ShippingAddress `xml:\"http://www.example.com/billing shipTo\"`")
+	for i := 0; i < 5; i++ {
+		if again := generate(); !bytes.Equal(again, src) {
+			t.Fatalf("output is not stable; got\n%s\nthen\n%s", src, again)
+		}
+	}
+
+	src = generate(Namespaces("http://www.example.com/billing"),
+		PackageForNamespace("http://www.example.com/shipping/v2", "example.com/shipping"))
+	check(src, "Address", "Account", "string `xml:\"http://www.example.com/billing account\"`")
+	check(src, "Invoice", "ShipTo", "shipping.Address `xml:\"http://www.example.com/billing shipTo\"`")
+}
+
+func TestAttributeDefaults(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	out := testRun(t, &cfg, `
+	  <complexType name="Weight">
+	    <simpleContent>
+	      <extension base="xs:decimal">
+	        <attribute name="unit" type="xs:string" default="kg" />
+	      </extension>
+	    </simpleContent>
+	  </complexType>
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <element name="sku" type="xs:string" />
+	    </sequence>
+	    <attribute name="quantity" type="xs:int" default="1" />
+	  </complexType>
+	  <complexType name="GiftItem">
+	    <complexContent>
+	      <extension base="tns:Item">
+	        <sequence>
+	          <element name="message" type="xs:string" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Parcel">
+	    <sequence>
+	      <element name="weight" type="tns:Weight" minOccurs="0" />
+	      <element name="gift" type="tns:GiftItem" minOccurs="0" />
+	    </sequence>
+	  </complexType>`, `
+		docs := []string{
+			"<Parcel xmlns='http://www.example.com/'><weight>2.5</weight>" +
+				"<gift><name>book</name><sku>b1</sku><message>hi</message></gift></Parcel>",
+			"<Parcel xmlns='http://www.example.com/'><weight unit='lb'>4</weight>" +
+				"<gift quantity='3'><name>pen</name><sku>p1</sku><message>yo</message></gift></Parcel>",
+			"<Parcel xmlns='http://www.example.com/'></Parcel>",
+		}
+		for _, doc := range docs {
+			var p Parcel
+			if err := xml.Unmarshal([]byte(doc), &p); err != nil {
+				panic(err)
+			}
+			fmt.Printf("%v %q %d %s %s\n", p.Weight.Decimal, p.Weight.Unit,
+				p.Gift.Quantity, p.Gift.Name, p.Gift.Message)
+		}
+	`)
+	want := "2.5 \"kg\" 1 book hi\n" +
+		"4 \"lb\" 3 pen yo\n" +
+		"0 \"\" 0"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestFieldByXMLName(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitFieldAccessorsByXMLName())
+	out := testRun(t, &cfg, `
+	  <complexType name="Account">
+	    <sequence>
+	      <element name="owner" type="xs:string" />
+	      <element name="balance" type="xs:int" />
+	    </sequence>
+	    <attribute name="currency" type="xs:string" />
+	  </complexType>
+	  <complexType name="SavingsAccount">
+	    <complexContent>
+	      <extension base="tns:Account">
+	        <sequence>
+	          <element name="rate" type="xs:double" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>`, `
+		a := SavingsAccount{Rate: 1.5}
+		a.Owner = "Alice"
+		a.Balance = 100
+		a.Currency = "EUR"
+		for _, name := range []xml.Name{
+			{Space: "http://www.example.com/", Local: "rate"},
+			{Space: "http://www.example.com/", Local: "balance"},
+			{Local: "currency"},
+			{Space: "http://www.example.com/", Local: "currency"},
+			{Local: "owner"},
+		} {
+			v, ok := a.FieldByXMLName(name)
+			fmt.Printf("%s %v %v\n", name.Local, v, ok)
+		}
+	`)
+	want := "rate 1.5 true\n" +
+		"balance 100 true\n" +
+		"currency EUR true\n" +
+		"currency <nil> false\n" +
+		"owner <nil> false"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestUnicodeNames(t *testing.T) {
+	const schema = `
+	  <complexType name="élève">
+	    <sequence>
+	      <element name="prénom" type="xs:string" />
+	      <element name="e&#x301;cole" type="xs:string" />
+	      <element name="名前" type="xs:string" />
+	      <element name="date-of-birth" type="xs:date" />
+	    </sequence>
+	  </complexType>`
+	tests := []struct {
+		name   string
+		opts   []Option
+		fields []string
+	}{
+		{"default", DefaultOptions,
+			[]string{"Prénom", "Ecole", "X名前", "Dateofbirth"}},
+		{"no replace", []Option{PackageName("ws")},
+			[]string{"Prénom", "Ecole", "X名前", "DateOfBirth"}},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		cfg.Option(tt.opts...)
+		src := testSource(t, &cfg, schema)
+		fields := structFields(t, src, "Élève")
+		if len(fields) != len(tt.fields) {
+			t.Errorf("%s: got fields %v, want %v", tt.name, fields, tt.fields)
+		}
+		for _, name := range tt.fields {
+			if !token.IsIdentifier(name) || !token.IsExported(name) {
+				t.Errorf("%s: %s is not a valid exported identifier", tt.name, name)
+			}
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s: no field %s in\n%s", tt.name, name, src)
+			}
+		}
+	}
+}
+
+func TestRestrictionNarrowsElementType(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `
+	  <complexType name="BaseItem">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	      <element name="label" type="xs:string" minOccurs="0" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="SpecialItem">
+	    <complexContent>
+	      <restriction base="tns:BaseItem">
+	        <sequence>
+	          <element name="id" type="xs:string" />
+	        </sequence>
+	      </restriction>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Container">
+	    <sequence>
+	      <element name="content" type="tns:BaseItem" />
+	      <element name="options">
+	        <complexType>
+	          <sequence>
+	            <element name="color" type="xs:string" minOccurs="0" />
+	            <element name="size" type="xs:int" minOccurs="0" />
+	          </sequence>
+	        </complexType>
+	      </element>
+	    </sequence>
+	  </complexType>
+	  <complexType name="SpecialContainer">
+	    <complexContent>
+	      <restriction base="tns:Container">
+	        <sequence>
+	          <element name="content" type="tns:SpecialItem" />
+	          <element name="options">
+	            <complexType>
+	              <sequence>
+	                <element name="color" type="xs:string" />
+	              </sequence>
+	            </complexType>
+	          </element>
+	        </sequence>
+	      </restriction>
+	    </complexContent>
+	  </complexType>`)
+	tests := []struct {
+		typ, field, want string
+	}{
+		{"Container", "Content", "BaseItem `xml:\"http://www.example.com/ content\"`"},
+		{"Container", "Options", "Options `xml:\"http://www.example.com/ options\"`"},
+		{"SpecialContainer", "Content", "SpecialItem `xml:\"http://www.example.com/ content\"`"},
+		{"SpecialContainer", "Options", "SpecialContainerOptions `xml:\"http://www.example.com/ options\"`"},
+		{"Options", "Size", "int `xml:\"http://www.example.com/ size\"`"},
+		{"SpecialContainerOptions", "Color", "string `xml:\"http://www.example.com/ color\"`"},
+	}
+	for _, tt := range tests {
+		if got := structFields(t, src, tt.typ)[tt.field]; got != tt.want {
+			t.Errorf("%s.%s is %q, want %q", tt.typ, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatedXMLSize(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitSize())
+	out := testRun(t, &cfg, `
+	  <complexType name="Line">
+	    <sequence>
+	      <element name="sku" type="xs:string" />
+	      <element name="qty" type="xs:int" />
+	      <element name="shipped" type="xs:date" minOccurs="0" />
+	    </sequence>
+	    <attribute name="status" type="xs:string" />
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="line" type="tns:Line" maxOccurs="unbounded" />
+	      <element name="note" type="xs:string" maxOccurs="unbounded" />
+	      <element name="urgent" type="xs:boolean" />
+	    </sequence>
+	  </complexType>`, `
+		o := Order{Customer: "Smith & Sons", Urgent: true}
+		for i := 0; i < 20; i++ {
+			o.Line = append(o.Line, Line{
+				Sku:     fmt.Sprintf("sku-%d", i*37),
+				Qty:     i * i,
+				Shipped: xsdDate(time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC)),
+				Status:  "open",
+			})
+		}
+		o.Note = []string{"call first", "leave at the door"}
+		data, err := xml.Marshal(&o)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(o.EstimatedXMLSize(), len(data))
+	`)
+	var estimate, actual int
+	if _, err := fmt.Sscan(out, &estimate, &actual); err != nil {
+		t.Fatalf("%v in %q", err, out)
+	}
+	// The estimate only differs from the actual length by the
+	// characters that xml.Marshal escapes, such as the &.
+	if diff := actual - estimate; diff < 0 || diff > actual/100 {
+		t.Errorf("estimated size %d, actual size %d", estimate, actual)
+	}
+}
+
+func TestFetchInstanceSchemas(t *testing.T) {
+	files := map[string]string{
+		"/schemas/orders.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        xmlns:c="http://www.example.net/common"
+			        targetNamespace="http://www.example.com/orders">
+			  <import namespace="http://www.example.net/common" schemaLocation="common.xsd" />
+			  <complexType name="Order">
+			    <sequence>
+			      <element name="id" type="string" />
+			      <element name="shipTo" type="c:Address" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+		"/schemas/common.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema"
+			        targetNamespace="http://www.example.net/common">
+			  <complexType name="Address">
+			    <sequence>
+			      <element name="street" type="string" />
+			      <element name="city" type="string" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+		"/schemas/notes.xsd": `
+			<schema xmlns="http://www.w3.org/2001/XMLSchema">
+			  <complexType name="Note">
+			    <sequence>
+			      <element name="author" type="string" />
+			      <element name="text" type="string" />
+			    </sequence>
+			  </complexType>
+			</schema>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	doc := []byte(`
+		<o:order xmlns:o="http://www.example.com/orders"
+		         xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+		         xsi:schemaLocation="http://www.example.com/orders ` + srv.URL + `/schemas/orders.xsd">
+		  <o:id>1</o:id>
+		  <note xsi:noNamespaceSchemaLocation="schemas/notes.xsd">
+		    <author>me</author>
+		  </note>
+		</o:order>`)
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	schemas, err := cfg.FetchInstanceSchemas(doc, srv.URL+"/instance.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := cfg.GenFromSchema(schemas...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), file); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.Bytes()
+	for _, name := range []string{"Order", "Address", "Note"} {
+		if len(structFields(t, src, name)) != 2 {
+			t.Errorf("no struct type %s with 2 fields in\n%s", name, src)
+		}
+	}
+
+	doc = []byte(`<order xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+		xsi:noNamespaceSchemaLocation="` + srv.URL + `/missing.xsd" />`)
+	if _, err := cfg.FetchInstanceSchemas(doc, ""); err == nil {
+		t.Error("no error fetching a schema that is not found")
+	}
+}
+
+func TestCoreType(t *testing.T) {
+	const schema = `
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	      <element name="comment" type="xs:string" minOccurs="0" />
+	      <element name="total" type="xs:int" />
+	      <element name="tag" type="xs:string" minOccurs="0" maxOccurs="unbounded" />
+	    </sequence>
+	    <attribute name="status" type="xs:string" use="required" />
+	    <attribute name="channel" type="xs:string" />
+	  </complexType>`
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(CoreType(xml.Name{Space: "http://www.example.com/", Local: "Order"}))
+	src := testSource(t, &cfg, schema)
+	core := structFields(t, src, "OrderCore")
+	if len(core) != 3 {
+		t.Errorf("OrderCore has fields %v, want Status, Id and Total", core)
+	}
+	for name, field := range core {
+		if strings.HasPrefix(field, "*") {
+			t.Errorf("OrderCore field %s is a pointer: %s", name, field)
+		}
+	}
+	if ext := structFields(t, src, "OrderExtensions"); len(ext) != 3 {
+		t.Errorf("OrderExtensions has fields %v, want Channel, Comment and Tag", ext)
+	}
+
+	cfg = Config{}
+	cfg.Option(DefaultOptions...)
+	cfg.Option(CoreType(xml.Name{Space: "http://www.example.com/", Local: "Order"}))
+	out := testRun(t, &cfg, schema, `
+		minimal := `+"`"+`<OrderCore status="new">`+
+		`<id xmlns="http://www.example.com/">A1</id>`+
+		`<total xmlns="http://www.example.com/">3</total>`+
+		`</OrderCore>`+"`"+`
+		var core OrderCore
+		if err := xml.Unmarshal([]byte(minimal), &core); err != nil {
+			panic(err)
+		}
+		data, err := xml.Marshal(core)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data) == minimal)
+
+		var order Order
+		order.OrderCore = core
+		order.Comment = "rush"
+		if data, err = xml.Marshal(order); err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data))
+		var decoded Order
+		if err := xml.Unmarshal(data, &decoded); err != nil {
+			panic(err)
+		}
+		fmt.Println(decoded.Id, decoded.Total, decoded.Comment, decoded.Status)
+	`)
+	want := "true\n" +
+		`<Order status="new" channel="">` +
+		`<id xmlns="http://www.example.com/">A1</id>` +
+		`<comment xmlns="http://www.example.com/">rush</comment>` +
+		`<total xmlns="http://www.example.com/">3</total>` +
+		"</Order>\n" +
+		"A1 3 rush new"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestCoreTypeOmitEmptyWrappers(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(OmitEmptyWrappers())
+	cfg.Option(CoreType(xml.Name{Space: "http://www.example.com/", Local: "Order"}))
+	out := testRun(t, &cfg, `
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="city" type="xs:string" minOccurs="0" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	      <element name="shipTo" type="tns:Address" minOccurs="0" />
+	      <element name="total" type="xs:int" />
+	    </sequence>
+	  </complexType>`, `
+		for _, shipTo := range []Address{{}, {City: "Paris"}} {
+			var order Order
+			order.Id, order.Total, order.ShipTo = "A1", 3, shipTo
+			data, err := xml.Marshal(order)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", data)
+		}
+	`)
+	// The empty address is left out, and the fields keep the order
+	// of the schema even though shipTo moved to OrderExtensions.
+	want := `<Order>` +
+		`<id xmlns="http://www.example.com/">A1</id>` +
+		`<total xmlns="http://www.example.com/">3</total>` +
+		"</Order>\n" +
+		`<Order>` +
+		`<id xmlns="http://www.example.com/">A1</id>` +
+		`<shipTo xmlns="http://www.example.com/"><city xmlns="http://www.example.com/">Paris</city></shipTo>` +
+		`<total xmlns="http://www.example.com/">3</total>` +
+		`</Order>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestElementNamedLikeType(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	out := testRun(t, &cfg, `
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	      <element name="total" type="xs:int" />
+	    </sequence>
+	  </complexType>
+	  <element name="Order">
+	    <complexType>
+	      <sequence>
+	        <element name="received" type="xs:string" />
+	        <element name="order" type="tns:Order" />
+	      </sequence>
+	    </complexType>
+	  </element>
+	  <complexType name="Batch">
+	    <sequence>
+	      <element ref="tns:Order" />
+	      <element name="count" type="xs:int" />
+	    </sequence>
+	  </complexType>`, `
+		var b Batch
+		b.Order = OrderElement{Received: "today", Order: Order{Id: "A1", Total: 3}}
+		b.Count = 1
+		data, err := xml.Marshal(b)
+		if err != nil {
+			panic(err)
+		}
+		var decoded Batch
+		if err := xml.Unmarshal(data, &decoded); err != nil {
+			panic(err)
+		}
+		fmt.Println(decoded.Order.Received, decoded.Order.Order.Id, decoded.Count)
+	`)
+	if want := "today A1 1"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestOmitEmptyWrappers(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(OmitEmptyWrappers())
+	out := testRun(t, &cfg, `
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="street" type="xs:string" minOccurs="0" />
+	      <element name="city" type="xs:string" minOccurs="0" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Contact">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	      <element name="address" type="tns:Address" minOccurs="0" />
+	      <element name="billing" type="tns:Address" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="PremiumContact">
+	    <complexContent>
+	      <extension base="tns:Contact">
+	        <sequence>
+	          <element name="level" type="xs:int" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>`, `
+		for _, v := range []interface{}{
+			Contact{Name: "a"},
+			Contact{Name: "b", Address: Address{City: "Paris"}},
+			PremiumContact{Contact: Contact{Name: "c"}, Level: 2},
+		} {
+			data, err := xml.Marshal(v)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", data)
+		}
+	`)
+	// Elements of simple types are written even if they are empty,
+	// as before; only the empty optional address is left out.
+	billing := `<billing xmlns="http://www.example.com/">` +
+		`<street xmlns="http://www.example.com/"></street>` +
+		`<city xmlns="http://www.example.com/"></city>` +
+		`</billing>`
+	want := `<Contact>` +
+		`<name xmlns="http://www.example.com/">a</name>` + billing +
+		"</Contact>\n" +
+		`<Contact>` +
+		`<name xmlns="http://www.example.com/">b</name>` +
+		`<address xmlns="http://www.example.com/">` +
+		`<street xmlns="http://www.example.com/"></street>` +
+		`<city xmlns="http://www.example.com/">Paris</city>` +
+		`</address>` + billing +
+		"</Contact>\n" +
+		`<PremiumContact>` +
+		`<name xmlns="http://www.example.com/">c</name>` + billing +
+		`<level xmlns="http://www.example.com/">2</level>` +
+		`</PremiumContact>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestDeepSchema(t *testing.T) {
+	// A chain of types hundreds of levels deep, whose last type
+	// refers back to the first.
+	const depth = 800
+	var buf bytes.Buffer
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&buf, `
+		  <complexType name="Level%d">
+		    <sequence>
+		      <element name="name" type="xs:string" />
+		      <element name="child" type="tns:Level%d" minOccurs="0" maxOccurs="unbounded" />
+		    </sequence>
+		  </complexType>`, i, (i+1)%depth)
+	}
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, buf.String())
+	for _, i := range []int{0, depth / 2, depth - 1} {
+		name := fmt.Sprintf("Level%d", i)
+		want := fmt.Sprintf("[]Level%d `xml:\"http://www.example.com/ child\"`", (i+1)%depth)
+		if got := structFields(t, src, name)["Child"]; got != want {
+			t.Errorf("%s.Child is %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTypedWildcards(t *testing.T) {
+	schema := `
+	  <element name="order" type="tns:Order" />
+	  <element name="invoice" type="tns:Invoice" />
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="id" type="xs:int" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Invoice">
+	    <sequence>
+	      <element name="total" type="xs:decimal" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Envelope">
+	    <sequence>
+	      <element name="from" type="xs:string" />
+	      <any namespace="##targetNamespace" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(TypedWildcards())
+	out := testRun(t, &cfg, schema, `
+		var env Envelope
+		doc := `+"`"+`<Envelope xmlns="http://www.example.com/">
+		  <from>alice</from>
+		  <order><id>7</id></order>
+		  <unknown>skipped</unknown>
+		  <invoice><total>12.5</total></invoice>
+		</Envelope>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &env); err != nil {
+			panic(err)
+		}
+		for _, item := range env.Items {
+			switch item := item.(type) {
+			case *Order:
+				fmt.Println("order", item.Id)
+			case *Invoice:
+				fmt.Println("invoice", item.Total)
+			}
+		}
+		data, err := xml.Marshal(Envelope{From: "bob", Items: EnvelopeItems{&Invoice{Total: 3}}})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", data)
+	`)
+	want := "order 7\ninvoice 12.5\n" +
+		`<Envelope>` +
+		`<invoice xmlns="http://www.example.com/"><total xmlns="http://www.example.com/">3</total></invoice>` +
+		`<from xmlns="http://www.example.com/">bob</from>` +
+		`</Envelope>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// A top-level element of a simple type has no type of its
+	// own to decode into, so the wildcard is left as it was.
+	cfg = Config{}
+	cfg.Option(DefaultOptions...)
+	cfg.Option(TypedWildcards())
+	src := testSource(t, &cfg, schema+`<element name="note" type="xs:string" />`)
+	if got, want := structFields(t, src, "Envelope")["Items"], "[]string `xml:\",any\"`"; got != want {
+		t.Errorf("Envelope.Items is %s, want %s", got, want)
+	}
+}
+
+func TestNamespaceConstants(t *testing.T) {
+	file, err := ioutil.TempFile("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitNamespaceConstants())
+	const ns = "http://dyomedea.com/ns/library"
+	if err := cfg.GenCLI("-o", file.Name(), "-ns", ns, "testdata/library.xsd"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `const NamespaceLibrary = "` + ns + `"`; !strings.Contains(string(src), want) {
+		t.Errorf("generated source does not contain %s\n%s", want, src)
+	}
+}
+
+func TestAnyTypeElement(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	out := testRun(t, &cfg, `
+	  <complexType name="Note">
+	    <sequence>
+	      <element name="title" type="xs:string" />
+	      <element name="body" type="xs:anyType" />
+	      <element name="extra" minOccurs="0" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`, `
+		var note Note
+		doc := `+"`"+`<Note xmlns="http://www.example.com/">`+
+		`<title>hello</title>`+
+		`<body xmlns:h="http://www.w3.org/1999/xhtml" h:class="rich" lang="en">`+
+		`<h:p>some <h:b>bold</h:b> text</h:p><!-- kept --><h:br/>`+
+		`</body>`+
+		`<extra><a><b>1</b></a></extra>`+
+		`<extra>plain</extra>`+
+		`</Note>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &note); err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", note.Body.InnerXML)
+		fmt.Println(len(note.Body.Attr), len(note.Extra))
+		data, err := xml.Marshal(note)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", data)
+	`)
+	want := `<h:p>some <h:b>bold</h:b> text</h:p><!-- kept --><h:br/>` + "\n" +
+		"3 2\n" +
+		`<Note>` +
+		`<title xmlns="http://www.example.com/">hello</title>` +
+		`<body xmlns="http://www.example.com/" xmlns:h="http://www.w3.org/1999/xhtml" h:class="rich" lang="en">` +
+		`<h:p>some <h:b>bold</h:b> text</h:p><!-- kept --><h:br/>` +
+		`</body>` +
+		`<extra xmlns="http://www.example.com/"><a><b>1</b></a></extra>` +
+		`<extra xmlns="http://www.example.com/">plain</extra>` +
+		`</Note>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestSchemaAttributeOrder(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(SchemaAttributeOrder())
+	out := testRun(t, &cfg, `
+	  <complexType name="Record">
+	    <sequence>
+	      <element name="data" type="xs:string" />
+	    </sequence>
+	    <attribute name="serial" type="xs:string" />
+	    <attribute name="version" type="xs:int" />
+	    <attribute name="lang" type="xs:string" />
+	    <attribute name="signed" type="xs:boolean" />
+	  </complexType>
+	  <complexType name="SignedRecord">
+	    <complexContent>
+	      <restriction base="tns:Record">
+	        <sequence>
+	          <element name="data" type="xs:string" />
+	        </sequence>
+	        <attribute name="signed" type="xs:boolean" use="required" />
+	        <attribute name="lang" type="xs:string" />
+	        <attribute name="serial" type="xs:string" use="required" />
+	      </restriction>
+	    </complexContent>
+	  </complexType>`, `
+		for _, v := range []interface{}{
+			Record{Serial: "1", Version: 2, Lang: "en", Signed: true, Data: "a"},
+			SignedRecord{Serial: "1", Version: 2, Lang: "en", Signed: true, Data: "a"},
+		} {
+			data, err := xml.Marshal(v)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", data)
+		}
+	`)
+	attrs := ` serial="1" version="2" lang="en" signed="true">` +
+		`<data xmlns="http://www.example.com/">a</data>`
+	want := `<Record` + attrs + "</Record>\n" +
+		`<SignedRecord` + attrs + "</SignedRecord>"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestSchemaAttributeOrderWrappers(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(SchemaAttributeOrder(), OmitEmptyWrappers())
+	out := testRun(t, &cfg, `
+	  <complexType name="Meta">
+	    <sequence>
+	      <element name="author" type="xs:string" minOccurs="0" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Note">
+	    <sequence>
+	      <element name="body" type="xs:string" />
+	      <element name="meta" type="tns:Meta" minOccurs="0" />
+	    </sequence>
+	    <attribute name="serial" type="xs:string" />
+	    <attribute name="lang" type="xs:string" />
+	  </complexType>
+	  <complexType name="LocalNote">
+	    <complexContent>
+	      <restriction base="tns:Note">
+	        <sequence>
+	          <element name="body" type="xs:string" />
+	          <element name="meta" type="tns:Meta" minOccurs="0" />
+	        </sequence>
+	        <attribute name="lang" type="xs:string" use="required" />
+	      </restriction>
+	    </complexContent>
+	  </complexType>`, `
+		data, err := xml.Marshal(LocalNote{Serial: "n1", Lang: "en", Body: "hi"})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", data)
+	`)
+	want := `<LocalNote serial="n1" lang="en">` +
+		`<body xmlns="http://www.example.com/">hi</body>` +
+		`</LocalNote>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestValidateOnDecode(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(ValidateOnDecode())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Code">
+	    <restriction base="xs:string">
+	      <maxLength value="3" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="code" type="tns:Code" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="item" type="tns:Item" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`, `
+		var order Order
+		doc := `+"`"+`<Order xmlns="http://www.example.com/">`+"`"+` + "\n" +
+			"<customer>Alice</customer>\n" +
+			"<item><code>abc</code></item>\n" +
+			"<item><code>abcd</code></item>\n" +
+			"<item><code>toolong</code></item>\n" +
+			"</Order>"
+		err := xml.Unmarshal([]byte(doc), &order)
+		fmt.Println(err)
+		fmt.Println(len(order.Item))
+	`)
+	want := "element item at line 4, column 7: Code: length 4 exceeds the maximum length 3\n1"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+// Types with an UnmarshalXML method of their own, such as one that
+// sets attribute defaults, are decoded with it before they are
+// validated.
+func TestValidateOnDecodeDefaults(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(ValidateOnDecode())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Currency">
+	    <restriction base="xs:string">
+	      <enumeration value="EUR" />
+	      <enumeration value="USD" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Price">
+	    <sequence>
+	      <element name="amount" type="xs:decimal" />
+	    </sequence>
+	    <attribute name="currency" type="tns:Currency" default="EUR" />
+	  </complexType>`, `
+		for _, doc := range []string{
+			`+"`"+`<Price xmlns="http://www.example.com/"><amount>1</amount></Price>`+"`"+`,
+			`+"`"+`<Price xmlns="http://www.example.com/" currency="GBP"><amount>1</amount></Price>`+"`"+`,
+		} {
+			var p Price
+			err := xml.Unmarshal([]byte(doc), &p)
+			fmt.Println(p.Currency, err != nil)
+		}
+	`)
+	if want := "EUR false\nGBP true"; out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+// Valid documents that leave out optional elements decode without
+// error.
+func TestValidateOnDecodeOptional(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(ValidateOnDecode())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Code">
+	    <restriction base="xs:string">
+	      <pattern value="[A-C]" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="street" type="xs:string" />
+	      <element name="code" type="tns:Code" minOccurs="0" />
+	    </sequence>
+	    <attribute name="kind" type="xs:string" />
+	  </complexType>
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="ship" type="tns:Address" minOccurs="0" />
+	    </sequence>
+	  </complexType>`, `
+		for _, doc := range []string{
+			`+"`"+`<Address xmlns="http://www.example.com/" kind="red"><street>Main</street></Address>`+"`"+`,
+			`+"`"+`<Address xmlns="http://www.example.com/"><street>Main</street><code>D</code></Address>`+"`"+`,
+		} {
+			var a Address
+			fmt.Println(xml.Unmarshal([]byte(doc), &a))
+		}
+		var o Order
+		err := xml.Unmarshal([]byte(`+"`"+`<Order xmlns="http://www.example.com/"><customer>Alice</customer></Order>`+"`"+`), &o)
+		fmt.Println(o.Customer, err)
+	`)
+	want := "<nil>\n" +
+		`element Address at line 1, column 42: Code: "D" does not match the pattern [A-C]` + "\n" +
+		"Alice <nil>"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestEmbeddedSchemas(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src, err := cfg.GenSource("testdata/orders.wsdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := structFields(t, src, "Order")
+	if want := "Address `xml:\"http://example.com/orders shipTo\"`"; order["ShipTo"] != want {
+		t.Errorf("Order.ShipTo is %s, want %s", order["ShipTo"], want)
+	}
+	address := structFields(t, src, "Address")
+	if want := "string `xml:\"http://example.com/common city\"`"; address["City"] != want {
+		t.Errorf("Address.City is %s, want %s\n%s", address["City"], want, src)
+	}
+}
+
+func TestSkipNamespace(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(SkipNamespace("http://example.com/signature"))
+	out := testRun(t, &cfg, `
+	  <import namespace="http://example.com/signature" />
+	  <complexType name="Document">
+	    <sequence>
+	      <element name="body" type="xs:string" />
+	      <element name="signature" type="sig:Signature" xmlns:sig="http://example.com/signature" />
+	    </sequence>
+	    <attribute name="algorithm" type="sig:Algorithm" xmlns:sig="http://example.com/signature" />
+	  </complexType>`, `
+		doc := `+"`"+`<Document xmlns="http://www.example.com/" algorithm="sha1">`+
+		`<body>text</body>`+
+		`<signature><DigestValue xmlns="http://example.com/signature">AAAA</DigestValue></signature>`+
+		`</Document>`+"`"+`
+		var v Document
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			panic(err)
+		}
+		var algorithm string = v.Algorithm
+		fmt.Println(algorithm, strings.TrimSpace(string(v.Signature.InnerXML)))
+	`, "testdata/signature.xsd")
+	want := `sha1 <DigestValue xmlns="http://example.com/signature">AAAA</DigestValue>`
+	if out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+	src := testSource(t, &cfg, `
+	  <import namespace="http://example.com/signature" />
+	  <element name="signature" type="sig:Signature" xmlns:sig="http://example.com/signature" />`,
+		"testdata/signature.xsd")
+	for _, name := range []string{"type Signature", "type DigestValue", "type Algorithm"} {
+		if bytes.Contains(src, []byte(name)) {
+			t.Errorf("%s is declared, but its namespace is skipped\n%s", name, src)
+		}
+	}
+
+	// Types derived from the types of a skipped namespace are
+	// derived from the built-in types in their place.
+	out = testRun(t, &cfg, `
+	  <import namespace="http://example.com/signature" />
+	  <complexType name="SignedNote">
+	    <complexContent>
+	      <extension base="sig:Signature" xmlns:sig="http://example.com/signature">
+	        <sequence>
+	          <element name="note" type="xs:string" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="VersionedAlgorithm">
+	    <simpleContent>
+	      <extension base="sig:Algorithm" xmlns:sig="http://example.com/signature">
+	        <attribute name="version" type="xs:int" />
+	      </extension>
+	    </simpleContent>
+	  </complexType>`, `
+		var note SignedNote
+		doc := `+"`"+`<SignedNote xmlns="http://www.example.com/"><note>hi</note></SignedNote>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &note); err != nil {
+			panic(err)
+		}
+		var algorithm VersionedAlgorithm
+		doc = `+"`"+`<VersionedAlgorithm version="2">sha1</VersionedAlgorithm>`+"`"+`
+		if err := xml.Unmarshal([]byte(doc), &algorithm); err != nil {
+			panic(err)
+		}
+		fmt.Println(note.Note, algorithm.Version, algorithm.AnyURI)
+	`, "testdata/signature.xsd")
+	if want := "hi 2 sha1"; out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+func TestRoundTripTest(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitRoundTripTest())
+	out := testRun(t, &cfg, `
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="customer" type="xs:string" />
+	      <element name="note" type="xs:string" />
+	    </sequence>
+	    <attribute name="number" type="xs:int" />
+	  </complexType>
+	  <element name="order" type="tns:Order" />`, `
+		root := xml.Name{Space: "http://www.example.com/", Local: "order"}
+		for _, doc := range []string{
+			`+"`"+`<t:order xmlns:t="http://www.example.com/" number="7">
+			  <t:customer>Alice</t:customer>
+			  <t:note>Ring twice</t:note>
+			</t:order>`+"`"+`,
+			`+"`"+`<order xmlns="http://www.example.com/" number="7" rush="yes">`+
+		`<customer>Alice</customer><note>Ring twice</note><gift>yes</gift>`+
+		`</order>`+"`"+`,
+		} {
+			ok, diff, err := RoundTripOK([]byte(doc), root)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%v\n%s", ok, diff)
+		}
+	`)
+	want := "true\n" +
+		"false\n" +
+		"--- a\n" +
+		"+++ b\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		`-<{http://www.example.com/}order number="7" rush="yes">` + "\n" +
+		`+<{http://www.example.com/}order number="7">` + "\n" +
+		"   <{http://www.example.com/}customer>\n" +
+		`     "Alice"` + "\n" +
+		"@@ -6,6 +6,3 @@\n" +
+		`     "Ring twice"` + "\n" +
+		"   </{http://www.example.com/}note>\n" +
+		"-  <{http://www.example.com/}gift>\n" +
+		`-    "yes"` + "\n" +
+		"-  </{http://www.example.com/}gift>\n" +
+		" </{http://www.example.com/}order>"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestEmptyElements(t *testing.T) {
+	const schema = `
+	  <complexType name="Marker" />
+	  <complexType name="Entry">
+	    <sequence>
+	      <element name="title" type="xs:string" />
+	      <element name="deleted" type="tns:Marker" minOccurs="0" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		for _, doc := range []string{
+			` + "`" + `<Entry xmlns="http://www.example.com/"><title>a</title><deleted/></Entry>` + "`" + `,
+			` + "`" + `<Entry xmlns="http://www.example.com/"><title>b</title></Entry>` + "`" + `,
+		} {
+			var entry Entry
+			if err := xml.Unmarshal([]byte(doc), &entry); err != nil {
+				panic(err)
+			}
+			data, err := xml.Marshal(entry)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%v %s\n", !reflect.ValueOf(entry.Deleted).IsZero(), data)
+		}
+	`
+	want := `true <Entry><title xmlns="http://www.example.com/">a</title><deleted xmlns="http://www.example.com/"></deleted></Entry>` + "\n" +
+		`false <Entry><title xmlns="http://www.example.com/">b</title></Entry>`
+
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, schema)
+	if got := structFields(t, src, "Entry")["Deleted"]; !strings.HasPrefix(got, "*struct{} ") {
+		t.Errorf("Deleted field is %s, want *struct{}", got)
+	}
+	out := testRun(t, &cfg, schema, main)
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	cfg = Config{}
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmptyElementsAsBool())
+	out = testRun(t, &cfg, schema, main)
+	if out != want {
+		t.Errorf("with EmptyElementsAsBool, got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestFieldMarshalers(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitFieldMarshalers())
+	out := testRun(t, &cfg, `
+	  <complexType name="Message">
+	    <sequence>
+	      <element name="sender" type="xs:string" />
+	    </sequence>
+	    <attribute name="lang" type="xs:string" />
+	  </complexType>
+	  <complexType name="Invoice">
+	    <complexContent>
+	      <extension base="tns:Message">
+	        <sequence>
+	          <element name="line" type="xs:string" maxOccurs="unbounded" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>`, `
+		v := Invoice{Line: []string{"a", "b & c"}}
+		v.Sender, v.Lang = "Bob", "en"
+		for _, name := range []string{"Sender", "Lang", "Line", "Total"} {
+			data, err := v.MarshalField(name)
+			fmt.Printf("%s %v\n", data, err)
+		}
+	`)
+	want := `<sender xmlns="http://www.example.com/">Bob</sender> <nil>` + "\n" +
+		`lang="en" <nil>` + "\n" +
+		`<line xmlns="http://www.example.com/">a</line><line xmlns="http://www.example.com/">b &amp; c</line> <nil>` + "\n" +
+		` Invoice has no field "Total"`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	const schema = `
+	  <simpleType name="Level">
+	    <restriction base="xs:short" />
+	  </simpleType>
+	  <complexType name="Reading">
+	    <sequence>
+	      <element name="temp" type="xs:short" />
+	      <element name="count" type="xs:unsignedByte" />
+	      <element name="level" type="tns:Level" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		for _, doc := range []string{
+			` + "`" + `<Reading xmlns="http://www.example.com/"><temp>70000</temp><count>1</count><level>1</level></Reading>` + "`" + `,
+			` + "`" + `<Reading xmlns="http://www.example.com/"><temp>1</temp><count>300</count><level>1</level></Reading>` + "`" + `,
+			` + "`" + `<Reading xmlns="http://www.example.com/"><temp>-70000</temp><count>-1</count><level>1</level></Reading>` + "`" + `,
+		} {
+			var r Reading
+			if err := xml.Unmarshal([]byte(doc), &r); err != nil {
+				fmt.Println("error")
+				continue
+			}
+			fmt.Println(r.Temp, r.Count)
+		}
+	`
+	tests := []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{0, "70000 1\nerror\nerror"},
+		{OverflowError, "error\nerror\nerror"},
+		{OverflowClamp, "32767 1\n1 255\n-32768 0"},
+		{OverflowSaturate, "32767 1\n1 255\n-32768 0"},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		if tt.policy != 0 {
+			cfg.Option(IntegerOverflow(tt.policy))
+		}
+		if out := testRun(t, &cfg, schema, main); out != tt.want {
+			t.Errorf("policy %d: got\n%s\nwant\n%s", tt.policy, out, tt.want)
+		}
+	}
+}
+
+// Saturate only limits values to the size of their type, and keeps
+// values outside of the value space of xs:nonNegativeInteger.
+func TestIntegerOverflowUnsized(t *testing.T) {
+	const schema = `
+	  <complexType name="Stock">
+	    <sequence>
+	      <element name="count" type="xs:nonNegativeInteger" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		var s Stock
+		err := xml.Unmarshal([]byte(` + "`" + `<Stock xmlns="http://www.example.com/"><count>-5</count></Stock>` + "`" + `), &s)
+		fmt.Println(s.Count, err)
+	`
+	tests := []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{OverflowError, "0 nonNegativeInteger -5 is out of the range [0, 9223372036854775807]"},
+		{OverflowClamp, "0 <nil>"},
+		{OverflowSaturate, "-5 <nil>"},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(IntegerOverflow(tt.policy))
+		if out := testRun(t, &cfg, schema, main); out != tt.want {
+			t.Errorf("policy %d: got\n%s\nwant\n%s", tt.policy, out, tt.want)
+		}
+	}
+}
+
+// With EmitTextMarshalers, simple types derived from the integer
+// types use the methods of the overflow types.
+func TestIntegerOverflowTextMarshalers(t *testing.T) {
+	const schema = `
+	  <simpleType name="Level">
+	    <restriction base="xs:short" />
+	  </simpleType>
+	  <complexType name="Reading">
+	    <sequence>
+	      <element name="level" type="tns:Level" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		var r Reading
+		if err := xml.Unmarshal([]byte(` + "`" + `<Reading xmlns="http://www.example.com/"><level>70000</level></Reading>` + "`" + `), &r); err != nil {
+			fmt.Println("error")
+			return
+		}
+		out, err := xml.Marshal(r)
+		fmt.Println(string(out), err)
+	`
+	tests := []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{OverflowError, "error"},
+		{OverflowClamp, `<Reading><level xmlns="http://www.example.com/">32767</level></Reading> <nil>`},
+		{OverflowSaturate, `<Reading><level xmlns="http://www.example.com/">32767</level></Reading> <nil>`},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(IntegerOverflow(tt.policy), EmitTextMarshalers())
+		if out := testRun(t, &cfg, schema, main); out != tt.want {
+			t.Errorf("policy %d: got\n%s\nwant\n%s", tt.policy, out, tt.want)
+		}
+		// ndfdXML.xsd derives a simpleType from xs:integer.
+		cfg = Config{}
+		cfg.Option(DefaultOptions...)
+		cfg.Option(IntegerOverflow(tt.policy), EmitTextMarshalers())
+		testRun(t, &cfg, `<import namespace="http://graphical.weather.gov/xml/DWMLgen/schema/DWML.xsd" />`, ``, "testdata/ndfdXML.xsd")
+	}
+}
+
+func TestByteOverflow(t *testing.T) {
+	const schema = `
+	  <complexType name="Offset">
+	    <sequence>
+	      <element name="delta" type="xs:byte" />
+	    </sequence>
+	  </complexType>`
+	const main = `
+		for _, delta := range []string{"-128", "127", "200", "-200"} {
+			var o Offset
+			doc := "<Offset xmlns=\"http://www.example.com/\"><delta>" + delta + "</delta></Offset>"
+			if err := xml.Unmarshal([]byte(doc), &o); err != nil {
+				fmt.Println("error")
+				continue
+			}
+			fmt.Println(o.Delta)
+		}
+	`
+	tests := []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{OverflowError, "-128\n127\nerror\nerror"},
+		{OverflowClamp, "-128\n127\n127\n-128"},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(IntegerOverflow(tt.policy))
+		if out := testRun(t, &cfg, schema, main); out != tt.want {
+			t.Errorf("policy %d: got\n%s\nwant\n%s", tt.policy, out, tt.want)
+		}
+	}
+}
+
+func TestRepeatingGroup(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(KeepRepeatingGroups())
+	out := testRun(t, &cfg, `<import namespace="http://example.com/series" />`, `
+		doc := `+"`"+`<series xmlns="http://example.com/series"><name>rain</name>`+
+		`<date>2020-01-01</date><value>1.5</value><note>estimated</note>`+
+		`<date>2020-01-02</date><value>0</value>`+
+		`<date>2020-01-03</date><value>3</value>`+
+		`<unit>mm</unit></series>`+"`"+`
+		var v Series
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			panic(err)
+		}
+		fmt.Println(v.Name, v.Unit)
+		for _, g := range v.Groups {
+			fmt.Println(time.Time(g.Date).Format("Jan 2"), g.Value, g.Note)
+		}
+		data, err := xml.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		var back Series
+		if err := xml.Unmarshal(data, &back); err != nil {
+			panic(err)
+		}
+		fmt.Println(reflect.DeepEqual(v, back))
+	`, "testdata/series.xsd")
+	want := "rain mm\n" +
+		"Jan 1 1.5 estimated\n" +
+		"Jan 2 0 \n" +
+		"Jan 3 3 \n" +
+		"true"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// Without the option, each element of the group has a slice.
+	var plain Config
+	plain.Option(DefaultOptions...)
+	src := testSource(t, &plain, `<import namespace="http://example.com/series" />`, "testdata/series.xsd")
+	fields := structFields(t, src, "Series")
+	if _, ok := fields["Groups"]; ok {
+		t.Errorf("Series has a Groups field without KeepRepeatingGroups: %v", fields)
+	}
+	if got := fields["Date"]; !strings.HasPrefix(got, "[]") {
+		t.Errorf("Date field has type %q, want a slice", got)
+	}
+}
+
+// A type whose only content is a repeating group is not flattened to
+// a slice, which would lose its UnmarshalXML method.
+func TestRepeatingGroupOnly(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(KeepRepeatingGroups())
+	out := testRun(t, &cfg, `
+	  <complexType name="Readings">
+	    <sequence>
+	      <sequence maxOccurs="unbounded">
+	        <element name="date" type="xs:date" />
+	        <element name="value" type="xs:decimal" minOccurs="0" />
+	      </sequence>
+	    </sequence>
+	  </complexType>
+	  <element name="readings" type="tns:Readings" />`, `
+		doc := `+"`"+`<readings xmlns="http://www.example.com/">`+
+		`<date>2020-01-01</date><value>1.5</value>`+
+		`<date>2020-01-02</date>`+
+		`<date>2020-01-03</date><value>3</value></readings>`+"`"+`
+		var v Readings
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			panic(err)
+		}
+		for _, g := range v.Groups {
+			fmt.Println(time.Time(g.Date).Format("Jan 2"), g.Value)
+		}
+		data, err := xml.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		var back Readings
+		if err := xml.Unmarshal(data, &back); err != nil {
+			panic(err)
+		}
+		fmt.Println(reflect.DeepEqual(v, back))
+	`)
+	want := "Jan 1 1.5\n" +
+		"Jan 2 0\n" +
+		"Jan 3 3\n" +
+		"true"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+// The UnmarshalXML method that keeps a group together cannot decode
+// types in an extension hierarchy: it would be promoted to the
+// derived type, or skip the decoding of the base type. Rather than
+// split the group's elements into slices of their own, generating
+// code fails.
+func TestRepeatingGroupExtended(t *testing.T) {
+	const group = `
+	  <sequence maxOccurs="unbounded">
+	    <element name="date" type="xs:date" />
+	    <element name="value" type="xs:decimal" />
+	  </sequence>`
+	tests := []struct {
+		schema, want string
+	}{
+		{
+			schema: `
+			  <complexType name="Series">
+			    <sequence>
+			      <element name="name" type="xs:string" />
+			      ` + group + `
+			    </sequence>
+			  </complexType>
+			  <complexType name="LabeledSeries">
+			    <complexContent>
+			      <extension base="tns:Series">
+			        <sequence>
+			          <element name="label" type="xs:string" />
+			        </sequence>
+			      </extension>
+			    </complexContent>
+			  </complexType>`,
+			want: "complexType Series: cannot keep the elements of its repeating groups together, because it is extended by another type",
+		},
+		{
+			schema: `
+			  <complexType name="Named">
+			    <sequence>
+			      <element name="name" type="xs:string" />
+			    </sequence>
+			  </complexType>
+			  <complexType name="Series">
+			    <complexContent>
+			      <extension base="tns:Named">` + group + `
+			      </extension>
+			    </complexContent>
+			  </complexType>`,
+			want: "complexType Series: cannot keep the elements of its repeating groups together, because it extends Named",
+		},
+	}
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, tt := range tests {
+		filename := filepath.Join(dir, fmt.Sprintf("schema%d.xsd", i))
+		if err := ioutil.WriteFile(filename, []byte(fmt.Sprintf(testSchema, tt.schema)), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var cfg Config
+		cfg.Option(DefaultOptions...)
+		cfg.Option(KeepRepeatingGroups())
+		if _, err := cfg.GenSource(filename); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got error %v, want %s", err, tt.want)
+		}
+
+		// Without the option, each element of the group has a
+		// slice, as before.
+		var plain Config
+		plain.Option(DefaultOptions...)
+		src := testSource(t, &plain, tt.schema)
+		if got := structFields(t, src, "Series")["Date"]; !strings.HasPrefix(got, "[]") {
+			t.Errorf("Date field has type %q, want a slice", got)
+		}
+	}
+}
+
+func TestImportedAttributeType(t *testing.T) {
+	const schema = `
+	  <import namespace="http://example.com/codes" />
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	    </sequence>
+	    <attribute name="code" type="c:CodeType" xmlns:c="http://example.com/codes" />
+	    <attributeGroup ref="c:Codes" xmlns:c="http://example.com/codes" />
+	  </complexType>`
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitEnumConstants(), EmitValidators())
+	fields := structFields(t, testSource(t, &cfg, schema, "testdata/codes.xsd"), "Item")
+	for _, name := range []string{"Code", "Primary"} {
+		if got := strings.Fields(fields[name]); len(got) == 0 || got[0] != "CodeType" {
+			t.Errorf("field %s is %q, want type CodeType", name, fields[name])
+		}
+	}
+	out := testRun(t, &cfg, schema, `
+		for _, code := range []CodeType{CodeTypeA1, "C3"} {
+			v := Item{Name: "widget", Code: code, Primary: CodeTypeB2}
+			fmt.Println(v.Validate())
+		}
+	`, "testdata/codes.xsd")
+	want := "<nil>\n" + `Code: CodeType: "C3" is not one of the allowed values`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestElementNames(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitElementNames())
+	out := testRun(t, &cfg, `
+	  <complexType name="Order">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <element name="order" type="tns:Order" />
+	  <element name="purchase-order" type="tns:Order" />`, `
+		for _, name := range []xml.Name{ElemOrder, ElemPurchaseOrder} {
+			fmt.Printf("%s %q %q\n", NamespaceWwwExampleCom, name.Space, name.Local)
+		}
+	`)
+	want := `http://www.example.com/ "http://www.example.com/" "order"` + "\n" +
+		`http://www.example.com/ "http://www.example.com/" "purchase-order"`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestLenientNamespaces(t *testing.T) {
+	schema := `
+	  <complexType name="Base">
+	    <sequence>
+	      <element name="id" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Order">
+	    <complexContent>
+	      <extension base="tns:Base">
+	        <sequence>
+	          <element name="item" type="tns:Item" maxOccurs="unbounded" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Item">
+	    <sequence>
+	      <element name="sku" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <element name="order" type="tns:Order" />`
+	main := `
+		NamespaceMismatch = func(got xml.Name, want string) {
+			fmt.Printf("%s %q\n", got.Local, got.Space)
+		}
+		var v Order
+		doc := "<order><id>7</id><item><sku>a</sku></item><item><sku>b</sku></item></order>"
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			panic(err)
+		}
+		fmt.Printf("%q %d", v.Id, len(v.Item))
+		for _, item := range v.Item {
+			fmt.Printf(" %q", item.Sku)
+		}
+	`
+	var strict Config
+	strict.Option(DefaultOptions...)
+	out := testRun(t, &strict, schema, strings.Replace(main, "NamespaceMismatch = ", "_ = ", 1))
+	if want := `"" 0`; out != want {
+		t.Errorf("strict: got %s, want %s", out, want)
+	}
+
+	var lenient Config
+	lenient.Option(DefaultOptions...)
+	lenient.Option(LenientNamespaces())
+	out = testRun(t, &lenient, schema, main)
+	want := `id ""` + "\n" +
+		`item ""` + "\n" +
+		`sku ""` + "\n" +
+		`item ""` + "\n" +
+		`sku ""` + "\n" +
+		`"7" 2 "a" "b"`
+	if out != want {
+		t.Errorf("lenient: got\n%s\nwant\n%s", out, want)
+	}
+}
+
+// LenientNamespaces keeps the UnmarshalXML methods that types already
+// have, and is combined with ValidateOnDecode.
+func TestLenientNamespacesComposed(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(LenientNamespaces(), ValidateOnDecode())
+	out := testRun(t, &cfg, `
+	  <simpleType name="Currency">
+	    <restriction base="xs:string">
+	      <enumeration value="EUR" />
+	      <enumeration value="USD" />
+	    </restriction>
+	  </simpleType>
+	  <complexType name="Price">
+	    <sequence>
+	      <element name="amount" type="xs:int" />
+	    </sequence>
+	    <attribute name="currency" type="tns:Currency" default="EUR" />
+	  </complexType>`, `
+		NamespaceMismatch = nil
+		for _, doc := range []string{
+			`+"`"+`<Price xmlns="http://www.example.com/"><amount xmlns="">2</amount></Price>`+"`"+`,
+			`+"`"+`<Price xmlns="http://www.example.com/" currency="GBP"><amount xmlns="">3</amount></Price>`+"`"+`,
+		} {
+			var p Price
+			err := xml.Unmarshal([]byte(doc), &p)
+			fmt.Println(p.Amount, p.Currency, err)
+		}
+	`)
+	want := "2 EUR <nil>\n" +
+		`3 GBP element Price at line 1, column 55: Currency: "GBP" is not one of the allowed values`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestSchemaDigest(t *testing.T) {
+	const (
+		orders = `<schema xmlns="http://www.w3.org/2001/XMLSchema" xmlns:tns="http://www.example.com/" targetNamespace="http://www.example.com/">
+		  <!-- orders -->
+		  <complexType name="Order">
+		    <sequence>
+		      <element name="id" type="string" />
+		      <element name="item" type="tns:Item" maxOccurs="unbounded" />
+		    </sequence>
+		  </complexType>
+		</schema>`
+		// The same schema, with other prefixes, attribute order
+		// and white space, and no comment.
+		ordersPrefixed = `<xs:schema targetNamespace="http://www.example.com/" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:o="http://www.example.com/">
+		  <xs:complexType name="Order"><xs:sequence>
+		    <xs:element type="xs:string" name="id"/>
+		    <xs:element maxOccurs="unbounded" name="item" type="o:Item"/>
+		  </xs:sequence></xs:complexType>
+		</xs:schema>`
+		ordersChanged = `<schema xmlns="http://www.w3.org/2001/XMLSchema" xmlns:tns="http://www.example.com/" targetNamespace="http://www.example.com/">
+		  <complexType name="Order">
+		    <sequence>
+		      <element name="id" type="int" />
+		      <element name="item" type="tns:Item" maxOccurs="unbounded" />
+		    </sequence>
+		  </complexType>
+		</schema>`
+		items = `<schema xmlns="http://www.w3.org/2001/XMLSchema" targetNamespace="http://www.example.com/">
+		  <complexType name="Item">
+		    <annotation><documentation>An item.</documentation></annotation>
+		    <attribute name="sku" type="string" />
+		  </complexType>
+		</schema>`
+	)
+	digest := func(docs ...string) string {
+		data := make([][]byte, 0, len(docs))
+		for _, doc := range docs {
+			data = append(data, []byte(doc))
+		}
+		d, err := SchemaDigest(data...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	want := digest(orders, items)
+	if !strings.HasPrefix(want, "sha256:") {
+		t.Errorf("digest %s does not start with sha256:", want)
+	}
+	if got := digest(items, orders); got != want {
+		t.Errorf("digest changed with the order of the documents: %s != %s", got, want)
+	}
+	if got := digest(ordersPrefixed, items); got != want {
+		t.Errorf("digest changed with prefixes and formatting: %s != %s", got, want)
+	}
+	if got := digest(ordersChanged, items); got == want {
+		t.Errorf("digest did not change with the type of an element")
+	}
+	if got := digest(orders, strings.Replace(items, "An item.", "A line item.", 1)); got == want {
+		t.Errorf("digest did not change with documentation")
+	}
+
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for name, doc := range map[string]string{"orders.xsd": orders, "items.xsd": items} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(doc), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filename)
+	}
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitSchemaDigest())
+	src, err := cfg.GenSource(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decl := fmt.Sprintf("const SchemaDigest = %q", want); !bytes.Contains(src, []byte(decl)) {
+		t.Errorf("generated source does not declare %s:\n%s", decl, src)
+	}
+}
+
+func TestSharedComplexType(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	src := testSource(t, &cfg, `<import namespace="http://example.com/customers" />`, "testdata/customers.xsd")
+	if n := bytes.Count(src, []byte("type AddressType struct")); n != 1 {
+		t.Errorf("AddressType is declared %d times, want 1:\n%s", n, src)
+	}
+	for typ, want := range map[string]map[string]string{
+		"CustomerType": {
+			"BillingAddress":    "AddressType",
+			"ShippingAddresses": "[]AddressType",
+		},
+		"DirectoryType": {
+			"Address": "[]AddressType",
+		},
+	} {
+		fields := structFields(t, src, typ)
+		for name, wantType := range want {
+			if got := strings.Fields(fields[name]); len(got) == 0 || got[0] != wantType {
+				t.Errorf("%s.%s is %q, want type %s", typ, name, fields[name], wantType)
+			}
+		}
+	}
+
+	out := testRun(t, &cfg, `<import namespace="http://example.com/customers" />`, `
+		doc := `+"`"+`<customer xmlns="http://example.com/customers"><name>Ann</name>`+
+		`<billingAddress><street>1 High St</street><city>Leeds</city></billingAddress>`+
+		`<shippingAddresses><street>2 Low Rd</street><city>York</city></shippingAddresses>`+
+		`<shippingAddresses><street>3 Mill Ln</street><city>Hull</city></shippingAddresses>`+
+		`</customer>`+"`"+`
+		var v CustomerType
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			panic(err)
+		}
+		fmt.Println(v.BillingAddress.City)
+		for _, a := range v.ShippingAddresses {
+			fmt.Println(a.City)
+		}
+	`, "testdata/customers.xsd")
+	if want := "Leeds\nYork\nHull"; out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestVisitor(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitVisitor())
+	out := testRun(t, &cfg, `
+	  <complexType name="Party">
+	    <sequence>
+	      <element name="name" type="xs:string" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Customer">
+	    <complexContent>
+	      <extension base="tns:Party">
+	        <sequence>
+	          <element name="address" type="tns:Address" maxOccurs="unbounded" />
+	          <element name="referrer" type="tns:Referral" minOccurs="0" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="line" type="xs:string" maxOccurs="unbounded" />
+	    </sequence>
+	    <attribute name="kind" type="xs:string" />
+	  </complexType>
+	  <complexType name="Referral">
+	    <sequence>
+	      <element name="code" type="xs:string" />
+	      <element name="referral" type="tns:Referral" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>`, `
+		v := Customer{
+			Party:    Party{Name: "Ann"},
+			Address:  []Address{{Kind: "home", Line: []string{"1 High St", "Leeds"}}, {Kind: "work"}},
+			Referrer: Referral{Code: "a", Referral: []Referral{{Code: "b"}}},
+		}
+		v.Walk(func(path string, v interface{}) {
+			if path == "" {
+				path = "-"
+			}
+			if s, ok := v.(*string); ok {
+				fmt.Printf("%s=%s\n", path, *s)
+				*s = "x"
+			} else {
+				fmt.Printf("%s %T\n", path, v)
+			}
+		})
+		fmt.Println(v.Name, v.Address[0].Line[1], v.Referrer.Referral[0].Code)
+	`)
+	want := `- *main.Customer
+Name=Ann
+Address *[]main.Address
+Address[0].Kind=home
+Address[0].Line *[]string
+Address[0].Line[0]=1 High St
+Address[0].Line[1]=Leeds
+Address[1].Kind=work
+Address[1].Line *[]string
+Referrer *main.Referral
+Referrer.Code=a
+Referrer.Referral *[]main.Referral
+Referrer.Referral[0].Code=b
+Referrer.Referral[0].Referral *[]main.Referral
+x x x`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestNonNilSlices(t *testing.T) {
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(NonNilSlices(), EmitBuilders())
+	out := testRun(t, &cfg, `
+	  <complexType name="Party">
+	    <sequence>
+	      <element name="id" type="xs:int" />
+	      <element name="alias" type="xs:string" maxOccurs="unbounded" />
+	    </sequence>
+	  </complexType>
+	  <complexType name="Customer">
+	    <complexContent>
+	      <extension base="tns:Party">
+	        <sequence>
+	          <element name="name" type="xs:string" />
+	          <element name="address" type="tns:Address" />
+	          <element name="order" type="xs:string" maxOccurs="unbounded" />
+	        </sequence>
+	      </extension>
+	    </complexContent>
+	  </complexType>
+	  <complexType name="Address">
+	    <sequence>
+	      <element name="line" type="xs:string" maxOccurs="unbounded" />
+	      <element name="city" type="xs:string" />
+	    </sequence>
+	  </complexType>`, `
+		for _, v := range []*Customer{NewCustomer(), func() *Customer { v := NewCustomerBuilder().Build(); return &v }()} {
+			fmt.Println(v.Alias != nil, len(v.Alias), v.Order != nil, len(v.Order), v.Address.Line != nil, len(v.Address.Line))
+			data, err := json.Marshal(v)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(data))
+		}
+		v := NewCustomer()
+		if err := xml.Unmarshal([]byte(`+"`"+`<customer xmlns="http://www.example.com/"><order>a</order></customer>`+"`"+`), v); err != nil {
+			panic(err)
+		}
+		fmt.Println(v.Alias != nil, v.Order)
+	`)
+	const encoded = `{"Id":0,"Alias":[],"Name":"","Address":{"Line":[],"City":""},"Order":[]}`
+	want := "true 0 true 0 true 0\n" + encoded + "\n" +
+		"true 0 true 0 true 0\n" + encoded + "\n" +
+		"true [a]"
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestForwardReferences(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/forward.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same schema, with its top-level declarations in reverse
+	// order, so that no reference is to a later declaration.
+	root, err := xmltree.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, j := 0, len(root.Children)-1; i < j; i, j = i+1, j-1 {
+		root.Children[i], root.Children[j] = root.Children[j], root.Children[i]
+	}
+	reversed, err := xmltree.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "xsdgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reversedFile := filepath.Join(dir, "forward.xsd")
+	if err := ioutil.WriteFile(reversedFile, reversed, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	const schema = `<import namespace="http://example.com/forward" />`
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitEnumConstants())
+	src := testSource(t, &cfg, schema, "testdata/forward.xsd")
+	if back := testSource(t, &cfg, schema, reversedFile); !bytes.Equal(src, back) {
+		t.Errorf("the order of declarations changes the generated code:\n%s\nreversed:\n%s", src, back)
+	}
+	want := map[string]string{
+		"Base":     "",
+		"ShipTo":   "Address",
+		"Note":     "Note",
+		"Priority": "Priority",
+		"Line":     "[]Line",
+		"Status":   "Priority",
+	}
+	fields := structFields(t, src, "Order")
+	for name, typ := range want {
+		got, ok := fields[name]
+		if !ok {
+			t.Errorf("Order has no field %s:\n%s", name, src)
+		} else if typ != "" && strings.Fields(got)[0] != typ {
+			t.Errorf("Order.%s is %q, want type %s", name, got, typ)
+		}
+	}
+	if got := strings.Fields(structFields(t, src, "Note")["Value"]); len(got) == 0 || got[0] != "Priority" {
+		t.Errorf("Note.Value is %q, want type Priority", got)
+	}
+}
+
+func TestNotations(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/notations.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := xsd.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range schema {
+		if s.TargetNS != "http://example.com/media" {
+			continue
+		}
+		gif := s.Notations[xml.Name{Space: "http://example.com/media", Local: "gif"}]
+		png := s.Notations[xml.Name{Space: "http://example.com/media", Local: "png"}]
+		if gif.Public != "image/gif" || gif.Doc != "Graphics Interchange Format" || png.System != "viewer.exe" {
+			t.Errorf("notations are not parsed: %+v", s.Notations)
+		}
+	}
+
+	var cfg Config
+	cfg.Option(DefaultOptions...)
+	cfg.Option(EmitValidators())
+	out := testRun(t, &cfg, `<import namespace="http://example.com/media" />`, `
+		for _, format := range []xsdNotation{"gif", "m:png", "", "jpeg"} {
+			v := Figure{Caption: "A chart", Format: format}
+			fmt.Println(v.Validate())
+		}
+	`, "testdata/notations.xsd")
+	want := "<nil>\n<nil>\n<nil>\n" + `Format: "jpeg" is not a declared notation`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}